@@ -0,0 +1,148 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+// AntiEntropy selects what peers exchange on their periodic push/pull.
+type AntiEntropy int
+
+const (
+	// AntiEntropyFull sends every state in full.
+	AntiEntropyFull AntiEntropy = iota
+	// AntiEntropyDigest sends digests of states implementing BucketState
+	// instead, as long as all members are known to understand them. The
+	// receiver sends the entries of the buckets which differ back to the
+	// sender. Peers without digest support keep receiving full states.
+	AntiEntropyDigest
+)
+
+func (a AntiEntropy) String() string {
+	switch a {
+	case AntiEntropyFull:
+		return "full"
+	case AntiEntropyDigest:
+		return "digest"
+	default:
+		return fmt.Sprintf("AntiEntropy(%d)", int(a))
+	}
+}
+
+const (
+	// DigestBuckets is the number of buckets states are summarized in.
+	DigestBuckets = 64
+	// maxDigestBuckets limits the buckets accepted from remote peers.
+	maxDigestBuckets = 4096
+)
+
+// BucketState is a state which supports anti-entropy by digest. Its entries
+// are spread over n buckets, e.g. with BucketOf.
+type BucketState interface {
+	State
+	// BucketDigests returns a digest of the entries of each of the n
+	// buckets. Peers holding the same entries must return the same digests.
+	BucketDigests(n int) ([][]byte, error)
+	// MarshalBuckets serializes the entries of the given buckets in the
+	// format accepted by Merge.
+	MarshalBuckets(n int, buckets []int) ([]byte, error)
+}
+
+// BucketOf returns the bucket of n the entry with the given key belongs to.
+func BucketOf(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// sendDivergent sends the entries of all buckets which differ from the
+// digests of a remote peer to that peer.
+func (d *delegate) sendDivergent(from string, digests []clusterpb.StateDigest) {
+	var node *memberlist.Node
+	for _, n := range d.mlist.Members() {
+		if n.Name == from {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		d.logger.Debug("digests of unknown peer", "peer", from)
+		return
+	}
+
+	for _, sd := range digests {
+		b, err := d.divergentBuckets(sd)
+		if err != nil {
+			d.logger.Warn("compare digests", "err", err, "key", sd.Key, "peer", from)
+			continue
+		}
+		if len(b) == 0 {
+			continue
+		}
+		part := clusterpb.Part{Key: sd.Key, Data: b}
+		if d.compress && d.hasFeature(from, acceptsFlate) {
+			if err := compressPart(&part); err != nil {
+				d.logger.Warn("compress divergent buckets", "err", err, "key", sd.Key)
+				continue
+			}
+		}
+		msg, err := proto.Marshal(&part)
+		if err != nil {
+			d.logger.Warn("encode divergent buckets", "err", err, "key", sd.Key)
+			continue
+		}
+		if err := d.mlist.SendReliable(node, msg); err != nil {
+			d.logger.Debug("failed to send divergent buckets", "err", err, "key", sd.Key, "peer", from)
+		}
+	}
+}
+
+// divergentBuckets returns the serialized local entries of the buckets which
+// differ from the remote digest. It is empty if the state is unknown or has
+// no entries in those buckets.
+func (d *delegate) divergentBuckets(sd clusterpb.StateDigest) ([]byte, error) {
+	n := len(sd.Buckets)
+	if n == 0 || n > maxDigestBuckets {
+		return nil, fmt.Errorf("invalid number of buckets %d", n)
+	}
+	d.mtx.RLock()
+	s, ok := d.states[sd.Key].(BucketState)
+	d.mtx.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	local, err := s.BucketDigests(n)
+	if err != nil {
+		return nil, err
+	}
+	var divergent []int
+	for i := range local {
+		if !bytes.Equal(local[i], sd.Buckets[i]) {
+			divergent = append(divergent, i)
+		}
+	}
+	if len(divergent) == 0 {
+		return nil, nil
+	}
+	return s.MarshalBuckets(n, divergent)
+}
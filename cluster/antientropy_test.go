@@ -0,0 +1,173 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+// mapState is a BucketState of string entries which merge by union.
+type mapState struct {
+	mtx     sync.Mutex
+	entries map[string]string
+}
+
+func (s *mapState) MarshalBinary() ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return json.Marshal(s.entries)
+}
+
+func (s *mapState) Merge(b []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	maps.Copy(s.entries, m)
+	return nil
+}
+
+func (s *mapState) BucketDigests(n int) ([][]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	hashes := make([][]byte, n)
+	for _, k := range slices.Sorted(maps.Keys(s.entries)) {
+		i := BucketOf(k, n)
+		sum := sha256.Sum256(append(hashes[i], k+"="+s.entries[k]...))
+		hashes[i] = sum[:]
+	}
+	return hashes, nil
+}
+
+func (s *mapState) MarshalBuckets(n int, buckets []int) ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	m := map[string]string{}
+	for k, v := range s.entries {
+		if slices.Contains(buckets, BucketOf(k, n)) {
+			m[k] = v
+		}
+	}
+	return json.Marshal(m)
+}
+
+func decodeFullState(t *testing.T, b []byte) (parts []string, fs clusterpb.FullState) {
+	t.Helper()
+	require.NoError(t, proto.Unmarshal(b, &fs))
+	for _, p := range fs.Parts {
+		parts = append(parts, p.Key)
+	}
+	slices.Sort(parts)
+	return parts, fs
+}
+
+func TestLocalStateAntiEntropy(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		mode    AntiEntropy
+		join    bool
+		parts   []string
+		digests bool
+	}{
+		{name: "full", mode: AntiEntropyFull, parts: []string{"bucket", "plain"}},
+		{name: "digest on join", mode: AntiEntropyDigest, join: true, parts: []string{"bucket", "plain"}, digests: true},
+		{name: "digest", mode: AntiEntropyDigest, parts: []string{"plain"}, digests: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.AntiEntropy = tc.mode
+			p := newTestPeer(t, o)
+			p.AddState("bucket", &mapState{entries: map[string]string{"a": "1"}})
+			p.AddState("plain", bytesState("plain"))
+
+			parts, fs := decodeFullState(t, p.delegate.LocalState(tc.join))
+			require.Equal(t, tc.parts, parts)
+			require.Equal(t, p.Name(), fs.From)
+			if !tc.digests {
+				require.Empty(t, fs.Digests)
+				return
+			}
+			require.Len(t, fs.Digests, 1)
+			require.Equal(t, "bucket", fs.Digests[0].Key)
+			require.Len(t, fs.Digests[0].Buckets, DigestBuckets)
+		})
+	}
+}
+
+func TestLocalStateUntilAllUnderstandDigests(t *testing.T) {
+	o := testOptions()
+	o.AntiEntropy = AntiEntropyDigest
+	p := newTestPeer(t, o)
+	p.AddState("bucket", &mapState{entries: map[string]string{}})
+	o2 := testOptions()
+	o2.AntiEntropy = AntiEntropyFull
+	p2 := newTestPeer(t, o2)
+	_, err := p.mlist.Join([]string{p2.Self().Address()})
+	require.NoError(t, err)
+
+	// The other member never sent digests, it gets the full state.
+	parts, _ := decodeFullState(t, p.delegate.LocalState(false))
+	require.Equal(t, []string{"bucket"}, parts)
+
+	p.delegate.setFeatures(&clusterpb.FullState{From: p2.Name(), Digests: []clusterpb.StateDigest{{Key: "bucket"}}})
+	parts, _ = decodeFullState(t, p.delegate.LocalState(false))
+	require.Empty(t, parts)
+
+	p.delegate.forgetPeer(p2.Name())
+	parts, _ = decodeFullState(t, p.delegate.LocalState(false))
+	require.Equal(t, []string{"bucket"}, parts)
+}
+
+func TestDivergentBuckets(t *testing.T) {
+	o := testOptions()
+	o.AntiEntropy = AntiEntropyDigest
+	p := newTestPeer(t, o)
+	local := &mapState{entries: map[string]string{"a": "1", "b": "2", "c": "3"}}
+	p.AddState("bucket", local)
+	remote := &mapState{entries: map[string]string{"a": "1", "b": "old"}}
+	buckets, err := remote.BucketDigests(DigestBuckets)
+	require.NoError(t, err)
+
+	b, err := p.delegate.divergentBuckets(clusterpb.StateDigest{Key: "bucket", Buckets: buckets})
+	require.NoError(t, err)
+	require.NoError(t, remote.Merge(b))
+	require.Equal(t, local.entries, remote.entries)
+	// Only the divergent buckets were sent.
+	var sent map[string]string
+	require.NoError(t, json.Unmarshal(b, &sent))
+	require.NotContains(t, sent, "a")
+
+	buckets, err = remote.BucketDigests(DigestBuckets)
+	require.NoError(t, err)
+	b, err = p.delegate.divergentBuckets(clusterpb.StateDigest{Key: "bucket", Buckets: buckets})
+	require.NoError(t, err)
+	require.Empty(t, b)
+	b, err = p.delegate.divergentBuckets(clusterpb.StateDigest{Key: "unknown", Buckets: buckets})
+	require.NoError(t, err)
+	require.Empty(t, b)
+	_, err = p.delegate.divergentBuckets(clusterpb.StateDigest{Key: "bucket"})
+	require.Error(t, err)
+}
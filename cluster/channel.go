@@ -16,30 +16,35 @@ package cluster
 import (
 	"log/slog"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/hashicorp/memberlist"
 
-	"github.com/prometheus/alertmanager/cluster/clusterpb"
+	"github.com/SoloJacobs/am/cluster/clusterpb"
 )
 
 // Channel allows clients to send messages for a specific state type that will be
 // broadcasted in a best-effort manner.
 type Channel struct {
 	key          string
-	send         func([]byte)
+	send         func(name string, b []byte)
+	pressure     func() float64
 	peers        func() []*memberlist.Node
 	sendOversize func(*memberlist.Node, []byte) error
 
-	msgc   chan []byte
-	logger *slog.Logger
+	msgc             chan []byte
+	droppedOversized atomic.Uint64
+	logger           *slog.Logger
 }
 
 // NewChannel creates a new Channel struct, which handles sending normal and
-// oversize messages to peers.
+// oversize messages to peers. send queues normal messages for gossip and
+// pressure returns how full that queue is, it may be nil.
 func NewChannel(
 	key string,
-	send func([]byte),
+	send func(name string, b []byte),
+	pressure func() float64,
 	peers func() []*memberlist.Node,
 	sendOversize func(*memberlist.Node, []byte) error,
 	logger *slog.Logger,
@@ -48,6 +53,7 @@ func NewChannel(
 	c := &Channel{
 		key:          key,
 		send:         send,
+		pressure:     pressure,
 		peers:        peers,
 		logger:       logger,
 		msgc:         make(chan []byte, 200),
@@ -86,20 +92,40 @@ func (c *Channel) handleOverSizedMessages(stopc <-chan struct{}) {
 
 // Broadcast enqueues a message for broadcasting.
 func (c *Channel) Broadcast(b []byte) {
+	c.BroadcastNamed("", b)
+}
+
+// BroadcastNamed enqueues a message for broadcasting which replaces queued
+// messages of the same name sent through this channel. Messages without a
+// name don't replace any other message. Oversized messages aren't queued and
+// can't be replaced.
+func (c *Channel) BroadcastNamed(name string, b []byte) {
 	b, err := proto.Marshal(&clusterpb.Part{Key: c.key, Data: b})
 	if err != nil {
 		return
 	}
 
-	if OversizedMessage(b) {
-		select {
-		case c.msgc <- b:
-		default:
-			c.logger.Debug("oversized gossip channel full")
-		}
-	} else {
-		c.send(b)
+	if !OversizedMessage(b) {
+		c.send(name, b)
+		return
+	}
+	select {
+	case c.msgc <- b:
+	default:
+		c.droppedOversized.Add(1)
+	}
+}
+
+// Pressure returns a value between 0 and 1 for how close broadcasts are to
+// being dropped. At 1, the next broadcast of the channel or of a state with a
+// lower priority is dropped. Callers may send fewer or larger messages
+// instead.
+func (c *Channel) Pressure() float64 {
+	p := float64(len(c.msgc)) / float64(cap(c.msgc))
+	if c.pressure != nil {
+		p = max(p, c.pressure())
 	}
+	return min(p, 1)
 }
 
 // OversizedMessage indicates whether or not the byte payload should be sent
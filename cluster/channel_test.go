@@ -20,14 +20,18 @@ import (
 	"os"
 	"testing"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/hashicorp/memberlist"
 	"github.com/prometheus/common/promslog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
 )
 
 func TestNormalMessagesGossiped(t *testing.T) {
 	var sent bool
 	c := newChannel(
-		func(_ []byte) { sent = true },
+		func(string, []byte) { sent = true },
 		func() []*memberlist.Node { return nil },
 		func(_ *memberlist.Node, _ []byte) error { return nil },
 	)
@@ -43,7 +47,7 @@ func TestOversizedMessagesGossiped(t *testing.T) {
 	var sent bool
 	ctx, cancel := context.WithCancel(context.Background())
 	c := newChannel(
-		func(_ []byte) {},
+		func(string, []byte) {},
 		func() []*memberlist.Node { return []*memberlist.Node{{}} },
 		func(_ *memberlist.Node, _ []byte) error { sent = true; cancel(); return nil },
 	)
@@ -72,16 +76,78 @@ func TestOversizedMessagesGossiped(t *testing.T) {
 }
 
 func newChannel(
-	send func([]byte),
+	send func(string, []byte),
 	peers func() []*memberlist.Node,
 	sendOversize func(*memberlist.Node, []byte) error,
 ) *Channel {
 	return NewChannel(
 		"test",
 		send,
+		nil,
 		peers,
 		sendOversize,
 		promslog.NewNopLogger(),
 		make(chan struct{}),
 	)
 }
+
+func TestNamedMessagesInvalidate(t *testing.T) {
+	q := newBroadcastQueue(func() int { return 3 }, 3, DefaultMaxQueuedBroadcasts, nil)
+	newQueueChannel := func(key string) *Channel {
+		return NewChannel(
+			key,
+			func(name string, b []byte) { q.queue(key, name, b) },
+			q.pressure,
+			func() []*memberlist.Node { return nil },
+			func(_ *memberlist.Node, _ []byte) error { return nil },
+			promslog.NewNopLogger(),
+			make(chan struct{}),
+		)
+	}
+	a, b := newQueueChannel("a"), newQueueChannel("b")
+
+	a.BroadcastNamed("x", []byte("old"))
+	a.BroadcastNamed("x", []byte("new"))
+	a.BroadcastNamed("y", []byte("other"))
+	b.BroadcastNamed("x", []byte("other state"))
+	a.Broadcast([]byte("unnamed"))
+	a.Broadcast([]byte("unnamed"))
+	require.Equal(t, BroadcastStats{Queued: 4, Superseded: 1}, q.statsByKey()["a"])
+
+	var data []string
+	for _, msg := range q.getBroadcasts(0, 1<<20) {
+		var p clusterpb.Part
+		require.NoError(t, proto.Unmarshal(msg, &p))
+		data = append(data, p.Key+":"+string(p.Data))
+	}
+	require.ElementsMatch(t, []string{"a:new", "a:other", "b:other state", "a:unnamed", "a:unnamed"}, data)
+}
+
+func TestOversizedMessagesDropped(t *testing.T) {
+	sending, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	c := newChannel(
+		func(string, []byte) {},
+		func() []*memberlist.Node { return []*memberlist.Node{{}} },
+		func(_ *memberlist.Node, _ []byte) error {
+			select {
+			case sending <- struct{}{}:
+			default:
+			}
+			<-release
+			return nil
+		},
+	)
+	oversized := make([]byte, MaxGossipPacketSize)
+
+	// The first message blocks the sender, the next ones fill the buffer.
+	c.Broadcast(oversized)
+	<-sending
+	for range cap(c.msgc) {
+		c.Broadcast(oversized)
+	}
+	require.Equal(t, 1.0, c.Pressure())
+	require.Zero(t, c.droppedOversized.Load())
+	c.Broadcast(oversized)
+	require.EqualValues(t, 1, c.droppedOversized.Load())
+}
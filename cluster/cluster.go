@@ -25,12 +25,15 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/coder/quartz"
 	"github.com/hashicorp/memberlist"
 	"github.com/oklog/ulid/v2"
 )
@@ -51,11 +54,19 @@ type ClusterMember interface {
 	Name() string
 	// Address returns the IP address of the node
 	Address() string
+	// Meta returns what the node announced about itself.
+	Meta() NodeMeta
 }
 
 // ClusterChannel supports state broadcasting across peers.
 type ClusterChannel interface {
 	Broadcast([]byte)
+	// BroadcastNamed broadcasts a message which supersedes the queued
+	// messages of the same name which weren't fully gossiped yet.
+	BroadcastNamed(name string, b []byte)
+	// Pressure returns a value between 0 and 1 for how close broadcasts
+	// are to being dropped.
+	Pressure() float64
 }
 
 // Peer is a single peer in a gossip cluster.
@@ -66,19 +77,42 @@ type Peer struct {
 	resolvedPeers       []string
 	resolvePeersTimeout time.Duration
 
-	mtx    sync.RWMutex
-	states map[string]State
-	stopc  chan struct{}
-	readyc chan struct{}
+	mtx      sync.RWMutex
+	states   map[string]State
+	channels map[string]*Channel
+	stopc    chan struct{}
+	readyc   chan struct{}
+
+	settlePolicy SettlePolicy
+	settleMtx    sync.Mutex
+	settleStatus SettleStatus
+
+	events *eventHub
 
 	peerLock    sync.RWMutex
 	peers       map[string]peer
 	failedPeers []peer
 
-	knownPeers    []string
+	discoverer    Discoverer
 	advertiseAddr string
 
-	logger *slog.Logger
+	logger      *slog.Logger
+	clock       quartz.Clock
+	antiEntropy AntiEntropy
+	compress    bool
+	retries     *retryQueue
+
+	strictStateKeys bool
+	merges          *mergeTracker
+
+	version   string
+	role      string
+	zone      string
+	startTime time.Time
+	leaving   atomic.Bool
+	// selfAddr is the address of the peer itself. memberlist rewrites the
+	// node of the peer when its meta is updated, so it isn't read from there.
+	selfAddr string
 }
 
 // peer is an internal type used for bookkeeping. It holds the state of peers
@@ -86,6 +120,8 @@ type Peer struct {
 type peer struct {
 	status    PeerStatus
 	leaveTime time.Time
+	// joined is false for the initially failed peers until they first join.
+	joined bool
 
 	*memberlist.Node
 }
@@ -122,66 +158,74 @@ const (
 	DefaultReconnectTimeout    = 6 * time.Hour
 	DefaultRefreshInterval     = 15 * time.Second
 	DefaultResolvePeersTimeout = 15 * time.Second
+	DefaultMaxQueuedBroadcasts = 4096
+	DefaultRetryInterval       = 10 * time.Second
+	DefaultRetryAttempts       = 5
+	DefaultRetryQueueSize      = 100
 	MaxGossipPacketSize        = 1400
 )
 
-func Create(
-	l *slog.Logger,
-	bindAddr string,
-	advertiseAddr string,
-	knownPeers []string,
-	waitIfEmpty bool,
-	pushPullInterval time.Duration,
-	gossipInterval time.Duration,
-	tcpTimeout time.Duration,
-	resolveTimeout time.Duration,
-	probeTimeout time.Duration,
-	probeInterval time.Duration,
-	tlsTransportConfig *TLSTransportConfig,
-	allowInsecureAdvertise bool,
-	label string,
-	name string,
-) (*Peer, error) {
-	bindHost, bindPortStr, err := net.SplitHostPort(bindAddr)
+// leaveAnnounceTimeout bounds announcing the leave when Leave has no timeout.
+const leaveAnnounceTimeout = time.Second
+
+// Create creates a new peer from the given options.
+func Create(o Options) (*Peer, error) {
+	o = o.withDefaults()
+	if err := o.validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	l := o.Logger
+
+	bindHost, bindPortStr, err := net.SplitHostPort(o.BindAddr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid listen address: %w", err)
 	}
 	bindPort, err := strconv.Atoi(bindPortStr)
 	if err != nil {
-		return nil, fmt.Errorf("address %s: invalid port: %w", bindAddr, err)
+		return nil, fmt.Errorf("address %s: invalid port: %w", o.BindAddr, err)
 	}
 
 	var advertiseHost string
 	var advertisePort int
-	if advertiseAddr != "" {
+	if o.AdvertiseAddr != "" {
 		var advertisePortStr string
-		advertiseHost, advertisePortStr, err = net.SplitHostPort(advertiseAddr)
+		advertiseHost, advertisePortStr, err = net.SplitHostPort(o.AdvertiseAddr)
 		if err != nil {
 			return nil, fmt.Errorf("invalid advertise address: %w", err)
 		}
 		advertisePort, err = strconv.Atoi(advertisePortStr)
 		if err != nil {
-			return nil, fmt.Errorf("address %s: invalid port: %w", advertiseAddr, err)
+			return nil, fmt.Errorf("address %s: invalid port: %w", o.AdvertiseAddr, err)
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), o.ResolvePeersTimeout)
 	defer cancel()
-	resolvedPeers, err := resolvePeers(ctx, knownPeers, advertiseAddr, &net.Resolver{}, waitIfEmpty)
-	if err != nil {
-		return nil, fmt.Errorf("resolve peers: %w", err)
+	var resolvedPeers []string
+	discoverer := o.Discoverer
+	if discoverer == nil {
+		discoverer = StaticDiscoverer{Peers: o.KnownPeers}
+		resolvedPeers, err = resolvePeers(ctx, o.KnownPeers, o.AdvertiseAddr, &net.Resolver{}, o.WaitIfEmpty)
+		if err != nil {
+			return nil, fmt.Errorf("resolve peers: %w", err)
+		}
+	} else {
+		resolvedPeers, err = discoverPeers(ctx, discoverer, o.WaitIfEmpty)
+		if err != nil {
+			return nil, fmt.Errorf("discover peers: %w", err)
+		}
 	}
 	l.Debug("resolved peers to following addresses", "peers", strings.Join(resolvedPeers, ","))
 
 	// Initial validation of user-specified advertise address.
-	addr, err := calculateAdvertiseAddress(bindHost, advertiseHost, allowInsecureAdvertise)
+	addr, err := calculateAdvertiseAddress(bindHost, advertiseHost, o.AllowInsecureAdvertise)
 	if err != nil {
 		l.Warn("couldn't deduce an advertise address: " + err.Error())
 	} else if hasNonlocal(resolvedPeers) && isUnroutable(addr.String()) {
 		l.Warn("this node advertises itself on an unroutable address", "addr", addr.String())
 		l.Warn("this node will be unreachable in the cluster")
 		l.Warn("provide --cluster.advertise-address as a routable IP address or hostname")
-	} else if isAny(bindAddr) && advertiseHost == "" {
+	} else if isAny(o.BindAddr) && advertiseHost == "" {
 		// memberlist doesn't advertise properly when the bind address is empty or unspecified.
 		l.Info("setting advertise address explicitly", "addr", addr.String(), "port", bindPort)
 		advertiseHost = addr.String()
@@ -189,30 +233,48 @@ func Create(
 	}
 
 	// Generate a random name if none is provided.
-	if name == "" {
+	if o.Name == "" {
 		id, err := ulid.New(ulid.Now(), rand.Reader)
 		if err != nil {
 			return nil, err
 		}
-		name = id.String()
+		o.Name = id.String()
 	}
 
 	p := &Peer{
 		states:              map[string]State{},
+		channels:            map[string]*Channel{},
 		stopc:               make(chan struct{}),
 		readyc:              make(chan struct{}),
+		settlePolicy:        o.SettlePolicy,
+		events:              newEventHub(l),
+		settleStatus:        SettleStatus{Policy: o.SettlePolicy.Name(), Outcome: SettleOutcomeSettling},
 		logger:              l,
 		peers:               map[string]peer{},
 		resolvedPeers:       resolvedPeers,
-		resolvePeersTimeout: resolveTimeout,
-		knownPeers:          knownPeers,
+		resolvePeersTimeout: o.ResolvePeersTimeout,
+		discoverer:          discoverer,
+		clock:               o.Clock,
+		antiEntropy:         o.AntiEntropy,
+		compress:            o.Compress,
+		strictStateKeys:     o.StrictStateKeys,
+		merges:              newMergeTracker(),
+		version:             o.Version,
+		role:                o.Role,
+		zone:                o.Zone,
+		startTime:           o.Clock.Now(),
 	}
 
-	retransmit := max(len(knownPeers)/2, 3)
-	p.delegate = newDelegate(l, p, retransmit)
+	p.retries = newRetryQueue(p.sendReliable, p.otherMembers, o)
+
+	retransmit := max(len(o.KnownPeers)/2, 3)
+	if o.Discoverer != nil {
+		retransmit = max(len(resolvedPeers)/2, 3)
+	}
+	p.delegate = newDelegate(l, p, newBroadcastQueue(p.ClusterSize, retransmit, o.MaxQueuedBroadcasts, o.Priorities))
 
 	cfg := memberlist.DefaultLANConfig()
-	cfg.Name = name
+	cfg.Name = o.Name
 	cfg.BindAddr = bindHost
 	cfg.BindPort = bindPort
 	cfg.Delegate = p.delegate
@@ -220,27 +282,30 @@ func Create(
 	cfg.Alive = p.delegate
 	cfg.Events = p.delegate
 	cfg.Conflict = p.delegate
-	cfg.GossipInterval = gossipInterval
-	cfg.PushPullInterval = pushPullInterval
-	cfg.TCPTimeout = tcpTimeout
-	cfg.ProbeTimeout = probeTimeout
-	cfg.ProbeInterval = probeInterval
+	cfg.GossipInterval = o.GossipInterval
+	cfg.PushPullInterval = o.PushPullInterval
+	cfg.TCPTimeout = o.TCPTimeout
+	cfg.ProbeTimeout = o.ProbeTimeout
+	cfg.ProbeInterval = o.ProbeInterval
 	cfg.Logger = slog.NewLogLogger(l.Handler(), slog.LevelDebug)
 	cfg.GossipNodes = retransmit
 	cfg.UDPBufferSize = MaxGossipPacketSize
-	cfg.Label = label
+	cfg.Label = o.Label
 
 	if advertiseHost != "" {
 		cfg.AdvertiseAddr = advertiseHost
 		cfg.AdvertisePort = advertisePort
 		p.setInitialFailed(resolvedPeers, fmt.Sprintf("%s:%d", advertiseHost, advertisePort))
 	} else {
-		p.setInitialFailed(resolvedPeers, bindAddr)
+		p.setInitialFailed(resolvedPeers, o.BindAddr)
 	}
 
-	if tlsTransportConfig != nil {
+	if o.Transport != nil {
+		cfg.Transport = o.Transport
+	}
+	if o.TLSTransportConfig != nil {
 		l.Info("using TLS for gossip")
-		cfg.Transport, err = NewTLSTransport(context.Background(), l, cfg.BindAddr, cfg.BindPort, tlsTransportConfig)
+		cfg.Transport, err = NewTLSTransport(context.Background(), l, cfg.BindAddr, cfg.BindPort, o.TLSTransportConfig)
 		if err != nil {
 			return nil, fmt.Errorf("tls transport: %w", err)
 		}
@@ -251,6 +316,8 @@ func Create(
 		return nil, fmt.Errorf("create memberlist: %w", err)
 	}
 	p.mlist = ml
+	p.selfAddr = ml.LocalNode().Address()
+	go p.retries.run(p.stopc)
 	return p, nil
 }
 
@@ -270,20 +337,26 @@ func (p *Peer) Join(
 
 	if reconnectInterval != 0 {
 		go p.runPeriodicTask(
+			"reconnect",
 			reconnectInterval,
 			p.reconnect,
 		)
 	}
 	if reconnectTimeout != 0 {
 		go p.runPeriodicTask(
+			"removeFailedPeers",
 			5*time.Minute,
 			func() { p.removeFailedPeers(reconnectTimeout) },
 		)
 	}
 	go p.runPeriodicTask(
+		"refresh",
 		DefaultRefreshInterval,
 		p.refresh,
 	)
+	if w, ok := p.discoverer.(Watcher); ok {
+		go p.refreshOnChange(w.Watch(p.stopc))
+	}
 
 	return err
 }
@@ -298,7 +371,7 @@ func (p *Peer) setInitialFailed(peers []string, myAddr string) {
 	p.peerLock.Lock()
 	defer p.peerLock.Unlock()
 
-	now := time.Now()
+	now := p.clock.Now()
 	for _, peerAddr := range peers {
 		if peerAddr == myAddr {
 			// Don't add ourselves to the initially failing list,
@@ -333,8 +406,10 @@ func (p *Peer) setInitialFailed(peers []string, myAddr string) {
 	}
 }
 
-func (p *Peer) runPeriodicTask(d time.Duration, f func()) {
-	tick := time.NewTicker(d)
+// runPeriodicTask calls f every d until the peer stops. The ticker is tagged
+// with "Peer" and name.
+func (p *Peer) runPeriodicTask(name string, d time.Duration, f func()) {
+	tick := p.clock.NewTicker(d, "Peer", name)
 	defer tick.Stop()
 
 	for {
@@ -349,21 +424,28 @@ func (p *Peer) runPeriodicTask(d time.Duration, f func()) {
 
 func (p *Peer) removeFailedPeers(timeout time.Duration) {
 	p.peerLock.Lock()
-	defer p.peerLock.Unlock()
-
-	now := time.Now()
+	now := p.clock.Now()
 
+	var removed []string
 	keep := make([]peer, 0, len(p.failedPeers))
 	for _, pr := range p.failedPeers {
 		if pr.leaveTime.Add(timeout).After(now) {
 			keep = append(keep, pr)
 		} else {
 			p.logger.Debug("failed peer has timed out", "peer", pr.Node, "addr", pr.Address())
-			delete(p.peers, pr.Name)
+			delete(p.peers, pr.Address())
+			removed = append(removed, pr.Address())
+			p.publishNode(EventRemoved, pr.Node)
 		}
 	}
 
 	p.failedPeers = keep
+	p.peerLock.Unlock()
+
+	// The messages which failed to reach the peers won't be retried.
+	for _, addr := range removed {
+		p.retries.peerRemoved(addr)
+	}
 }
 
 func (p *Peer) reconnect() {
@@ -389,9 +471,9 @@ func (p *Peer) refresh() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), p.resolvePeersTimeout)
 	defer cancel()
-	resolvedPeers, err := resolvePeers(ctx, p.knownPeers, p.advertiseAddr, &net.Resolver{}, false)
+	resolvedPeers, err := p.discoverer.Discover(ctx)
 	if err != nil {
-		logger.Debug(fmt.Sprintf("%v", p.knownPeers), "err", err)
+		logger.Debug("failed to discover peers", "err", err)
 		return
 	}
 
@@ -415,6 +497,18 @@ func (p *Peer) refresh() {
 	}
 }
 
+// refreshOnChange refreshes whenever the discovered peers changed.
+func (p *Peer) refreshOnChange(changed <-chan struct{}) {
+	for {
+		select {
+		case <-p.stopc:
+			return
+		case <-changed:
+			p.refresh()
+		}
+	}
+}
+
 func (p *Peer) peerJoin(n *memberlist.Node) {
 	p.peerLock.Lock()
 	defer p.peerLock.Unlock()
@@ -425,20 +519,29 @@ func (p *Peer) peerJoin(n *memberlist.Node) {
 		oldStatus = StatusNone
 		pr = peer{
 			status: StatusAlive,
-			Node:   n,
+			Node:   copyNode(n),
 		}
 	} else {
 		oldStatus = pr.status
-		pr.Node = n
+		pr.Node = copyNode(n)
 		pr.status = StatusAlive
 		pr.leaveTime = time.Time{}
 	}
+	rejoined := pr.joined
+	pr.joined = true
 
 	p.peers[n.Address()] = pr
 
 	if oldStatus == StatusFailed {
 		p.logger.Debug("peer rejoined", "peer", pr.Node)
 		p.failedPeers = removeOldPeer(p.failedPeers, pr.Address())
+		p.retries.peerJoined(pr.Address())
+	}
+	switch {
+	case !rejoined:
+		p.publishNode(EventJoined, n)
+	case oldStatus == StatusFailed:
+		p.publishNode(EventRejoined, n)
 	}
 }
 
@@ -454,11 +557,16 @@ func (p *Peer) peerLeave(n *memberlist.Node) {
 	}
 
 	pr.status = StatusFailed
-	pr.leaveTime = time.Now()
+	pr.leaveTime = p.clock.Now()
 	p.failedPeers = append(p.failedPeers, pr)
 	p.peers[n.Address()] = pr
 
 	p.logger.Debug("peer left", "peer", pr.Node)
+	if leftOnPurpose(n) {
+		p.publishNode(EventLeft, n)
+	} else {
+		p.publishNode(EventFailed, n)
+	}
 }
 
 func (p *Peer) peerUpdate(n *memberlist.Node) {
@@ -472,10 +580,11 @@ func (p *Peer) peerUpdate(n *memberlist.Node) {
 		return
 	}
 
-	pr.Node = n
+	pr.Node = copyNode(n)
 	p.peers[n.Address()] = pr
 
 	p.logger.Debug("peer updated", "peer", pr.Node)
+	p.publishNode(EventUpdated, n)
 }
 
 // AddState adds a new state that will be gossiped. It returns a channel to which
@@ -485,29 +594,99 @@ func (p *Peer) AddState(key string, s State) ClusterChannel {
 	p.states[key] = s
 	p.mtx.Unlock()
 
-	send := func(b []byte) {
-		p.delegate.bcast.QueueBroadcast(simpleBroadcast(b))
+	send := func(name string, b []byte) {
+		p.delegate.bcast.queue(key, name, b)
 	}
-	peers := func() []*memberlist.Node {
-		nodes := p.mlist.Members()
-		for i, n := range nodes {
-			if n.String() == p.Self().Name {
-				nodes = append(nodes[:i], nodes[i+1:]...)
-				break
-			}
+	sendOversize := func(n *memberlist.Node, b []byte) error {
+		return p.retries.sendReliable(key, n, b)
+	}
+	c := NewChannel(key, send, p.delegate.bcast.pressure, p.otherMembers, sendOversize, p.logger, p.stopc)
+	p.mtx.Lock()
+	p.channels[key] = c
+	p.mtx.Unlock()
+
+	// Announce the new state key.
+	go func() {
+		if err := p.mlist.UpdateNode(DefaultTCPTimeout); err != nil {
+			p.logger.Debug("failed to update node meta", "err", err)
+		}
+	}()
+	return c
+}
+
+// sendReliable sends a serialized part to n over TCP. It is compressed if
+// enabled and n accepts it.
+func (p *Peer) sendReliable(n *memberlist.Node, b []byte) error {
+	if p.compress && p.delegate.hasFeature(n.Name, acceptsFlate) {
+		var err error
+		if b, err = compressMessage(b); err != nil {
+			return err
 		}
-		return nodes
 	}
-	sendOversize := func(n *memberlist.Node, b []byte) error {
-		return p.mlist.SendReliable(n, b)
+	return p.mlist.SendReliable(n, b)
+}
+
+// otherMembers returns copies of all alive members except the peer itself.
+// The nodes returned by memberlist change while they are in use.
+func (p *Peer) otherMembers() []*memberlist.Node {
+	self := p.Name()
+	p.peerLock.RLock()
+	defer p.peerLock.RUnlock()
+	var nodes []*memberlist.Node
+	for _, pr := range p.peers {
+		if pr.status == StatusAlive && pr.Name != self {
+			nodes = append(nodes, copyNode(pr.Node))
+		}
 	}
-	return NewChannel(key, send, peers, sendOversize, p.logger, p.stopc)
+	return nodes
+}
+
+// copyNode returns a copy of n. memberlist updates its nodes in place, it
+// only holds its lock while calling the delegate.
+func copyNode(n *memberlist.Node) *memberlist.Node {
+	c := *n
+	return &c
+}
+
+// BroadcastStats returns the counters of the broadcasts of all states by their
+// key.
+func (p *Peer) BroadcastStats() map[string]BroadcastStats {
+	stats := p.delegate.bcast.statsByKey()
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	for key, c := range p.channels {
+		s, ok := stats[key]
+		if !ok {
+			s.Priority = p.delegate.bcast.priorities[key]
+		}
+		s.DroppedOversized = c.droppedOversized.Load()
+		stats[key] = s
+	}
+	return stats
 }
 
 // Leave the cluster, waiting up to timeout.
 func (p *Peer) Leave(timeout time.Duration) error {
 	close(p.stopc)
+	defer p.events.close()
 	p.logger.Debug("leaving cluster")
+	// Announce the leave first, the others can't tell it from a failure
+	// otherwise. The announcement takes up to half of the timeout, the
+	// leave gets the rest. Without a timeout, only the leave may block.
+	// memberlist takes a timeout of 0 as none, so the split ones are kept
+	// positive.
+	deadline := time.Now().Add(timeout)
+	announce := leaveAnnounceTimeout
+	if timeout > 0 {
+		announce = max(timeout/2, time.Nanosecond)
+	}
+	p.leaving.Store(true)
+	if err := p.mlist.UpdateNode(announce); err != nil {
+		p.logger.Warn("failed to announce leaving the cluster", "err", err)
+	}
+	if timeout > 0 {
+		timeout = max(time.Until(deadline), time.Nanosecond)
+	}
 	return p.mlist.Leave(timeout)
 }
 
@@ -541,7 +720,8 @@ func (p *Peer) WaitReady(ctx context.Context) error {
 	}
 }
 
-// Return a status string representing the peer state.
+// Return a status string representing the peer state: "ready" or "settling".
+// SettleStatus returns the settle policy, its outcome and the reason.
 func (p *Peer) Status() string {
 	if p.Ready() {
 		return "ready"
@@ -559,6 +739,7 @@ func (p *Peer) Info() map[string]any {
 	return map[string]any{
 		"self":    p.mlist.LocalNode(),
 		"members": p.mlist.Members(),
+		"settle":  p.SettleStatus(),
 	}
 }
 
@@ -578,14 +759,28 @@ func (m Member) Name() string { return m.node.Name }
 // Address implements cluster.ClusterMember.
 func (m Member) Address() string { return m.node.Address() }
 
+// Meta implements cluster.ClusterMember. It is empty if the meta of the node
+// is invalid.
+func (m Member) Meta() NodeMeta {
+	meta, _ := decodeNodeMeta(m.node.Meta)
+	return meta
+}
+
 // Peers returns the peers in the cluster.
 func (p *Peer) Peers() []ClusterMember {
-	peers := make([]ClusterMember, 0, len(p.mlist.Members()))
-	for _, member := range p.mlist.Members() {
-		peers = append(peers, Member{
-			node: member,
-		})
+	// The copies kept by the peer are read, as the meta of the nodes
+	// returned by memberlist may change while it is decoded.
+	p.peerLock.RLock()
+	defer p.peerLock.RUnlock()
+	peers := make([]ClusterMember, 0, len(p.peers))
+	for _, pr := range p.peers {
+		if pr.status == StatusAlive {
+			peers = append(peers, Member{
+				node: pr.Node,
+			})
+		}
 	}
+	slices.SortFunc(peers, func(a, b ClusterMember) int { return strings.Compare(a.Name(), b.Name()) })
 	return peers
 }
 
@@ -611,39 +806,47 @@ func (p *Peer) Position() int {
 // Inspired from https://github.com/apache/cassandra/blob/7a40abb6a5108688fb1b10c375bb751cbb782ea4/src/java/org/apache/cassandra/gms/Gossiper.java
 // This is clearly not perfect or strictly correct but should prevent the alertmanager to send notification before it is obviously not ready.
 // This is especially important for those that do not have persistent storage.
+//
+// Every interval, the settle policy of the peer decides whether it has
+// settled. If ctx is done first, the peer becomes ready anyway.
 func (p *Peer) Settle(ctx context.Context, interval time.Duration) {
-	const NumOkayRequired = 3
-	p.logger.Info("Waiting for gossip to settle...", "interval", interval)
-	start := time.Now()
-	nPeers := 0
-	nOkay := 0
-	totalPolls := 0
+	policy := p.settlePolicy
+	p.logger.Info("Waiting for gossip to settle...", "interval", interval, "policy", policy.Name())
+	start := p.clock.Now()
+	status := SettleStatus{Policy: policy.Name(), Outcome: SettleOutcomeSettling}
 	for {
+		timer := p.clock.NewTimer(interval, "Peer", "Settle")
 		select {
 		case <-ctx.Done():
-			elapsed := time.Since(start)
-			p.logger.Info("gossip not settled but continuing anyway", "polls", totalPolls, "elapsed", elapsed)
+			timer.Stop()
+			status.Elapsed = p.clock.Since(start)
+			status.Outcome = SettleOutcomeGaveUp
+			p.setSettleStatus(status)
+			p.logger.Info("gossip not settled but continuing anyway", "polls", status.Polls, "elapsed", status.Elapsed, "reason", status.Reason)
 			close(p.readyc)
+			p.publishSettled(status)
 			return
-		case <-time.After(interval):
+		case <-timer.C:
 		}
-		elapsed := time.Since(start)
-		n := len(p.Peers())
-		if nOkay >= NumOkayRequired {
-			p.logger.Info("gossip settled; proceeding", "elapsed", elapsed)
+		status.Elapsed = p.clock.Since(start)
+		settled, reason := policy.Settled(p.settleState(status.Polls, status.Elapsed))
+		status.Reason = reason
+		status.Polls++
+		if settled {
+			status.Outcome = SettleOutcomeSettled
+			p.setSettleStatus(status)
+			p.logger.Info("gossip settled; proceeding", "elapsed", status.Elapsed, "reason", reason)
 			break
 		}
-		if n == nPeers {
-			nOkay++
-			p.logger.Debug("gossip looks settled", "elapsed", elapsed)
-		} else {
-			nOkay = 0
-			p.logger.Info("gossip not settled", "polls", totalPolls, "before", nPeers, "now", n, "elapsed", elapsed)
-		}
-		nPeers = n
-		totalPolls++
+		p.setSettleStatus(status)
+		p.logger.Debug("gossip not settled", "polls", status.Polls, "elapsed", status.Elapsed, "reason", reason)
 	}
 	close(p.readyc)
+	p.publishSettled(status)
+}
+
+func (p *Peer) publishSettled(s SettleStatus) {
+	p.events.publish(Event{Type: EventSettled, Time: p.clock.Now(), Name: p.Name(), Address: p.selfAddr, Settle: &s})
 }
 
 // State is a piece of state that can be serialized and merged with other
@@ -656,13 +859,6 @@ type State interface {
 	Merge(b []byte) error
 }
 
-// We use a simple broadcast implementation in which items are never invalidated by others.
-type simpleBroadcast []byte
-
-func (b simpleBroadcast) Message() []byte                       { return []byte(b) }
-func (b simpleBroadcast) Invalidates(memberlist.Broadcast) bool { return false }
-func (b simpleBroadcast) Finished()                             {}
-
 func resolvePeers(ctx context.Context, peers []string, myAddress string, res *net.Resolver, waitIfEmpty bool) ([]string, error) {
 	var resolvedPeers []string
 
@@ -15,10 +15,13 @@ package cluster
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/coder/quartz"
 	"github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/memberlist"
 	"github.com/stretchr/testify/require"
 
 	"github.com/prometheus/common/promslog"
@@ -41,24 +44,7 @@ func TestClusterJoinAndReconnect(t *testing.T) {
 }
 
 func testJoinLeave(t *testing.T) {
-	logger := promslog.NewNopLogger()
-	p, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		"",
-	)
+	p, err := Create(testOptions())
 	require.NoError(t, err)
 	require.NotNil(t, p)
 	err = p.Join(
@@ -78,23 +64,9 @@ func testJoinLeave(t *testing.T) {
 	require.Equal(t, "ready", p.Status())
 
 	// Create the peer who joins the first.
-	p2, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{p.Self().Address()},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		"",
-	)
+	o2 := testOptions()
+	o2.KnownPeers = []string{p.Self().Address()}
+	p2, err := Create(o2)
 	require.NoError(t, err)
 	require.NotNil(t, p2)
 	err = p2.Join(
@@ -114,24 +86,7 @@ func testJoinLeave(t *testing.T) {
 }
 
 func testReconnect(t *testing.T) {
-	logger := promslog.NewNopLogger()
-	p, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		"",
-	)
+	p, err := Create(testOptions())
 	require.NoError(t, err)
 	require.NotNil(t, p)
 	err = p.Join(
@@ -142,23 +97,7 @@ func testReconnect(t *testing.T) {
 	go p.Settle(context.Background(), 0*time.Second)
 	require.NoError(t, p.WaitReady(context.Background()))
 
-	p2, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		"",
-	)
+	p2, err := Create(testOptions())
 	require.NoError(t, err)
 	require.NotNil(t, p2)
 	err = p2.Join(
@@ -183,24 +122,7 @@ func testReconnect(t *testing.T) {
 }
 
 func testRemoveFailedPeers(t *testing.T) {
-	logger := promslog.NewNopLogger()
-	p, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		"",
-	)
+	p, err := Create(testOptions())
 	require.NoError(t, err)
 	require.NotNil(t, p)
 	err = p.Join(
@@ -234,26 +156,9 @@ func testRemoveFailedPeers(t *testing.T) {
 }
 
 func testInitiallyFailingPeers(t *testing.T) {
-	logger := promslog.NewNopLogger()
 	myAddr := "1.2.3.4:5000"
 	peerAddrs := []string{myAddr, "2.3.4.5:5000", "3.4.5.6:5000", "foo.example.com:5000"}
-	p, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		"",
-	)
+	p, err := Create(testOptions())
 	require.NoError(t, err)
 	require.NotNil(t, p)
 	err = p.Join(
@@ -283,26 +188,11 @@ func testInitiallyFailingPeers(t *testing.T) {
 }
 
 func testTLSConnection(t *testing.T) {
-	logger := promslog.NewNopLogger()
 	tlsTransportConfig1, err := GetTLSTransportConfig("./testdata/tls_config_node1.yml")
 	require.NoError(t, err)
-	p1, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		tlsTransportConfig1,
-		false,
-		"",
-		"",
-	)
+	o1 := testOptions()
+	o1.TLSTransportConfig = tlsTransportConfig1
+	p1, err := Create(o1)
 	require.NoError(t, err)
 	require.NotNil(t, p1)
 	err = p1.Join(
@@ -319,23 +209,10 @@ func testTLSConnection(t *testing.T) {
 	// Create the peer who joins the first.
 	tlsTransportConfig2, err := GetTLSTransportConfig("./testdata/tls_config_node2.yml")
 	require.NoError(t, err)
-	p2, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{p1.Self().Address()},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		tlsTransportConfig2,
-		false,
-		"",
-		"",
-	)
+	o2 := testOptions()
+	o2.KnownPeers = []string{p1.Self().Address()}
+	o2.TLSTransportConfig = tlsTransportConfig2
+	p2, err := Create(o2)
 	require.NoError(t, err)
 	require.NotNil(t, p2)
 	err = p2.Join(
@@ -357,24 +234,9 @@ func testTLSConnection(t *testing.T) {
 
 func testPeerNames(t *testing.T, name1, name2 string) {
 	t.Helper()
-	logger := promslog.NewNopLogger()
-	p1, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		name1,
-	)
+	o1 := testOptions()
+	o1.Name = name1
+	p1, err := Create(o1)
 	require.NoError(t, err)
 	require.NotNil(t, p1)
 	err = p1.Join(
@@ -394,23 +256,10 @@ func testPeerNames(t *testing.T, name1, name2 string) {
 	require.Equal(t, "ready", p1.Status())
 
 	// Create the peer who joins the first.
-	p2, err := Create(
-		logger,
-		"127.0.0.1:0",
-		"",
-		[]string{p1.Self().Address()},
-		true,
-		DefaultPushPullInterval,
-		DefaultGossipInterval,
-		DefaultTCPTimeout,
-		DefaultResolvePeersTimeout,
-		DefaultProbeTimeout,
-		DefaultProbeInterval,
-		nil,
-		false,
-		"",
-		name2,
-	)
+	o2 := testOptions()
+	o2.KnownPeers = []string{p1.Self().Address()}
+	o2.Name = name2
+	p2, err := Create(o2)
 	require.NoError(t, err)
 	require.NotNil(t, p2)
 	err = p2.Join(
@@ -427,3 +276,112 @@ func testPeerNames(t *testing.T, name1, name2 string) {
 		require.NotEqual(t, p1.Name(), p2.Name(), "peers should have different names")
 	}
 }
+
+// testOptions returns the default options of a peer listening on a random
+// local port.
+func testOptions() Options {
+	o := DefaultOptions()
+	o.Logger = promslog.NewNopLogger()
+	o.BindAddr = "127.0.0.1:0"
+	o.WaitIfEmpty = true
+	return o
+}
+
+// newTestPeer creates a peer from o, which leaves the cluster when the test
+// finishes unless the test left already.
+func newTestPeer(t *testing.T, o Options) *Peer {
+	t.Helper()
+	p, err := Create(o)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		select {
+		case <-p.stopc:
+		default:
+			p.Leave(0)
+		}
+	})
+	return p
+}
+
+func TestSettleWithMockClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clock := quartz.NewMock(t)
+	trap := clock.Trap().NewTimer("Peer", "Settle")
+	defer trap.Close()
+
+	o := testOptions()
+	o.Clock = clock
+	p := newTestPeer(t, o)
+	go p.Settle(ctx, time.Minute)
+
+	// The first poll sees a change from 0 to 1 peers, the following three
+	// polls confirm it and the fifth poll finishes settling.
+	for range 5 {
+		require.False(t, p.Ready())
+		trap.MustWait(ctx).MustRelease(ctx)
+		clock.Advance(time.Minute).MustWait(ctx)
+	}
+	require.NoError(t, p.WaitReady(ctx))
+	require.Equal(t, "ready", p.Status())
+	require.Equal(t, SettleStatus{
+		Policy:  "stable-peer-count",
+		Outcome: SettleOutcomeSettled,
+		Reason:  "1 members for 3 polls",
+		Polls:   5,
+		Elapsed: 5 * time.Minute,
+	}, p.SettleStatus())
+}
+
+func TestRemoveFailedPeersWithMockClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clock := quartz.NewMock(t)
+	start := clock.Now()
+
+	o := testOptions()
+	o.Clock = clock
+	p := newTestPeer(t, o)
+	p.setInitialFailed([]string{"2.3.4.5:5000"}, "1.2.3.4:5000")
+	failedPeers := func() int {
+		p.peerLock.RLock()
+		defer p.peerLock.RUnlock()
+		return len(p.failedPeers)
+	}
+	require.Equal(t, 1, failedPeers())
+	require.Equal(t, start, p.failedPeers[0].leaveTime)
+
+	// Reconnecting is disabled, it would dial the failed peer.
+	trap := clock.Trap().NewTicker("Peer", "removeFailedPeers")
+	require.NoError(t, p.Join(0, DefaultReconnectTimeout))
+	trap.MustWait(ctx).MustRelease(ctx)
+	trap.Close()
+
+	// Step through every tick until the failed peer expired.
+	for clock.Since(start) < DefaultReconnectTimeout {
+		require.Equal(t, 1, failedPeers(), "peer removed after %v", clock.Since(start))
+		_, w := clock.AdvanceNext()
+		w.MustWait(ctx)
+	}
+	require.Eventually(t, func() bool { return failedPeers() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPeerLeaveWithMockClock(t *testing.T) {
+	clock := quartz.NewMock(t)
+	o := testOptions()
+	o.Clock = clock
+	p := newTestPeer(t, o)
+	n := &memberlist.Node{Name: "other", Addr: net.ParseIP("2.3.4.5"), Port: 5000}
+	p.peerJoin(n)
+
+	clock.Advance(time.Hour)
+	p.peerLeave(n)
+	require.Len(t, p.failedPeers, 1)
+	require.Equal(t, clock.Now(), p.failedPeers[0].leaveTime)
+
+	p.removeFailedPeers(time.Hour)
+	require.Len(t, p.failedPeers, 1)
+	clock.Advance(time.Hour)
+	p.removeFailedPeers(time.Hour)
+	require.Empty(t, p.failedPeers)
+}
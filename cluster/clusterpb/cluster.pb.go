@@ -24,6 +24,32 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 
+// Encoding of the data of a part.
+type Encoding int32
+
+const (
+	Encoding_NONE  Encoding = 0
+	Encoding_FLATE Encoding = 1
+)
+
+var Encoding_name = map[int32]string{
+	0: "NONE",
+	1: "FLATE",
+}
+
+var Encoding_value = map[string]int32{
+	"NONE":  0,
+	"FLATE": 1,
+}
+
+func (x Encoding) String() string {
+	return proto.EnumName(Encoding_name, int32(x))
+}
+
+func (Encoding) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_3cfb3b8ec240c376, []int{0}
+}
+
 type MemberlistMessage_Kind int32
 
 const (
@@ -46,12 +72,15 @@ func (x MemberlistMessage_Kind) String() string {
 }
 
 func (MemberlistMessage_Kind) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_3cfb3b8ec240c376, []int{2, 0}
+	return fileDescriptor_3cfb3b8ec240c376, []int{3, 0}
 }
 
 type Part struct {
-	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Data                 []byte   `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Key  string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// Encoding of the data. Older peers don't know the field, so parts are
+	// only encoded for peers which accept the encoding.
+	Encoding             Encoding `protobuf:"varint,3,opt,name=encoding,proto3,enum=clusterpb.Encoding" json:"encoding,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -91,10 +120,18 @@ func (m *Part) XXX_DiscardUnknown() {
 var xxx_messageInfo_Part proto.InternalMessageInfo
 
 type FullState struct {
-	Parts                []Part   `protobuf:"bytes,1,rep,name=parts,proto3" json:"parts"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Parts []Part `protobuf:"bytes,1,rep,name=parts,proto3" json:"parts"`
+	// Name of the sending peer. It is only set by peers which understand
+	// digests, older peers send parts only.
+	From string `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	// Digests of states which support anti-entropy by digest. A state is
+	// either sent in parts or as digest, or both.
+	Digests []StateDigest `protobuf:"bytes,3,rep,name=digests,proto3" json:"digests"`
+	// Encodings of parts the sender accepts besides NONE.
+	AcceptEncodings      []Encoding `protobuf:"varint,4,rep,packed,name=accept_encodings,json=acceptEncodings,proto3,enum=clusterpb.Encoding" json:"accept_encodings,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
 }
 
 func (m *FullState) Reset()         { *m = FullState{} }
@@ -130,6 +167,49 @@ func (m *FullState) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_FullState proto.InternalMessageInfo
 
+// StateDigest summarizes a state by the digests of its entries, spread over
+// a fixed number of buckets. Peers only exchange the buckets which differ.
+type StateDigest struct {
+	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Buckets              [][]byte `protobuf:"bytes,2,rep,name=buckets,proto3" json:"buckets,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StateDigest) Reset()         { *m = StateDigest{} }
+func (m *StateDigest) String() string { return proto.CompactTextString(m) }
+func (*StateDigest) ProtoMessage()    {}
+func (*StateDigest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3cfb3b8ec240c376, []int{2}
+}
+func (m *StateDigest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *StateDigest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_StateDigest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *StateDigest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StateDigest.Merge(m, src)
+}
+func (m *StateDigest) XXX_Size() int {
+	return m.Size()
+}
+func (m *StateDigest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StateDigest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StateDigest proto.InternalMessageInfo
+
 type MemberlistMessage struct {
 	Version              string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
 	Kind                 MemberlistMessage_Kind `protobuf:"varint,2,opt,name=kind,proto3,enum=clusterpb.MemberlistMessage_Kind" json:"kind,omitempty"`
@@ -144,7 +224,7 @@ func (m *MemberlistMessage) Reset()         { *m = MemberlistMessage{} }
 func (m *MemberlistMessage) String() string { return proto.CompactTextString(m) }
 func (*MemberlistMessage) ProtoMessage()    {}
 func (*MemberlistMessage) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3cfb3b8ec240c376, []int{2}
+	return fileDescriptor_3cfb3b8ec240c376, []int{3}
 }
 func (m *MemberlistMessage) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -173,36 +253,108 @@ func (m *MemberlistMessage) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_MemberlistMessage proto.InternalMessageInfo
 
+// NodeMeta describes a peer to the others. It is sent with the alive messages
+// of the peer and must fit into 512 bytes.
+type NodeMeta struct {
+	// Format of the message, it changes on incompatible changes.
+	Format uint32 `protobuf:"varint,1,opt,name=format,proto3" json:"format,omitempty"`
+	// Build version of the peer.
+	Version string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	// Keys of the states the peer gossips.
+	StateKeys []string `protobuf:"bytes,3,rep,name=state_keys,json=stateKeys,proto3" json:"state_keys,omitempty"`
+	// Optional protocol features the peer supports.
+	Features []string `protobuf:"bytes,4,rep,name=features,proto3" json:"features,omitempty"`
+	// Start time of the peer in Unix milliseconds.
+	StartTime int64  `protobuf:"varint,5,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	Role      string `protobuf:"bytes,6,opt,name=role,proto3" json:"role,omitempty"`
+	Zone      string `protobuf:"bytes,7,opt,name=zone,proto3" json:"zone,omitempty"`
+	// Set by a peer which is about to leave the cluster on purpose.
+	Leaving              bool     `protobuf:"varint,8,opt,name=leaving,proto3" json:"leaving,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeMeta) Reset()         { *m = NodeMeta{} }
+func (m *NodeMeta) String() string { return proto.CompactTextString(m) }
+func (*NodeMeta) ProtoMessage()    {}
+func (*NodeMeta) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3cfb3b8ec240c376, []int{4}
+}
+func (m *NodeMeta) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *NodeMeta) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_NodeMeta.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *NodeMeta) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeMeta.Merge(m, src)
+}
+func (m *NodeMeta) XXX_Size() int {
+	return m.Size()
+}
+func (m *NodeMeta) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeMeta.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeMeta proto.InternalMessageInfo
+
 func init() {
+	proto.RegisterEnum("clusterpb.Encoding", Encoding_name, Encoding_value)
 	proto.RegisterEnum("clusterpb.MemberlistMessage_Kind", MemberlistMessage_Kind_name, MemberlistMessage_Kind_value)
 	proto.RegisterType((*Part)(nil), "clusterpb.Part")
 	proto.RegisterType((*FullState)(nil), "clusterpb.FullState")
+	proto.RegisterType((*StateDigest)(nil), "clusterpb.StateDigest")
 	proto.RegisterType((*MemberlistMessage)(nil), "clusterpb.MemberlistMessage")
+	proto.RegisterType((*NodeMeta)(nil), "clusterpb.NodeMeta")
 }
 
 func init() { proto.RegisterFile("cluster.proto", fileDescriptor_3cfb3b8ec240c376) }
 
 var fileDescriptor_3cfb3b8ec240c376 = []byte{
-	// 289 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x90, 0xdf, 0x4a, 0xc3, 0x30,
-	0x14, 0xc6, 0x17, 0x17, 0xa7, 0x3d, 0xfe, 0xab, 0xc1, 0x8b, 0xa0, 0x50, 0x67, 0xaf, 0x06, 0x4a,
-	0x85, 0x89, 0xe0, 0xed, 0x26, 0xf3, 0x66, 0x14, 0x46, 0xb7, 0x7b, 0x49, 0x4d, 0x2c, 0x65, 0x6d,
-	0x53, 0x92, 0x4c, 0xf0, 0xb9, 0x7c, 0x89, 0x5e, 0xfa, 0x04, 0xa2, 0x7d, 0x12, 0x49, 0x36, 0x45,
-	0xf0, 0xee, 0x77, 0x0e, 0x5f, 0x7e, 0x1f, 0x27, 0x70, 0xf0, 0x54, 0xac, 0xb4, 0x11, 0x2a, 0xaa,
-	0x95, 0x34, 0x92, 0x78, 0x9b, 0xb1, 0x4e, 0x4f, 0x4f, 0x32, 0x99, 0x49, 0xb7, 0xbd, 0xb6, 0xb4,
-	0x0e, 0x84, 0x57, 0x80, 0x67, 0x4c, 0x19, 0xe2, 0x43, 0x77, 0x29, 0x5e, 0x29, 0xea, 0xa3, 0x81,
-	0x97, 0x58, 0x24, 0x04, 0x30, 0x67, 0x86, 0xd1, 0xad, 0x3e, 0x1a, 0xec, 0x27, 0x8e, 0xc3, 0x3b,
-	0xf0, 0x1e, 0x56, 0x45, 0x31, 0x37, 0xcc, 0x08, 0x72, 0x09, 0xdb, 0x35, 0x53, 0x46, 0x53, 0xd4,
-	0xef, 0x0e, 0xf6, 0x86, 0x47, 0xd1, 0x6f, 0x57, 0x64, 0x95, 0x63, 0xdc, 0x7c, 0x9c, 0x77, 0x92,
-	0x75, 0x26, 0x7c, 0x43, 0x70, 0x1c, 0x8b, 0x32, 0x15, 0xaa, 0xc8, 0xb5, 0x89, 0x85, 0xd6, 0x2c,
-	0x13, 0x84, 0xc2, 0xce, 0x8b, 0x50, 0x3a, 0x97, 0xd5, 0xa6, 0xf9, 0x67, 0x24, 0xb7, 0x80, 0x97,
-	0x79, 0xc5, 0x5d, 0xfb, 0xe1, 0xf0, 0xe2, 0x8f, 0xfb, 0x9f, 0x25, 0x9a, 0xe6, 0x15, 0x4f, 0x5c,
-	0x9c, 0x9c, 0x81, 0xf7, 0xac, 0x64, 0xf9, 0xc8, 0x38, 0x57, 0xb4, 0xeb, 0x94, 0xbb, 0x76, 0x31,
-	0xe2, 0x5c, 0xd9, 0x1b, 0x4b, 0x9d, 0x51, 0xec, 0x0e, 0xb2, 0x18, 0x06, 0x80, 0xed, 0x63, 0x02,
-	0xd0, 0x9b, 0x2f, 0x92, 0xc9, 0x28, 0xf6, 0x3b, 0x96, 0x67, 0xa3, 0xfb, 0xe9, 0x64, 0xe1, 0xa3,
-	0xb1, 0xdf, 0x7c, 0x05, 0x9d, 0xa6, 0x0d, 0xd0, 0x7b, 0x1b, 0xa0, 0xcf, 0x36, 0x40, 0x69, 0xcf,
-	0x7d, 0xdb, 0xcd, 0x77, 0x00, 0x00, 0x00, 0xff, 0xff, 0xaa, 0x3f, 0xca, 0x45, 0x68, 0x01, 0x00,
-	0x00,
+	// 527 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x53, 0xcd, 0x8e, 0xd3, 0x3c,
+	0x14, 0xad, 0x27, 0x69, 0x9b, 0xdc, 0xf9, 0xcb, 0xe7, 0x0f, 0x8d, 0xac, 0x41, 0x74, 0x42, 0x56,
+	0x11, 0x48, 0x1d, 0xa9, 0x08, 0x24, 0x36, 0x48, 0x1d, 0xe8, 0x6c, 0x4a, 0xcb, 0xc8, 0xd3, 0x2d,
+	0xaa, 0xdc, 0xc6, 0x8d, 0xa2, 0xe6, 0xa7, 0xb2, 0xdd, 0x91, 0xca, 0x6b, 0xf1, 0x0a, 0x2c, 0xba,
+	0x64, 0xc9, 0x0a, 0x41, 0x9f, 0x04, 0xd9, 0x49, 0xaa, 0x22, 0x60, 0x77, 0xce, 0xcd, 0xb9, 0xe7,
+	0x1e, 0xfb, 0x3a, 0x70, 0x3a, 0x4f, 0xd7, 0x52, 0x71, 0xd1, 0x5d, 0x89, 0x42, 0x15, 0xd8, 0xad,
+	0xe8, 0x6a, 0x76, 0xf9, 0x28, 0x2e, 0xe2, 0xc2, 0x54, 0xaf, 0x35, 0x2a, 0x05, 0xc1, 0x47, 0xb0,
+	0xef, 0x98, 0x50, 0xd8, 0x03, 0x6b, 0xc9, 0x37, 0x04, 0xf9, 0x28, 0x74, 0xa9, 0x86, 0x18, 0x83,
+	0x1d, 0x31, 0xc5, 0xc8, 0x91, 0x8f, 0xc2, 0x13, 0x6a, 0x30, 0xbe, 0x06, 0x87, 0xe7, 0xf3, 0x22,
+	0x4a, 0xf2, 0x98, 0x58, 0x3e, 0x0a, 0xcf, 0x7a, 0xff, 0x77, 0xf7, 0x13, 0xba, 0x83, 0xea, 0x13,
+	0xdd, 0x8b, 0x82, 0x2f, 0x08, 0xdc, 0xdb, 0x75, 0x9a, 0xde, 0x2b, 0xa6, 0x38, 0x7e, 0x0e, 0xcd,
+	0x15, 0x13, 0x4a, 0x12, 0xe4, 0x5b, 0xe1, 0x71, 0xef, 0xfc, 0xa0, 0x57, 0x87, 0xb8, 0xb1, 0xb7,
+	0xdf, 0xaf, 0x1a, 0xb4, 0xd4, 0xe8, 0xf9, 0x0b, 0x51, 0x64, 0x66, 0xbe, 0x4b, 0x0d, 0xc6, 0xaf,
+	0xa0, 0x1d, 0x25, 0x31, 0x97, 0x4a, 0x12, 0xcb, 0x58, 0x5c, 0x1c, 0x58, 0x98, 0x19, 0xef, 0xcc,
+	0xe7, 0xca, 0xa9, 0x16, 0xe3, 0x37, 0xe0, 0xb1, 0xf9, 0x9c, 0xaf, 0xd4, 0xb4, 0x4e, 0x26, 0x89,
+	0xed, 0x5b, 0xff, 0xca, 0x7f, 0x5e, 0x8a, 0x6b, 0x2e, 0x83, 0xd7, 0x70, 0x7c, 0xe0, 0xfe, 0x97,
+	0xcb, 0x22, 0xd0, 0x9e, 0xad, 0xe7, 0x4b, 0xae, 0x24, 0x39, 0xf2, 0xad, 0xf0, 0x84, 0xd6, 0x34,
+	0xf8, 0x8c, 0xe0, 0xbf, 0x11, 0xcf, 0x66, 0x5c, 0xa4, 0x89, 0x54, 0x23, 0x2e, 0x25, 0x8b, 0xb9,
+	0xd6, 0x3f, 0x70, 0x21, 0x93, 0x22, 0xaf, 0x5c, 0x6a, 0x8a, 0x5f, 0x82, 0xbd, 0x4c, 0xf2, 0xc8,
+	0x1c, 0xfb, 0xac, 0xf7, 0xf4, 0x20, 0xde, 0x1f, 0x2e, 0xdd, 0x61, 0x92, 0x47, 0xd4, 0xc8, 0xf1,
+	0x63, 0x70, 0xf5, 0x0d, 0x4d, 0x59, 0x14, 0x09, 0xb3, 0x1a, 0x97, 0x3a, 0xba, 0xd0, 0x8f, 0x22,
+	0xa1, 0xf3, 0x66, 0x32, 0x26, 0xb6, 0xd9, 0xa4, 0x86, 0x41, 0x07, 0x6c, 0xdd, 0x8c, 0x01, 0x5a,
+	0xf7, 0x13, 0x3a, 0xe8, 0x8f, 0xbc, 0x86, 0xc6, 0x77, 0xfd, 0xb7, 0xc3, 0xc1, 0xc4, 0x43, 0xc1,
+	0x37, 0x04, 0xce, 0xb8, 0x88, 0xf8, 0x88, 0x2b, 0x86, 0x2f, 0xa0, 0xb5, 0x28, 0x44, 0xc6, 0x94,
+	0xc9, 0x7a, 0x4a, 0x2b, 0x76, 0x78, 0x88, 0xa3, 0xdf, 0x0f, 0xf1, 0x04, 0x40, 0xea, 0xfb, 0x9a,
+	0x2e, 0xf9, 0xa6, 0x5c, 0x95, 0x4b, 0x5d, 0x53, 0x19, 0xf2, 0x8d, 0xc4, 0x97, 0xe0, 0x2c, 0x38,
+	0x53, 0x6b, 0xc1, 0xcb, 0x35, 0xb8, 0x74, 0xcf, 0xab, 0x56, 0xa1, 0xa6, 0x2a, 0xc9, 0x38, 0x69,
+	0xfa, 0x28, 0xb4, 0x4c, 0xab, 0x50, 0x93, 0x24, 0xe3, 0xfa, 0x55, 0x88, 0x22, 0xe5, 0xa4, 0x55,
+	0xbe, 0x0a, 0x8d, 0x75, 0xed, 0x53, 0x91, 0x73, 0xd2, 0x2e, 0x6b, 0x1a, 0xeb, 0x6c, 0x29, 0x67,
+	0x0f, 0xfa, 0xa1, 0x3a, 0x3e, 0x0a, 0x1d, 0x5a, 0xd3, 0x67, 0x57, 0xe0, 0xd4, 0x8b, 0xc5, 0x0e,
+	0xd8, 0xe3, 0x0f, 0xe3, 0x81, 0xd7, 0xc0, 0x2e, 0x34, 0x6f, 0xdf, 0xf7, 0x27, 0x03, 0x0f, 0xdd,
+	0x78, 0xdb, 0x9f, 0x9d, 0xc6, 0x76, 0xd7, 0x41, 0x5f, 0x77, 0x1d, 0xf4, 0x63, 0xd7, 0x41, 0xb3,
+	0x96, 0xf9, 0x57, 0x5e, 0xfc, 0x1a, 0x00, 0x22, 0xf6, 0x18, 0xce, 0x5d, 0x03, 0x00, 0x00,
 }
 
 func (m *Part) Marshal() (dAtA []byte, err error) {
@@ -229,6 +381,11 @@ func (m *Part) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.Encoding != 0 {
+		i = encodeVarintCluster(dAtA, i, uint64(m.Encoding))
+		i--
+		dAtA[i] = 0x18
+	}
 	if len(m.Data) > 0 {
 		i -= len(m.Data)
 		copy(dAtA[i:], m.Data)
@@ -270,6 +427,45 @@ func (m *FullState) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if len(m.AcceptEncodings) > 0 {
+		dAtA2 := make([]byte, len(m.AcceptEncodings)*10)
+		var j1 int
+		for _, num := range m.AcceptEncodings {
+			for num >= 1<<7 {
+				dAtA2[j1] = uint8(uint64(num)&0x7f | 0x80)
+				num >>= 7
+				j1++
+			}
+			dAtA2[j1] = uint8(num)
+			j1++
+		}
+		i -= j1
+		copy(dAtA[i:], dAtA2[:j1])
+		i = encodeVarintCluster(dAtA, i, uint64(j1))
+		i--
+		dAtA[i] = 0x22
+	}
+	if len(m.Digests) > 0 {
+		for iNdEx := len(m.Digests) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Digests[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintCluster(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if len(m.From) > 0 {
+		i -= len(m.From)
+		copy(dAtA[i:], m.From)
+		i = encodeVarintCluster(dAtA, i, uint64(len(m.From)))
+		i--
+		dAtA[i] = 0x12
+	}
 	if len(m.Parts) > 0 {
 		for iNdEx := len(m.Parts) - 1; iNdEx >= 0; iNdEx-- {
 			{
@@ -287,6 +483,49 @@ func (m *FullState) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *StateDigest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *StateDigest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *StateDigest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Buckets) > 0 {
+		for iNdEx := len(m.Buckets) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Buckets[iNdEx])
+			copy(dAtA[i:], m.Buckets[iNdEx])
+			i = encodeVarintCluster(dAtA, i, uint64(len(m.Buckets[iNdEx])))
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if len(m.Key) > 0 {
+		i -= len(m.Key)
+		copy(dAtA[i:], m.Key)
+		i = encodeVarintCluster(dAtA, i, uint64(len(m.Key)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func (m *MemberlistMessage) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -340,6 +579,92 @@ func (m *MemberlistMessage) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *NodeMeta) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *NodeMeta) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *NodeMeta) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Leaving {
+		i--
+		if m.Leaving {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x40
+	}
+	if len(m.Zone) > 0 {
+		i -= len(m.Zone)
+		copy(dAtA[i:], m.Zone)
+		i = encodeVarintCluster(dAtA, i, uint64(len(m.Zone)))
+		i--
+		dAtA[i] = 0x3a
+	}
+	if len(m.Role) > 0 {
+		i -= len(m.Role)
+		copy(dAtA[i:], m.Role)
+		i = encodeVarintCluster(dAtA, i, uint64(len(m.Role)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if m.StartTime != 0 {
+		i = encodeVarintCluster(dAtA, i, uint64(m.StartTime))
+		i--
+		dAtA[i] = 0x28
+	}
+	if len(m.Features) > 0 {
+		for iNdEx := len(m.Features) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Features[iNdEx])
+			copy(dAtA[i:], m.Features[iNdEx])
+			i = encodeVarintCluster(dAtA, i, uint64(len(m.Features[iNdEx])))
+			i--
+			dAtA[i] = 0x22
+		}
+	}
+	if len(m.StateKeys) > 0 {
+		for iNdEx := len(m.StateKeys) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.StateKeys[iNdEx])
+			copy(dAtA[i:], m.StateKeys[iNdEx])
+			i = encodeVarintCluster(dAtA, i, uint64(len(m.StateKeys[iNdEx])))
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if len(m.Version) > 0 {
+		i -= len(m.Version)
+		copy(dAtA[i:], m.Version)
+		i = encodeVarintCluster(dAtA, i, uint64(len(m.Version)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if m.Format != 0 {
+		i = encodeVarintCluster(dAtA, i, uint64(m.Format))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintCluster(dAtA []byte, offset int, v uint64) int {
 	offset -= sovCluster(v)
 	base := offset
@@ -365,6 +690,9 @@ func (m *Part) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovCluster(uint64(l))
 	}
+	if m.Encoding != 0 {
+		n += 1 + sovCluster(uint64(m.Encoding))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -383,6 +711,45 @@ func (m *FullState) Size() (n int) {
 			n += 1 + l + sovCluster(uint64(l))
 		}
 	}
+	l = len(m.From)
+	if l > 0 {
+		n += 1 + l + sovCluster(uint64(l))
+	}
+	if len(m.Digests) > 0 {
+		for _, e := range m.Digests {
+			l = e.Size()
+			n += 1 + l + sovCluster(uint64(l))
+		}
+	}
+	if len(m.AcceptEncodings) > 0 {
+		l = 0
+		for _, e := range m.AcceptEncodings {
+			l += sovCluster(uint64(e))
+		}
+		n += 1 + sovCluster(uint64(l)) + l
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *StateDigest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Key)
+	if l > 0 {
+		n += 1 + l + sovCluster(uint64(l))
+	}
+	if len(m.Buckets) > 0 {
+		for _, b := range m.Buckets {
+			l = len(b)
+			n += 1 + l + sovCluster(uint64(l))
+		}
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -416,6 +783,51 @@ func (m *MemberlistMessage) Size() (n int) {
 	return n
 }
 
+func (m *NodeMeta) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Format != 0 {
+		n += 1 + sovCluster(uint64(m.Format))
+	}
+	l = len(m.Version)
+	if l > 0 {
+		n += 1 + l + sovCluster(uint64(l))
+	}
+	if len(m.StateKeys) > 0 {
+		for _, s := range m.StateKeys {
+			l = len(s)
+			n += 1 + l + sovCluster(uint64(l))
+		}
+	}
+	if len(m.Features) > 0 {
+		for _, s := range m.Features {
+			l = len(s)
+			n += 1 + l + sovCluster(uint64(l))
+		}
+	}
+	if m.StartTime != 0 {
+		n += 1 + sovCluster(uint64(m.StartTime))
+	}
+	l = len(m.Role)
+	if l > 0 {
+		n += 1 + l + sovCluster(uint64(l))
+	}
+	l = len(m.Zone)
+	if l > 0 {
+		n += 1 + l + sovCluster(uint64(l))
+	}
+	if m.Leaving {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
 func sovCluster(x uint64) (n int) {
 	return (math_bits.Len64(x|1) + 6) / 7
 }
@@ -517,6 +929,25 @@ func (m *Part) Unmarshal(dAtA []byte) error {
 				m.Data = []byte{}
 			}
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Encoding", wireType)
+			}
+			m.Encoding = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Encoding |= Encoding(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipCluster(dAtA[iNdEx:])
@@ -602,16 +1033,266 @@ func (m *FullState) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
-		default:
-			iNdEx = preIndex
-			skippy, err := skipCluster(dAtA[iNdEx:])
-			if err != nil {
-				return err
-			}
-			if (skippy < 0) || (iNdEx+skippy) < 0 {
-				return ErrInvalidLengthCluster
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field From", wireType)
 			}
-			if (iNdEx + skippy) > l {
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.From = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Digests", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Digests = append(m.Digests, StateDigest{})
+			if err := m.Digests[len(m.Digests)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType == 0 {
+				var v Encoding
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowCluster
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= Encoding(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				m.AcceptEncodings = append(m.AcceptEncodings, v)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowCluster
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= int(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthCluster
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex < 0 {
+					return ErrInvalidLengthCluster
+				}
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				var elementCount int
+				if elementCount != 0 && len(m.AcceptEncodings) == 0 {
+					m.AcceptEncodings = make([]Encoding, 0, elementCount)
+				}
+				for iNdEx < postIndex {
+					var v Encoding
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowCluster
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= Encoding(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.AcceptEncodings = append(m.AcceptEncodings, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field AcceptEncodings", wireType)
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCluster(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *StateDigest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCluster
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: StateDigest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: StateDigest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Buckets", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Buckets = append(m.Buckets, make([]byte, postIndex-iNdEx))
+			copy(m.Buckets[len(m.Buckets)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCluster(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if (iNdEx + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
 			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
@@ -792,6 +1473,275 @@ func (m *MemberlistMessage) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *NodeMeta) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCluster
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: NodeMeta: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: NodeMeta: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Format", wireType)
+			}
+			m.Format = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Format |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Version = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StateKeys", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.StateKeys = append(m.StateKeys, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Features", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Features = append(m.Features, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StartTime", wireType)
+			}
+			m.StartTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StartTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Role", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Role = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Zone", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCluster
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Zone = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Leaving", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCluster
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Leaving = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCluster(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCluster
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipCluster(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
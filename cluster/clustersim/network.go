@@ -0,0 +1,401 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clustersim
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/hashicorp/memberlist"
+)
+
+// Link controls the delivery of packets between two transports. Streams are
+// reliable and only affected by partitions.
+type Link struct {
+	// Delay of every packet in virtual time.
+	Delay time.Duration
+	// Jitter adds a random delay in [0, Jitter) to every packet, which
+	// reorders packets sent in short succession.
+	Jitter time.Duration
+	// DropRate is the probability of a packet being dropped.
+	DropRate float64
+}
+
+// NetworkStats counts the packets sent through a network.
+type NetworkStats struct {
+	Sent      int
+	Dropped   int
+	Delivered int
+	Dials     int
+	Refused   int
+	// StreamBytes counts the bytes written to streams.
+	StreamBytes int
+	// LargestStream is the most bytes written to one end of a stream.
+	LargestStream int
+}
+
+// Network connects in-memory transports. Delayed packets are queued until
+// Deliver is called after the virtual clock passed their delivery time. All
+// random decisions are drawn from a single seeded source.
+type Network struct {
+	clock quartz.Clock
+
+	mtx        sync.Mutex
+	rng        *rand.Rand
+	transports map[string]*Transport
+	link       Link
+	links      map[[2]string]Link
+	partition  map[string]int
+	pending    []packet
+	seq        uint64
+	stats      NetworkStats
+}
+
+type packet struct {
+	at   time.Time
+	seq  uint64
+	from string
+	to   string
+	buf  []byte
+}
+
+// NewNetwork returns an empty network using the given clock for delays and
+// seed for random decisions.
+func NewNetwork(clock quartz.Clock, seed int64) *Network {
+	return &Network{
+		clock:      clock,
+		rng:        rand.New(rand.NewSource(seed)),
+		transports: map[string]*Transport{},
+		links:      map[[2]string]Link{},
+		partition:  map[string]int{},
+	}
+}
+
+// SetLink sets the link between all pairs of transports without a link of
+// their own.
+func (n *Network) SetLink(l Link) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.link = l
+}
+
+// SetLinkBetween sets the link for packets from one address to another.
+func (n *Network) SetLinkBetween(from, to string, l Link) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.links[[2]string{from, to}] = l
+}
+
+// Partition splits the network into the given groups of addresses. Addresses
+// in different groups can't reach each other, addresses in no group can only
+// reach each other.
+func (n *Network) Partition(groups ...[]string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	clear(n.partition)
+	for i, g := range groups {
+		for _, addr := range g {
+			n.partition[addr] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Stats returns the packet counters of the network.
+func (n *Network) Stats() NetworkStats {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.stats
+}
+
+// NewTransport registers a transport listening on addr, which must be a
+// host:port with an IP.
+func (n *Network) NewTransport(addr string) (*Transport, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpAddr.IP == nil {
+		return nil, fmt.Errorf("address %s: IP required", addr)
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if _, ok := n.transports[tcpAddr.String()]; ok {
+		return nil, fmt.Errorf("address %s already in use", addr)
+	}
+	t := &Transport{
+		net:       n,
+		addr:      tcpAddr,
+		packetc:   make(chan *memberlist.Packet, 1024),
+		streamc:   make(chan net.Conn),
+		shutdownc: make(chan struct{}),
+	}
+	n.transports[tcpAddr.String()] = t
+	return t, nil
+}
+
+// Deliver delivers all queued packets which are due at the current time of
+// the clock.
+func (n *Network) Deliver() {
+	n.mtx.Lock()
+	now := n.clock.Now()
+	i := 0
+	for i < len(n.pending) && !n.pending[i].at.After(now) {
+		i++
+	}
+	due := slices.Clone(n.pending[:i])
+	n.pending = n.pending[i:]
+	n.mtx.Unlock()
+
+	for _, p := range due {
+		n.deliver(p)
+	}
+}
+
+// Pending returns the number of queued packets.
+func (n *Network) Pending() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return len(n.pending)
+}
+
+// nextDelivery returns the time until the next queued packet is due.
+func (n *Network) nextDelivery() (time.Duration, bool) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if len(n.pending) == 0 {
+		return 0, false
+	}
+	return max(n.clock.Until(n.pending[0].at), 0), true
+}
+
+// reachable must be called with the lock held.
+func (n *Network) reachable(from, to string) bool {
+	return n.partition[from] == n.partition[to]
+}
+
+func (n *Network) send(from, to string, b []byte) {
+	n.mtx.Lock()
+	n.stats.Sent++
+	l, ok := n.links[[2]string{from, to}]
+	if !ok {
+		l = n.link
+	}
+	if !n.reachable(from, to) || (l.DropRate > 0 && n.rng.Float64() < l.DropRate) {
+		n.stats.Dropped++
+		n.mtx.Unlock()
+		return
+	}
+	delay := l.Delay
+	if l.Jitter > 0 {
+		delay += time.Duration(n.rng.Int63n(int64(l.Jitter)))
+	}
+	p := packet{seq: n.seq, from: from, to: to, buf: slices.Clone(b)}
+	n.seq++
+	if delay == 0 {
+		n.mtx.Unlock()
+		n.deliver(p)
+		return
+	}
+	p.at = n.clock.Now().Add(delay)
+	i, _ := slices.BinarySearchFunc(n.pending, p, func(a, b packet) int {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+	n.pending = slices.Insert(n.pending, i, p)
+	n.mtx.Unlock()
+}
+
+func (n *Network) deliver(p packet) {
+	n.mtx.Lock()
+	t, ok := n.transports[p.to]
+	from := n.transports[p.from]
+	if !ok || !n.reachable(p.from, p.to) {
+		n.stats.Dropped++
+		n.mtx.Unlock()
+		return
+	}
+	n.mtx.Unlock()
+
+	var fromAddr net.Addr = &net.UDPAddr{}
+	if from != nil {
+		fromAddr = &net.UDPAddr{IP: from.addr.IP, Port: from.addr.Port}
+	}
+	select {
+	case t.packetc <- &memberlist.Packet{Buf: p.buf, From: fromAddr, Timestamp: time.Now()}:
+		n.mtx.Lock()
+		n.stats.Delivered++
+		n.mtx.Unlock()
+	default:
+		// The receiver is overloaded or shut down, like a full socket buffer.
+		n.mtx.Lock()
+		n.stats.Dropped++
+		n.mtx.Unlock()
+	}
+}
+
+func (n *Network) dial(from, to string, timeout time.Duration) (net.Conn, error) {
+	n.mtx.Lock()
+	n.stats.Dials++
+	t, ok := n.transports[to]
+	local := n.transports[from]
+	if !ok || local == nil || !n.reachable(from, to) {
+		n.stats.Refused++
+		n.mtx.Unlock()
+		return nil, fmt.Errorf("dial %s: connection refused", to)
+	}
+	n.mtx.Unlock()
+
+	client, server := net.Pipe()
+	client = &conn{Conn: client, net: n, local: local.addr, remote: t.addr}
+	server = &conn{Conn: server, net: n, local: t.addr, remote: local.addr}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case t.streamc <- server:
+		return client, nil
+	case <-t.shutdownc:
+	case <-timer.C:
+	}
+	_ = client.Close()
+	_ = server.Close()
+	n.mtx.Lock()
+	n.stats.Refused++
+	n.mtx.Unlock()
+	return nil, fmt.Errorf("dial %s: timeout", to)
+}
+
+func (n *Network) remove(addr string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	delete(n.transports, addr)
+}
+
+// Transport is an in-memory memberlist.Transport attached to a Network.
+type Transport struct {
+	net  *Network
+	addr *net.TCPAddr
+
+	packetc   chan *memberlist.Packet
+	streamc   chan net.Conn
+	shutdownc chan struct{}
+	once      sync.Once
+}
+
+var _ memberlist.Transport = (*Transport)(nil)
+
+// Addr returns the host:port the transport listens on.
+func (t *Transport) Addr() string {
+	return t.addr.String()
+}
+
+// FinalAdvertiseAddr implements memberlist.Transport.
+func (t *Transport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	if ip == "" {
+		return t.addr.IP, t.addr.Port, nil
+	}
+	advertiseIP := net.ParseIP(ip)
+	if advertiseIP == nil {
+		return nil, 0, fmt.Errorf("failed to parse advertise address %q", ip)
+	}
+	return advertiseIP, port, nil
+}
+
+// WriteTo implements memberlist.Transport.
+func (t *Transport) WriteTo(b []byte, addr string) (time.Time, error) {
+	select {
+	case <-t.shutdownc:
+		return time.Time{}, errors.New("transport shut down")
+	default:
+	}
+	t.net.send(t.Addr(), normalize(addr), b)
+	return time.Now(), nil
+}
+
+// PacketCh implements memberlist.Transport.
+func (t *Transport) PacketCh() <-chan *memberlist.Packet {
+	return t.packetc
+}
+
+// DialTimeout implements memberlist.Transport.
+func (t *Transport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return t.net.dial(t.Addr(), normalize(addr), timeout)
+}
+
+// StreamCh implements memberlist.Transport.
+func (t *Transport) StreamCh() <-chan net.Conn {
+	return t.streamc
+}
+
+// Shutdown implements memberlist.Transport.
+func (t *Transport) Shutdown() error {
+	t.once.Do(func() {
+		close(t.shutdownc)
+		t.net.remove(t.Addr())
+	})
+	return nil
+}
+
+// normalize turns addr into the form used as key of the transports.
+func normalize(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		port = strconv.Itoa(p)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// conn reports the addresses of the transports instead of those of the pipe
+// and counts the bytes written.
+type conn struct {
+	net.Conn
+	net           *Network
+	local, remote net.Addr
+	written       int
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.net.mtx.Lock()
+	c.net.stats.StreamBytes += n
+	c.written += n
+	c.net.stats.LargestStream = max(c.net.stats.LargestStream, c.written)
+	c.net.mtx.Unlock()
+	return n, err
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
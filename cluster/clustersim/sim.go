@@ -0,0 +1,289 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clustersim runs several cluster peers with notification logs in a
+// single process. The peers gossip through an in-memory network with
+// controllable packet delivery instead of sockets, and share a virtual clock.
+//
+// memberlist itself schedules gossip, probes and push/pull syncs on the real
+// clock, so the simulation uses short intervals for those. Everything else, the
+// periodic tasks of the peers, the notification logs and the delivery of
+// delayed packets, only moves forward with Sim.Advance.
+package clustersim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
+
+	"github.com/SoloJacobs/am/cluster"
+	"github.com/SoloJacobs/am/nflog"
+)
+
+// Config configures a simulation. Zero values are replaced by defaults.
+type Config struct {
+	// Peers is the number of peers, 3 by default.
+	Peers int
+	// Seed of the random decisions of the network.
+	Seed int64
+	// Logger defaults to a logger which discards everything.
+	Logger *slog.Logger
+
+	// Intervals of memberlist in real time.
+	GossipInterval   time.Duration
+	PushPullInterval time.Duration
+	ProbeInterval    time.Duration
+	ProbeTimeout     time.Duration
+
+	// Configure adjusts the options of the i-th peer before it is created.
+	Configure func(i int, o *cluster.Options)
+}
+
+func (c Config) withDefaults() Config {
+	if c.Peers == 0 {
+		c.Peers = 3
+	}
+	if c.Logger == nil {
+		c.Logger = slog.New(slog.DiscardHandler)
+	}
+	if c.GossipInterval == 0 {
+		c.GossipInterval = 10 * time.Millisecond
+	}
+	if c.PushPullInterval == 0 {
+		c.PushPullInterval = 500 * time.Millisecond
+	}
+	if c.ProbeInterval == 0 {
+		c.ProbeInterval = 200 * time.Millisecond
+	}
+	if c.ProbeTimeout == 0 {
+		c.ProbeTimeout = 100 * time.Millisecond
+	}
+	return c
+}
+
+// Node is a single peer of the simulation.
+type Node struct {
+	Addr string
+	Peer *cluster.Peer
+	Log  *nflog.Log
+}
+
+// Sim is a cluster of peers connected through an in-memory network.
+type Sim struct {
+	Clock   *quartz.Mock
+	Network *Network
+	Nodes   []*Node
+}
+
+// New starts a simulation. Every peer joins the peers started before it. The
+// peers leave the cluster when the test finishes.
+func New(tb testing.TB, cfg Config) *Sim {
+	tb.Helper()
+	cfg = cfg.withDefaults()
+
+	clock := quartz.NewMock(tb).WithLogger(quartz.NoOpLogger)
+	s := &Sim{
+		Clock:   clock,
+		Network: NewNetwork(clock, cfg.Seed),
+	}
+	var known []string
+	for i := range cfg.Peers {
+		n, err := s.newNode(cfg, i, known)
+		if err != nil {
+			tb.Fatalf("start peer %d: %v", i, err)
+		}
+		// Without a timeout, leaving can block forever if memberlist drops
+		// the leave broadcast, e.g. after refuting a suspicion concurrently.
+		tb.Cleanup(func() { _ = n.Peer.Leave(time.Second) })
+		s.Nodes = append(s.Nodes, n)
+		known = append(known, n.Addr)
+	}
+	return s
+}
+
+func (s *Sim) newNode(cfg Config, i int, known []string) (*Node, error) {
+	tr, err := s.Network.NewTransport(fmt.Sprintf("10.0.0.%d:9094", i+1))
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("peer-%d", i)
+	l := cfg.Logger.With("peer", name)
+	o := cluster.DefaultOptions()
+	o.Logger = l
+	o.BindAddr = tr.Addr()
+	o.KnownPeers = known
+	o.PushPullInterval = cfg.PushPullInterval
+	o.GossipInterval = cfg.GossipInterval
+	o.ProbeTimeout = cfg.ProbeTimeout
+	o.ProbeInterval = cfg.ProbeInterval
+	o.Transport = tr
+	o.Name = name
+	o.Clock = s.Clock
+	if cfg.Configure != nil {
+		cfg.Configure(i, &o)
+	}
+	p, err := cluster.Create(o)
+	if err != nil {
+		return nil, err
+	}
+	log, err := nflog.New(nflog.Options{Retention: time.Hour, Logger: l, Clock: s.Clock})
+	if err != nil {
+		return nil, err
+	}
+	log.SetNamedBroadcast(p.AddState("nfl", log).BroadcastNamed)
+	if err := p.Join(cluster.DefaultReconnectInterval, cluster.DefaultReconnectTimeout); err != nil {
+		return nil, err
+	}
+	return &Node{Addr: tr.Addr(), Peer: p, Log: log}, nil
+}
+
+// Advance moves the virtual clock forward by d. It stops at every timer and
+// queued packet due in between, so that they fire in order.
+func (s *Sim) Advance(d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		step := d
+		if next, ok := s.Clock.Peek(); ok && next < step {
+			step = next
+		}
+		if next, ok := s.Network.nextDelivery(); ok && next < step {
+			step = next
+		}
+		s.Clock.Advance(step).MustWait(ctx)
+		s.Network.Deliver()
+		d -= step
+		if d <= 0 {
+			return
+		}
+	}
+}
+
+// SetLink sets the link between all nodes.
+func (s *Sim) SetLink(l Link) {
+	s.Network.SetLink(l)
+}
+
+// Partition splits the nodes into groups given by their index.
+func (s *Sim) Partition(groups ...[]int) {
+	addrs := make([][]string, 0, len(groups))
+	for _, g := range groups {
+		var as []string
+		for _, i := range g {
+			as = append(as, s.Nodes[i].Addr)
+		}
+		addrs = append(addrs, as)
+	}
+	s.Network.Partition(addrs...)
+}
+
+// Heal removes all partitions.
+func (s *Sim) Heal() {
+	s.Network.Heal()
+}
+
+// Joined reports whether every node sees all others as members.
+func (s *Sim) Joined() bool {
+	for _, n := range s.Nodes {
+		if n.Peer.ClusterSize() != len(s.Nodes) {
+			return false
+		}
+	}
+	return true
+}
+
+// Divergent returns the keys of the states the nodes don't agree on, see
+// cluster.Divergent.
+func (s *Sim) Divergent() ([]string, error) {
+	return s.checker().Check(context.Background())
+}
+
+// Converged reports whether all nodes agree on their states and members.
+func (s *Sim) Converged() bool {
+	divergent, err := s.Divergent()
+	return err == nil && len(divergent) == 0
+}
+
+// WaitConverged advances the clock by step until all nodes agree on their
+// states and members or ctx is done. The result holds the virtual time until
+// convergence.
+func (s *Sim) WaitConverged(ctx context.Context, step time.Duration) (cluster.Convergence, error) {
+	start := s.Clock.Now()
+	var res cluster.Convergence
+	for {
+		res.Polls++
+		divergent, err := s.Divergent()
+		if err != nil {
+			return res, err
+		}
+		res.Divergent = divergent
+		if len(divergent) == 0 {
+			res.Elapsed = s.Clock.Since(start)
+			return res, nil
+		}
+		select {
+		case <-ctx.Done():
+			return res, fmt.Errorf("not converged after %v, divergent: %s", s.Clock.Since(start), strings.Join(divergent, ", "))
+		case <-time.After(step):
+		}
+		s.Advance(step)
+	}
+}
+
+func (s *Sim) checker() cluster.ConvergenceChecker {
+	var c cluster.ConvergenceChecker
+	for _, n := range s.Nodes {
+		c.Sources = append(c.Sources, cluster.PeerDigests(n.Peer))
+	}
+	return c
+}
+
+// Entries returns the entries of the notification log of a node sorted by
+// their key.
+func (n *Node) Entries() ([]*pb.MeshEntry, error) {
+	b, err := n.Log.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var entries []*pb.MeshEntry
+	r := bytes.NewReader(b)
+	for {
+		var e pb.MeshEntry
+		if _, err := pbutil.ReadDelimited(r, &e); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	slices.SortFunc(entries, func(a, b *pb.MeshEntry) int {
+		return strings.Compare(entryKey(a), entryKey(b))
+	})
+	return entries, nil
+}
+
+func entryKey(e *pb.MeshEntry) string {
+	r := e.Entry.Receiver
+	return fmt.Sprintf("%s/%s/%s/%d", e.Entry.GroupKey, r.GroupName, r.Integration, r.Idx)
+}
@@ -0,0 +1,334 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clustersim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster"
+)
+
+func receive(t *testing.T, tr *Transport) string {
+	t.Helper()
+	select {
+	case p := <-tr.PacketCh():
+		return string(p.Buf)
+	default:
+		return ""
+	}
+}
+
+func TestNetworkDelivery(t *testing.T) {
+	clock := quartz.NewMock(t)
+	n := NewNetwork(clock, 1)
+	a, err := n.NewTransport("10.0.0.1:9094")
+	require.NoError(t, err)
+	b, err := n.NewTransport("10.0.0.2:9094")
+	require.NoError(t, err)
+	_, err = n.NewTransport("10.0.0.1:9094")
+	require.Error(t, err)
+
+	_, err = a.WriteTo([]byte("now"), b.Addr())
+	require.NoError(t, err)
+	require.Equal(t, "now", receive(t, b))
+
+	// Delayed packets wait for the clock.
+	n.SetLinkBetween(a.Addr(), b.Addr(), Link{Delay: time.Second})
+	_, err = a.WriteTo([]byte("later"), b.Addr())
+	require.NoError(t, err)
+	n.Deliver()
+	require.Empty(t, receive(t, b))
+	require.Equal(t, 1, n.Pending())
+	clock.Advance(time.Second)
+	n.Deliver()
+	require.Equal(t, "later", receive(t, b))
+
+	// The link is directed.
+	_, err = b.WriteTo([]byte("back"), a.Addr())
+	require.NoError(t, err)
+	require.Equal(t, "back", receive(t, a))
+
+	n.SetLinkBetween(a.Addr(), b.Addr(), Link{DropRate: 1})
+	_, err = a.WriteTo([]byte("dropped"), b.Addr())
+	require.NoError(t, err)
+	require.Empty(t, receive(t, b))
+
+	require.Equal(t, NetworkStats{Sent: 4, Dropped: 1, Delivered: 3}, n.Stats())
+}
+
+func TestNetworkReorder(t *testing.T) {
+	clock := quartz.NewMock(t)
+	n := NewNetwork(clock, 1)
+	a, err := n.NewTransport("10.0.0.1:9094")
+	require.NoError(t, err)
+	b, err := n.NewTransport("10.0.0.2:9094")
+	require.NoError(t, err)
+	n.SetLink(Link{Delay: time.Millisecond, Jitter: time.Second})
+
+	var sent []string
+	for i := range 10 {
+		sent = append(sent, fmt.Sprint(i))
+		_, err := a.WriteTo([]byte(sent[i]), b.Addr())
+		require.NoError(t, err)
+	}
+	clock.Advance(2 * time.Second)
+	n.Deliver()
+
+	var received []string
+	for range sent {
+		received = append(received, receive(t, b))
+	}
+	require.ElementsMatch(t, sent, received)
+	require.NotEqual(t, sent, received)
+}
+
+func TestNetworkPartition(t *testing.T) {
+	n := NewNetwork(quartz.NewMock(t), 1)
+	a, err := n.NewTransport("10.0.0.1:9094")
+	require.NoError(t, err)
+	b, err := n.NewTransport("10.0.0.2:9094")
+	require.NoError(t, err)
+
+	n.Partition([]string{a.Addr()})
+	_, err = a.WriteTo([]byte("lost"), b.Addr())
+	require.NoError(t, err)
+	require.Empty(t, receive(t, b))
+	_, err = a.DialTimeout(b.Addr(), time.Second)
+	require.Error(t, err)
+
+	n.Heal()
+	go func() {
+		c := <-b.StreamCh()
+		defer c.Close()
+		_, _ = c.Write([]byte("hello"))
+	}()
+	c, err := a.DialTimeout(b.Addr(), time.Second)
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, b.Addr(), c.RemoteAddr().String())
+	msg, err := io.ReadAll(c)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(msg))
+}
+
+// logRandom adds n random entries to the logs of random nodes.
+func logRandom(t *testing.T, s *Sim, rng *rand.Rand, n int) {
+	t.Helper()
+	for range n {
+		node := s.Nodes[rng.Intn(len(s.Nodes))]
+		recv := &pb.Receiver{GroupName: "team", Integration: "webhook", Idx: uint32(rng.Intn(2))}
+		gkey := fmt.Sprintf("{}:{alertname=\"A%d\"}", rng.Intn(5))
+		require.NoError(t, node.Log.Log(recv, gkey, []uint64{rng.Uint64()}, nil, time.Hour))
+		// Entries of the same key need distinct timestamps to be ordered.
+		s.Advance(time.Millisecond)
+	}
+}
+
+func waitConverged(t *testing.T, s *Sim) cluster.Convergence {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	res, err := s.WaitConverged(ctx, 10*time.Millisecond)
+	require.NoError(t, err)
+	return res
+}
+
+func TestConvergence(t *testing.T) {
+	for seed := range int64(5) {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			t.Parallel()
+			rng := rand.New(rand.NewSource(seed))
+			s := New(t, Config{Peers: 2 + rng.Intn(4), Seed: seed})
+			require.Eventually(t, s.Joined, 10*time.Second, 10*time.Millisecond)
+
+			s.SetLink(Link{
+				Delay:    time.Duration(rng.Intn(50)) * time.Millisecond,
+				Jitter:   time.Duration(rng.Intn(100)) * time.Millisecond,
+				DropRate: rng.Float64() / 2,
+			})
+			logRandom(t, s, rng, 20)
+			res := waitConverged(t, s)
+			t.Logf("converged after %v of virtual time and %d polls", res.Elapsed, res.Polls)
+
+			entries, err := s.Nodes[0].Entries()
+			require.NoError(t, err)
+			require.NotEmpty(t, entries)
+		})
+	}
+}
+
+func TestPartitionHeals(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := New(t, Config{Peers: 4})
+	require.Eventually(t, s.Joined, 10*time.Second, 10*time.Millisecond)
+
+	s.Partition([]int{0, 1}, []int{2, 3})
+	for _, i := range []int{0, 2} {
+		recv := &pb.Receiver{GroupName: "team", Integration: "webhook"}
+		require.NoError(t, s.Nodes[i].Log.Log(recv, fmt.Sprintf("{}:{side=\"%d\"}", i), []uint64{1}, nil, time.Hour))
+	}
+	require.Never(t, func() bool {
+		s.Advance(10 * time.Millisecond)
+		return s.Converged()
+	}, time.Second, 50*time.Millisecond)
+
+	s.Heal()
+	logRandom(t, s, rng, 5)
+	waitConverged(t, s)
+	entries, err := s.Nodes[3].Entries()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(entries), 2)
+}
+
+// logSilently adds n entries to the log of a node without broadcasting them,
+// so that only push/pull spreads them.
+func logSilently(t *testing.T, node *Node, prefix string, n int) {
+	t.Helper()
+	node.Log.SetBroadcast(func([]byte) {})
+	rng := rand.New(rand.NewSource(int64(n)))
+	recv := &pb.Receiver{GroupName: "team", Integration: "webhook"}
+	for i := range n {
+		// Alert hashes are random, they don't compress.
+		alerts := []uint64{rng.Uint64(), rng.Uint64(), rng.Uint64()}
+		require.NoError(t, node.Log.Log(recv, fmt.Sprintf("{}:{%s=\"%d\"}", prefix, i), alerts, nil, time.Hour))
+	}
+}
+
+func antiEntropy(mode func(i int) cluster.AntiEntropy) func(int, *cluster.Options) {
+	return func(i int, o *cluster.Options) { o.AntiEntropy = mode(i) }
+}
+
+func TestDigestAntiEntropy(t *testing.T) {
+	streamBytes := func(mode cluster.AntiEntropy) int {
+		s := New(t, Config{Configure: antiEntropy(func(int) cluster.AntiEntropy { return mode })})
+		require.Eventually(t, s.Joined, 10*time.Second, 10*time.Millisecond)
+		logSilently(t, s.Nodes[0], "a", 1000)
+		logSilently(t, s.Nodes[1], "b", 10)
+		waitConverged(t, s)
+		entries, err := s.Nodes[2].Entries()
+		require.NoError(t, err)
+		require.Len(t, entries, 1010)
+
+		// Once converged, digests are all that is exchanged.
+		before := s.Network.Stats().StreamBytes
+		time.Sleep(2 * time.Second)
+		return s.Network.Stats().StreamBytes - before
+	}
+	full, digest := streamBytes(cluster.AntiEntropyFull), streamBytes(cluster.AntiEntropyDigest)
+	t.Logf("push/pull of converged peers: %d bytes in full mode, %d bytes in digest mode", full, digest)
+	require.Less(t, digest, full/3)
+}
+
+func TestDigestAntiEntropyMixedVersions(t *testing.T) {
+	// The first peer doesn't understand digests.
+	s := New(t, Config{Configure: antiEntropy(func(i int) cluster.AntiEntropy {
+		if i == 0 {
+			return cluster.AntiEntropyFull
+		}
+		return cluster.AntiEntropyDigest
+	})})
+	require.Eventually(t, s.Joined, 10*time.Second, 10*time.Millisecond)
+	logSilently(t, s.Nodes[0], "old", 20)
+	logSilently(t, s.Nodes[2], "new", 20)
+	waitConverged(t, s)
+	entries, err := s.Nodes[0].Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 40)
+}
+
+func TestOversizedRetriedAfterPartition(t *testing.T) {
+	var (
+		mtx        sync.Mutex
+		deliveries []cluster.Delivery
+	)
+	s := New(t, Config{Configure: func(i int, o *cluster.Options) {
+		if i == 0 {
+			o.OnDelivery = func(d cluster.Delivery) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				deliveries = append(deliveries, d)
+			}
+		}
+	}})
+	require.Eventually(t, s.Joined, 10*time.Second, 10*time.Millisecond)
+	delivered := func(addr string, attempts int) bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, d := range deliveries {
+			if d.Addr == addr && d.Outcome == cluster.Delivered && d.Attempts >= attempts {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The entry is too large for gossip and can't reach the other side.
+	// Only a failed send arms the retry timer, the partition must not heal
+	// before.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	trap := s.Clock.Trap().NewTimer("retryQueue", "run")
+	s.Partition([]int{0, 1}, []int{2})
+	alerts := make([]uint64, 200)
+	for i := range alerts {
+		alerts[i] = uint64(i) << 32
+	}
+	recv := &pb.Receiver{GroupName: "team", Integration: "webhook"}
+	require.NoError(t, s.Nodes[0].Log.Log(recv, "{}:{}", alerts, nil, time.Hour))
+	trap.MustWait(ctx).MustRelease(ctx)
+	trap.Close()
+	require.Eventually(t, func() bool { return delivered(s.Nodes[1].Addr, 1) }, 10*time.Second, 10*time.Millisecond)
+
+	s.Heal()
+	require.Eventually(t, func() bool {
+		s.Advance(time.Second)
+		return delivered(s.Nodes[2].Addr, 2)
+	}, 20*time.Second, 10*time.Millisecond)
+}
+
+func TestCompression(t *testing.T) {
+	streamBytes := func(compress bool) int {
+		s := New(t, Config{Configure: func(_ int, o *cluster.Options) { o.Compress = compress }})
+		require.Eventually(t, s.Joined, 10*time.Second, 10*time.Millisecond)
+		node := s.Nodes[0]
+		node.Log.SetBroadcast(func([]byte) {})
+		rng := rand.New(rand.NewSource(1))
+		for i := range 500 {
+			// Group keys repeat most of their labels, alert hashes don't.
+			recv := &pb.Receiver{GroupName: "team-" + fmt.Sprint(i%5), Integration: "webhook"}
+			gkey := fmt.Sprintf("{}/{severity=\"critical\"}:{alertname=\"HighLatency\", cluster=\"eu-west-1\", instance=\"host-%d\"}", i)
+			require.NoError(t, node.Log.Log(recv, gkey, []uint64{rng.Uint64(), rng.Uint64()}, nil, time.Hour))
+		}
+		waitConverged(t, s)
+		// The largest stream is a push/pull with the full state, smaller
+		// ones like pings over TCP don't matter.
+		return s.Network.Stats().LargestStream
+	}
+	plain, compressed := streamBytes(false), streamBytes(true)
+	t.Logf("push/pull of the full state: %d bytes compressed by memberlist only, %d bytes compressed by both", plain, compressed)
+	// memberlist compresses the plain streams already, with LZW. Flate
+	// saves about 40% on top of it here, the random hashes of the entries
+	// don't compress at all.
+	require.Less(t, compressed, plain*2/3)
+}
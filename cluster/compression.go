@@ -0,0 +1,91 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+const (
+	// minCompressSize is the size from which parts are compressed. Smaller
+	// ones fit into a gossip packet anyway.
+	minCompressSize = MaxGossipPacketSize / 2
+	// maxDecompressedSize bounds the size of a decompressed part.
+	maxDecompressedSize = 256 << 20
+)
+
+// compressPart compresses the data of p if it is large enough and shrinks.
+func compressPart(p *clusterpb.Part) error {
+	if p.Encoding != clusterpb.Encoding_NONE || len(p.Data) < minCompressSize {
+		return nil
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(p.Data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if buf.Len() < len(p.Data) {
+		p.Data, p.Encoding = buf.Bytes(), clusterpb.Encoding_FLATE
+	}
+	return nil
+}
+
+// compressMessage compresses a serialized part.
+func compressMessage(b []byte) ([]byte, error) {
+	var p clusterpb.Part
+	if err := proto.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if err := compressPart(&p); err != nil {
+		return nil, err
+	}
+	if p.Encoding == clusterpb.Encoding_NONE {
+		return b, nil
+	}
+	return proto.Marshal(&p)
+}
+
+// partData returns the decoded data of p.
+func partData(p clusterpb.Part) ([]byte, error) {
+	switch p.Encoding {
+	case clusterpb.Encoding_NONE:
+		return p.Data, nil
+	case clusterpb.Encoding_FLATE:
+		r := flate.NewReader(bytes.NewReader(p.Data))
+		defer r.Close()
+		b, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("decompress part: %w", err)
+		}
+		if len(b) > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed part exceeds %d bytes", maxDecompressedSize)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %v", p.Encoding)
+	}
+}
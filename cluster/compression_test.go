@@ -0,0 +1,121 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+func TestCompressPart(t *testing.T) {
+	random := make([]byte, 2*minCompressSize)
+	_, _ = rand.Read(random)
+	for _, tc := range []struct {
+		name       string
+		data       []byte
+		compressed bool
+	}{
+		{name: "small", data: []byte("small")},
+		{name: "random", data: random},
+		{name: "repetitive", data: bytes.Repeat([]byte("alertname=\"HighLatency\""), 100), compressed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := clusterpb.Part{Key: "nfl", Data: tc.data}
+			require.NoError(t, compressPart(&p))
+			if !tc.compressed {
+				require.Equal(t, clusterpb.Encoding_NONE, p.Encoding)
+				require.Equal(t, tc.data, p.Data)
+				return
+			}
+			require.Equal(t, clusterpb.Encoding_FLATE, p.Encoding)
+			require.Less(t, len(p.Data), len(tc.data))
+			data, err := partData(p)
+			require.NoError(t, err)
+			require.Equal(t, tc.data, data)
+		})
+	}
+}
+
+func TestCompressMessage(t *testing.T) {
+	data := bytes.Repeat([]byte("group key "), 200)
+	b, err := proto.Marshal(&clusterpb.Part{Key: "nfl", Data: data})
+	require.NoError(t, err)
+	b, err = compressMessage(b)
+	require.NoError(t, err)
+
+	var p clusterpb.Part
+	require.NoError(t, proto.Unmarshal(b, &p))
+	require.Equal(t, "nfl", p.Key)
+	got, err := partData(p)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+}
+
+func TestPartDataErrors(t *testing.T) {
+	_, err := partData(clusterpb.Part{Data: []byte("not flate"), Encoding: clusterpb.Encoding_FLATE})
+	require.ErrorContains(t, err, "decompress part")
+	_, err = partData(clusterpb.Part{Encoding: 7})
+	require.EqualError(t, err, "unknown encoding 7")
+}
+
+func TestLocalStateCompression(t *testing.T) {
+	o := testOptions()
+	o.AntiEntropy = AntiEntropyFull
+	p := newTestPeer(t, o)
+	p.compress = true
+	p.AddState("plain", bytesState(bytes.Repeat([]byte("x"), 1000)))
+	o2 := testOptions()
+	o2.AntiEntropy = AntiEntropyFull
+	p2 := newTestPeer(t, o2)
+	_, err := p.mlist.Join([]string{p2.Self().Address()})
+	require.NoError(t, err)
+	encoding := func(join bool) clusterpb.Encoding {
+		_, fs := decodeFullState(t, p.delegate.LocalState(join))
+		require.Equal(t, []clusterpb.Encoding{clusterpb.Encoding_FLATE}, fs.AcceptEncodings)
+		return fs.Parts[0].Encoding
+	}
+
+	// The push/pull on join told p that p2 accepts compressed parts. They're
+	// never sent on join, when the receiver might not be a member yet.
+	require.Equal(t, clusterpb.Encoding_FLATE, encoding(false))
+	require.Equal(t, clusterpb.Encoding_NONE, encoding(true))
+
+	// Older peers don't accept them.
+	p.delegate.setFeatures(&clusterpb.FullState{From: p2.Name()})
+	require.Equal(t, clusterpb.Encoding_NONE, encoding(false))
+	p.delegate.setFeatures(&clusterpb.FullState{From: p2.Name(), AcceptEncodings: []clusterpb.Encoding{clusterpb.Encoding_FLATE}})
+
+	// The receiver decompresses them.
+	state := &recordState{}
+	p2.AddState("plain", state)
+	p2.delegate.MergeRemoteState(p.delegate.LocalState(false), false)
+	require.Equal(t, bytes.Repeat([]byte("x"), 1000), state.merged)
+}
+
+// recordState keeps the data it merged last.
+type recordState struct {
+	bytesState
+	merged []byte
+}
+
+func (s *recordState) Merge(b []byte) error {
+	s.merged = b
+	return nil
+}
@@ -0,0 +1,284 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coder/quartz"
+)
+
+// DigestPath is the conventional path of DigestHandler.
+const DigestPath = "/debug/cluster/digests"
+
+// MembersKey is the key under which Divergent reports a disagreement about
+// the members of the cluster.
+const MembersKey = "members"
+
+// UnknownDigest is reported by a member for a state whose digest it can't
+// tell, e.g. by members which don't serve DigestHandler. Such states can't
+// be compared, Divergent skips them and Unknown lists them.
+const UnknownDigest = "unknown"
+
+// Digester is implemented by states whose serialization depends on more than
+// their content, e.g. on the iteration order of a map. Peers holding the same
+// state must return the same digest.
+type Digester interface {
+	Digest() ([]byte, error)
+}
+
+// StateDigest returns the hex encoded digest of a state. It is the Digest of
+// the state if it implements Digester and the SHA-256 of its MarshalBinary
+// output otherwise.
+func StateDigest(s State) (string, error) {
+	if d, ok := s.(Digester); ok {
+		b, err := d.Digest()
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	}
+	b, err := s.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// MemberDigests is the view of a single member on the cluster.
+type MemberDigests struct {
+	Name string `json:"name"`
+	// Members are the sorted names of all members the member knows of,
+	// including itself.
+	Members []string `json:"members"`
+	// States maps the keys of the states to their digests.
+	States map[string]string `json:"states"`
+}
+
+// Digests returns the digests of all states added to the peer.
+func (p *Peer) Digests() (MemberDigests, error) {
+	md := MemberDigests{Name: p.Name(), States: map[string]string{}}
+	for _, n := range p.mlist.Members() {
+		md.Members = append(md.Members, n.Name)
+	}
+	slices.Sort(md.Members)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	for key, s := range p.states {
+		d, err := StateDigest(s)
+		if err != nil {
+			return MemberDigests{}, fmt.Errorf("digest of state %s: %w", key, err)
+		}
+		md.States[key] = d
+	}
+	return md, nil
+}
+
+// DigestHandler serves the digests of p as JSON.
+func DigestHandler(p *Peer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		md, err := p.Digests()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(md)
+	})
+}
+
+// DigestSource returns the digests of one member.
+type DigestSource func(context.Context) (MemberDigests, error)
+
+// PeerDigests returns a source for a peer in the same process.
+func PeerDigests(p *Peer) DigestSource {
+	return func(context.Context) (MemberDigests, error) {
+		return p.Digests()
+	}
+}
+
+// HTTPDigests returns a source fetching the digests from a DigestHandler at
+// url. A nil client is http.DefaultClient.
+func HTTPDigests(client *http.Client, url string) DigestSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) (MemberDigests, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return MemberDigests{}, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return MemberDigests{}, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return MemberDigests{}, fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+		}
+		var md MemberDigests
+		if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+			return MemberDigests{}, fmt.Errorf("GET %s: %w", url, err)
+		}
+		return md, nil
+	}
+}
+
+// Divergent returns the sorted keys of the states which differ between the
+// members or are missing on some of them. MembersKey is included if the
+// members don't agree on the members of the cluster.
+func Divergent(members []MemberDigests) []string {
+	if len(members) == 0 {
+		return nil
+	}
+	var divergent []string
+	keys := map[string]struct{}{}
+	for _, m := range members {
+		for k := range m.States {
+			keys[k] = struct{}{}
+		}
+	}
+	for k := range keys {
+		if unknown(members, k) {
+			continue
+		}
+		d, same := members[0].States[k]
+		for _, m := range members[1:] {
+			if md, ok := m.States[k]; !ok || md != d {
+				same = false
+				break
+			}
+		}
+		if !same {
+			divergent = append(divergent, k)
+		}
+	}
+	for _, m := range members[1:] {
+		if !slices.Equal(m.Members, members[0].Members) {
+			divergent = append(divergent, MembersKey)
+			break
+		}
+	}
+	slices.Sort(divergent)
+	return divergent
+}
+
+// Unknown returns the sorted keys of the states for which any member
+// reported UnknownDigest.
+func Unknown(members []MemberDigests) []string {
+	var keys []string
+	for _, m := range members {
+		for k := range m.States {
+			if !slices.Contains(keys, k) && unknown(members, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func unknown(members []MemberDigests, key string) bool {
+	return slices.ContainsFunc(members, func(m MemberDigests) bool { return m.States[key] == UnknownDigest })
+}
+
+// Convergence is the outcome of ConvergenceChecker.Wait.
+type Convergence struct {
+	// Elapsed is the time from the start of the wait until the first poll
+	// which found all members in agreement.
+	Elapsed time.Duration
+	Polls   int
+	// Divergent are the keys which differed on the last poll.
+	Divergent []string
+	// Unknown are the keys which couldn't be compared on the last poll, so
+	// the members only agree on the others.
+	Unknown []string
+}
+
+// ConvergenceChecker compares the digests of several members.
+type ConvergenceChecker struct {
+	Sources []DigestSource
+	// Interval between two polls in Wait, one second by default.
+	Interval time.Duration
+	// Clock defaults to the real clock.
+	Clock quartz.Clock
+}
+
+// Check fetches the digests of all sources once and returns the divergent
+// keys.
+func (c ConvergenceChecker) Check(ctx context.Context) ([]string, error) {
+	members, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Divergent(members), nil
+}
+
+func (c ConvergenceChecker) fetch(ctx context.Context) ([]MemberDigests, error) {
+	members := make([]MemberDigests, 0, len(c.Sources))
+	for _, src := range c.Sources {
+		md, err := src(ctx)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, md)
+	}
+	return members, nil
+}
+
+// Wait polls the sources until they agree on all states or ctx is done.
+// Errors of the sources count as disagreement and are returned if ctx ends
+// before convergence.
+func (c ConvergenceChecker) Wait(ctx context.Context) (Convergence, error) {
+	clock, interval := c.Clock, c.Interval
+	if clock == nil {
+		clock = quartz.NewReal()
+	}
+	if interval == 0 {
+		interval = time.Second
+	}
+	start := clock.Now()
+	var res Convergence
+	for {
+		res.Polls++
+		members, err := c.fetch(ctx)
+		divergent := Divergent(members)
+		res.Divergent, res.Unknown = divergent, Unknown(members)
+		if err == nil && len(divergent) == 0 {
+			res.Elapsed = clock.Since(start)
+			return res, nil
+		}
+
+		timer := clock.NewTimer(interval, "ConvergenceChecker", "Wait")
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if err != nil {
+				return res, fmt.Errorf("not converged: %w", err)
+			}
+			return res, fmt.Errorf("not converged after %v, divergent: %s", clock.Since(start), strings.Join(divergent, ", "))
+		case <-timer.C:
+		}
+	}
+}
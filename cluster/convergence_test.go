@@ -0,0 +1,182 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/require"
+)
+
+type bytesState []byte
+
+func (s bytesState) MarshalBinary() ([]byte, error) { return s, nil }
+func (s bytesState) Merge([]byte) error             { return nil }
+
+type digestState struct{ bytesState }
+
+func (s digestState) Digest() ([]byte, error) { return []byte{0xab}, nil }
+
+func TestStateDigest(t *testing.T) {
+	d, err := StateDigest(bytesState("state"))
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte("state"))
+	require.Equal(t, hex.EncodeToString(sum[:]), d)
+	d2, err := StateDigest(bytesState("other"))
+	require.NoError(t, err)
+	require.NotEqual(t, d, d2)
+
+	d, err = StateDigest(digestState{bytesState("state")})
+	require.NoError(t, err)
+	require.Equal(t, "ab", d)
+}
+
+func TestDivergent(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		members []MemberDigests
+		want    []string
+		unknown []string
+	}{
+		{
+			name: "no members",
+		},
+		{
+			name: "agree",
+			members: []MemberDigests{
+				{Name: "a", Members: []string{"a", "b"}, States: map[string]string{"nfl": "1", "sil": "2"}},
+				{Name: "b", Members: []string{"a", "b"}, States: map[string]string{"nfl": "1", "sil": "2"}},
+			},
+		},
+		{
+			name: "differing and missing states",
+			members: []MemberDigests{
+				{Name: "a", Members: []string{"a", "b"}, States: map[string]string{"nfl": "1", "sil": "2"}},
+				{Name: "b", Members: []string{"a", "b"}, States: map[string]string{"nfl": "3", "other": "4"}},
+			},
+			want: []string{"nfl", "other", "sil"},
+		},
+		{
+			name: "membership",
+			members: []MemberDigests{
+				{Name: "a", Members: []string{"a"}, States: map[string]string{"nfl": "1"}},
+				{Name: "b", Members: []string{"a", "b"}, States: map[string]string{"nfl": "1"}},
+			},
+			want: []string{MembersKey},
+		},
+		{
+			name: "unknown state",
+			members: []MemberDigests{
+				{Name: "a", Members: []string{"a", "b"}, States: map[string]string{"nfl": "1", "sil": "2"}},
+				{Name: "b", Members: []string{"a", "b"}, States: map[string]string{"nfl": UnknownDigest, "sil": "2"}},
+			},
+			unknown: []string{"nfl"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, Divergent(tc.members))
+			require.Equal(t, tc.unknown, Unknown(tc.members))
+		})
+	}
+}
+
+func TestConvergenceCheckerWait(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clock := quartz.NewMock(t)
+	trap := clock.Trap().NewTimer("ConvergenceChecker", "Wait")
+	defer trap.Close()
+
+	var polls atomic.Int32
+	static := func(context.Context) (MemberDigests, error) {
+		return MemberDigests{States: map[string]string{"nfl": "1"}}, nil
+	}
+	// The second member fails once and lags behind once.
+	lagging := func(context.Context) (MemberDigests, error) {
+		switch polls.Add(1) {
+		case 1:
+			return MemberDigests{}, errors.New("connection refused")
+		case 2:
+			return MemberDigests{States: map[string]string{"nfl": "0"}}, nil
+		default:
+			return MemberDigests{States: map[string]string{"nfl": "1"}}, nil
+		}
+	}
+	c := ConvergenceChecker{
+		Sources:  []DigestSource{static, lagging},
+		Interval: time.Second,
+		Clock:    clock,
+	}
+
+	var (
+		res Convergence
+		err error
+	)
+	done := make(chan struct{})
+	go func() {
+		res, err = c.Wait(ctx)
+		close(done)
+	}()
+	for range 2 {
+		trap.MustWait(ctx).MustRelease(ctx)
+		clock.Advance(time.Second).MustWait(ctx)
+	}
+	<-done
+	require.NoError(t, err)
+	require.Equal(t, Convergence{Elapsed: 2 * time.Second, Polls: 3}, res)
+}
+
+func TestConvergenceCheckerTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	source := func(name string) DigestSource {
+		return func(context.Context) (MemberDigests, error) {
+			return MemberDigests{States: map[string]string{"nfl": name}}, nil
+		}
+	}
+	c := ConvergenceChecker{Sources: []DigestSource{source("a"), source("b")}, Interval: 10 * time.Millisecond}
+	res, err := c.Wait(ctx)
+	require.ErrorContains(t, err, "divergent: nfl")
+	require.Equal(t, []string{"nfl"}, res.Divergent)
+	require.Greater(t, res.Polls, 1)
+}
+
+func TestDigestHandler(t *testing.T) {
+	p := newTestPeer(t, testOptions())
+	p.AddState("nfl", digestState{})
+	srv := httptest.NewServer(DigestHandler(p))
+	defer srv.Close()
+
+	want, err := p.Digests()
+	require.NoError(t, err)
+	require.Equal(t, []string{p.Name()}, want.Members)
+	require.Equal(t, map[string]string{"nfl": "ab"}, want.States)
+
+	got, err := HTTPDigests(nil, srv.URL+DigestPath)(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	c := ConvergenceChecker{Sources: []DigestSource{PeerDigests(p), HTTPDigests(nil, srv.URL)}}
+	divergent, err := c.Check(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, divergent)
+}
@@ -14,20 +14,21 @@
 package cluster
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/hashicorp/memberlist"
 
-	"github.com/prometheus/alertmanager/cluster/clusterpb"
+	"github.com/SoloJacobs/am/cluster/clusterpb"
 )
 
 const (
-	// Maximum number of messages to be held in the queue.
-	maxQueueSize = 4096
-	fullState    = "full_state"
-	update       = "update"
+	fullState = "full_state"
+	update    = "update"
 )
 
 // delegate implements memberlist.Delegate and memberlist.EventDelegate
@@ -36,29 +37,35 @@ type delegate struct {
 	*Peer
 
 	logger *slog.Logger
-	bcast  *memberlist.TransmitLimitedQueue
-}
+	bcast  *broadcastQueue
 
-func newDelegate(l *slog.Logger, p *Peer, retransmit int) *delegate {
-	bcast := &memberlist.TransmitLimitedQueue{
-		NumNodes:       p.ClusterSize,
-		RetransmitMult: retransmit,
-	}
+	// features of the other peers by their name, and whether their full
+	// state was merged.
+	featuresMtx sync.Mutex
+	features    map[string]peerFeatures
+	synced      map[string]bool
+}
 
+func newDelegate(l *slog.Logger, p *Peer, bcast *broadcastQueue) *delegate {
 	d := &delegate{
-		logger: l,
-		Peer:   p,
-		bcast:  bcast,
+		logger:   l,
+		Peer:     p,
+		bcast:    bcast,
+		features: map[string]peerFeatures{},
+		synced:   map[string]bool{},
 	}
 
-	go d.handleQueueDepth()
-
 	return d
 }
 
 // NodeMeta retrieves meta-data about the current node when broadcasting an alive message.
 func (d *delegate) NodeMeta(limit int) []byte {
-	return []byte{}
+	b, err := d.localMeta().encode(limit)
+	if err != nil {
+		d.logger.Warn("encode node meta", "err", err)
+		return []byte{}
+	}
+	return b
 }
 
 // NotifyMsg is the callback invoked when a user-level gossip message is received.
@@ -68,17 +75,10 @@ func (d *delegate) NotifyMsg(b []byte) {
 		d.logger.Warn("decode broadcast", "err", err)
 		return
 	}
-
-	d.mtx.RLock()
-	s, ok := d.states[p.Key]
-	d.mtx.RUnlock()
-
-	if !ok {
-		return
-	}
-	if err := s.Merge(p.Data); err != nil {
+	// The sender of a broadcast isn't known, parts of unknown states are
+	// only counted.
+	if err := d.mergePart(p); err != nil && !errors.Is(err, errUnknownKey) {
 		d.logger.Warn("merge broadcast", "err", err, "key", p.Key)
-		return
 	}
 }
 
@@ -98,24 +98,51 @@ func (d *delegate) NotifyAlive(_ *memberlist.Node) error {
 
 // GetBroadcasts is called when user data messages can be broadcasted.
 func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
-	return d.bcast.GetBroadcasts(overhead, limit)
+	return d.bcast.getBroadcasts(overhead, limit)
 }
 
-// LocalState is called when gossip fetches local state.
-func (d *delegate) LocalState(_ bool) []byte {
+// LocalState is called when gossip fetches local state. With digest
+// anti-entropy, states implementing BucketState are only sent in full on join
+// and while some members don't understand digests. Likewise, parts are only
+// compressed if all members accept it, as the receiver isn't known.
+func (d *delegate) LocalState(join bool) []byte {
+	digests := d.antiEntropy == AntiEntropyDigest
+	onlyDigests := digests && !join && d.allHaveFeature(understandsDigests)
+	compress := d.compress && !join && d.allHaveFeature(acceptsFlate)
+
 	d.mtx.RLock()
 	defer d.mtx.RUnlock()
 	all := &clusterpb.FullState{
-		Parts: make([]clusterpb.Part, 0, len(d.states)),
+		Parts:           make([]clusterpb.Part, 0, len(d.states)),
+		From:            d.mlist.LocalNode().Name,
+		AcceptEncodings: []clusterpb.Encoding{clusterpb.Encoding_FLATE},
 	}
 
 	for key, s := range d.states {
+		if bs, ok := s.(BucketState); ok && digests {
+			buckets, err := bs.BucketDigests(DigestBuckets)
+			if err != nil {
+				d.logger.Warn("encode local state digest", "err", err, "key", key)
+				return nil
+			}
+			all.Digests = append(all.Digests, clusterpb.StateDigest{Key: key, Buckets: buckets})
+			if onlyDigests {
+				continue
+			}
+		}
 		b, err := s.MarshalBinary()
 		if err != nil {
 			d.logger.Warn("encode local state", "err", err, "key", key)
 			return nil
 		}
-		all.Parts = append(all.Parts, clusterpb.Part{Key: key, Data: b})
+		part := clusterpb.Part{Key: key, Data: b}
+		if compress {
+			if err := compressPart(&part); err != nil {
+				d.logger.Warn("compress local state", "err", err, "key", key)
+				return nil
+			}
+		}
+		all.Parts = append(all.Parts, part)
 	}
 	b, err := proto.Marshal(all)
 	if err != nil {
@@ -125,24 +152,56 @@ func (d *delegate) LocalState(_ bool) []byte {
 	return b
 }
 
+// MergeRemoteState merges each part of a remote full state on its own, so
+// that a bad part doesn't prevent merging the others. In strict mode, a peer
+// sending a part which can't be merged is marked incompatible and its state
+// is ignored until it leaves. Peers which don't send their name can't be
+// marked, their parts which can't be merged are counted as unattributed.
 func (d *delegate) MergeRemoteState(buf []byte, _ bool) {
 	var fs clusterpb.FullState
 	if err := proto.Unmarshal(buf, &fs); err != nil {
 		d.logger.Warn("merge remote state", "err", err)
 		return
 	}
-	d.mtx.RLock()
-	defer d.mtx.RUnlock()
+	if d.strictStateKeys && fs.From != "" && d.merges.isIncompatible(fs.From) {
+		d.merges.quarantine(fs.Parts)
+		d.logger.Debug("ignoring remote state of incompatible peer", "peer", fs.From)
+		return
+	}
+	d.setFeatures(&fs)
+	if d.antiEntropy == AntiEntropyDigest && fs.From != "" && len(fs.Digests) > 0 {
+		// The parts are merged first, so that only entries the remote peer
+		// lacks are sent back. Sending must not block push/pull.
+		defer func() { go d.sendDivergent(fs.From, fs.Digests) }()
+	}
+
+	var (
+		errs       []error
+		failedKeys []string
+		failed     bool
+	)
 	for _, p := range fs.Parts {
-		s, ok := d.states[p.Key]
-		if !ok {
-			d.logger.Warn("unknown state key", "len", len(buf), "key", p.Key)
-			continue
+		if err := d.mergePart(p); err != nil {
+			d.logger.Warn("merge remote state", "err", err, "len", len(buf), "key", p.Key, "peer", fs.From)
+			errs = append(errs, fmt.Errorf("state %q: %w", p.Key, err))
+			failedKeys = append(failedKeys, p.Key)
+			// States the peer doesn't have can't be missed.
+			failed = failed || !errors.Is(err, errUnknownKey)
 		}
-		if err := s.Merge(p.Data); err != nil {
-			d.logger.Warn("merge remote state", "err", err, "key", p.Key)
-			return
+	}
+	if d.strictStateKeys && fs.From == "" && len(errs) > 0 {
+		// Older versions don't send their name, there is no peer to mark.
+		d.merges.unattributed(failedKeys)
+	}
+	if d.strictStateKeys && fs.From != "" && len(errs) > 0 {
+		err := errors.Join(errs...)
+		if d.merges.markIncompatible(fs.From, err) {
+			d.logger.Error("marking peer incompatible", "peer", fs.From, "err", err)
 		}
+		return
+	}
+	if !failed {
+		d.setSynced(fs.From)
 	}
 }
 
@@ -155,7 +214,24 @@ func (d *delegate) NotifyJoin(n *memberlist.Node) {
 // NotifyLeave is called if a peer leaves the cluster.
 func (d *delegate) NotifyLeave(n *memberlist.Node) {
 	d.logger.Debug("NotifyLeave", "node", n.Name, "addr", n.Address())
+	d.forgetPeer(n.Name)
 	d.peerLeave(n)
+	// Unlike a failed peer, a peer which left on purpose won't miss the
+	// messages which couldn't be sent to it.
+	if leftOnPurpose(n) {
+		d.retries.peerRemoved(n.Address())
+	}
+}
+
+// forgetPeer drops what is known about the features, state and compatibility
+// of a peer. It must be called when the peer leaves, it may come back with a
+// different version.
+func (d *delegate) forgetPeer(name string) {
+	d.featuresMtx.Lock()
+	delete(d.features, name)
+	delete(d.synced, name)
+	d.featuresMtx.Unlock()
+	d.merges.forget(name)
 }
 
 // NotifyUpdate is called if a cluster peer gets updated.
@@ -168,20 +244,3 @@ func (d *delegate) NotifyUpdate(n *memberlist.Node) {
 func (d *delegate) AckPayload() []byte {
 	return []byte{}
 }
-
-// handleQueueDepth ensures that the queue doesn't grow unbounded by pruning
-// older messages at regular interval.
-func (d *delegate) handleQueueDepth() {
-	for {
-		select {
-		case <-d.stopc:
-			return
-		case <-time.After(15 * time.Minute):
-			n := d.bcast.NumQueued()
-			if n > maxQueueSize {
-				d.logger.Warn("dropping messages because too many are queued", "current", n, "limit", maxQueueSize)
-				d.bcast.Prune(maxQueueSize)
-			}
-		}
-	}
-}
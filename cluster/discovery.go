@@ -0,0 +1,219 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/quartz"
+	"gopkg.in/yaml.v2"
+)
+
+// Discoverer finds the peers to join. It is used on start and on every
+// refresh, which joins the peers which aren't members.
+type Discoverer interface {
+	// Discover returns the host:port addresses of the peers.
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// Watcher is implemented by discoverers which notice when their peers
+// change. The peer refreshes on every value sent on the returned channel,
+// instead of waiting for the next refresh.
+type Watcher interface {
+	Watch(stopc <-chan struct{}) <-chan struct{}
+}
+
+// StaticDiscoverer resolves a fixed list of host:port peers via DNS A and
+// AAAA records. Hosts which can't be resolved are used as they are.
+type StaticDiscoverer struct {
+	Peers []string
+}
+
+// Discover implements Discoverer.
+func (d StaticDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	return resolvePeers(ctx, d.Peers, "", &net.Resolver{}, false)
+}
+
+// srvResolver is implemented by net.Resolver.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DNSSRVDiscoverer finds the peers in the DNS SRV records of Name, e.g.
+// _gossip._tcp.alertmanager.example.com. The targets are resolved to
+// addresses, so that they can be compared to the addresses of members.
+type DNSSRVDiscoverer struct {
+	Name string
+	// Logger gets the targets which don't resolve. It defaults to a logger
+	// which discards everything.
+	Logger *slog.Logger
+
+	resolver srvResolver
+}
+
+// Discover implements Discoverer.
+func (d DNSSRVDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	res := d.resolver
+	if res == nil {
+		res = &net.Resolver{}
+	}
+	_, srvs, err := res.LookupSRV(ctx, "", "", d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("SRV lookup of %s: %w", d.Name, err)
+	}
+	logger := d.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	var (
+		peers []string
+		errs  []error
+	)
+	for _, srv := range srvs {
+		port := strconv.Itoa(int(srv.Port))
+		target := strings.TrimSuffix(srv.Target, ".")
+		ips, err := res.LookupIPAddr(ctx, target)
+		if err != nil {
+			// A single stale record must not hide the other peers.
+			logger.Warn("skipping unresolvable SRV target", "name", d.Name, "target", target, "err", err)
+			errs = append(errs, fmt.Errorf("IP Addr lookup for SRV target %s: %w", target, err))
+			continue
+		}
+		for _, ip := range ips {
+			peers = append(peers, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	if len(peers) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return peers, nil
+}
+
+// peersFile is the format of the file read by FileDiscoverer. JSON is valid,
+// too.
+type peersFile struct {
+	Peers []string `yaml:"peers" json:"peers"`
+}
+
+// DefaultFilePollInterval is how often FileDiscoverer checks its file for
+// changes by default.
+const DefaultFilePollInterval = 5 * time.Second
+
+// FileDiscoverer reads the peers from a YAML or JSON file, which lists them
+// as host:port under "peers". The hosts are resolved like those of
+// StaticDiscoverer. The file is read on every refresh and watched for changes
+// in between, see WritePeersFile.
+type FileDiscoverer struct {
+	Path string
+	// PollInterval between checks of the file for changes,
+	// DefaultFilePollInterval by default.
+	PollInterval time.Duration
+	// Clock defaults to the real clock.
+	Clock quartz.Clock
+}
+
+// Discover implements Discoverer.
+func (d FileDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	b, err := os.ReadFile(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	var f peersFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, fmt.Errorf("parse peers file %s: %w", d.Path, err)
+	}
+	return StaticDiscoverer{Peers: f.Peers}.Discover(ctx)
+}
+
+// Watch implements Watcher. It sends when the content of the file changed.
+func (d FileDiscoverer) Watch(stopc <-chan struct{}) <-chan struct{} {
+	interval := d.PollInterval
+	if interval == 0 {
+		interval = DefaultFilePollInterval
+	}
+	clock := d.Clock
+	if clock == nil {
+		clock = quartz.NewReal()
+	}
+	// Changes from now on are sent, even before the goroutine runs.
+	last, _ := os.ReadFile(d.Path)
+	changed := make(chan struct{}, 1)
+	go func() {
+		tick := clock.NewTicker(interval, "FileDiscoverer", "Watch")
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-stopc:
+				return
+			case <-tick.C:
+			}
+			b, err := os.ReadFile(d.Path)
+			if err != nil || bytes.Equal(b, last) {
+				continue
+			}
+			last = b
+			signal(changed)
+		}
+	}()
+	return changed
+}
+
+// WritePeersFile atomically replaces the file at path by one listing peers,
+// which FileDiscoverer reads.
+func WritePeersFile(path string, peers []string) error {
+	b, err := yaml.Marshal(peersFile{Peers: peers})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// discoverPeers returns the peers found by d. With waitIfEmpty, it retries
+// until at least one peer was found or ctx is done.
+func discoverPeers(ctx context.Context, d Discoverer, waitIfEmpty bool) ([]string, error) {
+	var peers []string
+	err := retry(2*time.Second, ctx.Done(), func() error {
+		var err error
+		peers, err = d.Discover(ctx)
+		if err == nil && len(peers) == 0 && waitIfEmpty {
+			return errors.New("no peers discovered. Retrying")
+		}
+		return err
+	})
+	return peers, err
+}
@@ -0,0 +1,149 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSRVResolver struct {
+	srvs []*net.SRV
+	ips  map[string][]net.IPAddr
+}
+
+func (r fakeSRVResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	if name != "_gossip._tcp.am.example.com" {
+		return "", nil, errors.New("no such host")
+	}
+	return name, r.srvs, nil
+}
+
+func (r fakeSRVResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.ips[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+func TestDNSSRVDiscoverer(t *testing.T) {
+	res := fakeSRVResolver{
+		srvs: []*net.SRV{
+			{Target: "am-0.example.com.", Port: 9094},
+			{Target: "am-1.example.com.", Port: 9095},
+		},
+		ips: map[string][]net.IPAddr{
+			"am-0.example.com": {{IP: net.ParseIP("10.0.0.1")}},
+			"am-1.example.com": {{IP: net.ParseIP("10.0.0.2")}, {IP: net.ParseIP("::1")}},
+		},
+	}
+	peers, err := DNSSRVDiscoverer{Name: "_gossip._tcp.am.example.com", resolver: res}.Discover(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:9094", "10.0.0.2:9095", "[::1]:9095"}, peers)
+
+	_, err = DNSSRVDiscoverer{Name: "unknown", resolver: res}.Discover(context.Background())
+	require.Error(t, err)
+
+	// A target which doesn't resolve is skipped, unless no target resolves.
+	res.srvs = append(res.srvs, &net.SRV{Target: "gone.example.com.", Port: 9096})
+	peers, err = DNSSRVDiscoverer{Name: "_gossip._tcp.am.example.com", resolver: res}.Discover(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:9094", "10.0.0.2:9095", "[::1]:9095"}, peers)
+
+	res.srvs = res.srvs[2:]
+	_, err = DNSSRVDiscoverer{Name: "_gossip._tcp.am.example.com", resolver: res}.Discover(context.Background())
+	require.Error(t, err)
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.yml")
+	d := FileDiscoverer{Path: path}
+	_, err := d.Discover(context.Background())
+	require.Error(t, err)
+
+	require.NoError(t, WritePeersFile(path, []string{"127.0.0.1:9094", "127.0.0.1:9095"}))
+	peers, err := d.Discover(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"127.0.0.1:9094", "127.0.0.1:9095"}, peers)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"peers": ["127.0.0.1:9096"]}`), 0o600))
+	peers, err = d.Discover(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"127.0.0.1:9096"}, peers)
+
+	require.NoError(t, os.WriteFile(path, []byte("hosts: []"), 0o600))
+	_, err = d.Discover(context.Background())
+	require.ErrorContains(t, err, "parse peers file")
+}
+
+func TestFileDiscovererWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "peers.yml")
+	require.NoError(t, WritePeersFile(path, []string{"127.0.0.1:9094"}))
+	clock := quartz.NewMock(t)
+	trap := clock.Trap().NewTicker("FileDiscoverer", "Watch")
+	defer trap.Close()
+
+	stopc := make(chan struct{})
+	defer close(stopc)
+	changed := FileDiscoverer{Path: path, PollInterval: time.Second, Clock: clock}.Watch(stopc)
+	trap.MustWait(ctx).MustRelease(ctx)
+
+	// Rewriting the same peers isn't a change.
+	require.NoError(t, WritePeersFile(path, []string{"127.0.0.1:9094"}))
+	clock.Advance(time.Second).MustWait(ctx)
+	require.NoError(t, WritePeersFile(path, []string{"127.0.0.1:9095"}))
+	clock.Advance(time.Second).MustWait(ctx)
+	select {
+	case <-changed:
+	case <-ctx.Done():
+		t.Fatal("no change was sent")
+	}
+	select {
+	case <-changed:
+		t.Fatal("unexpected change")
+	default:
+	}
+}
+
+func TestPeerJoinsDiscoveredPeers(t *testing.T) {
+	create := func(d Discoverer) *Peer {
+		o := testOptions()
+		o.Discoverer = d
+		p := newTestPeer(t, o)
+		require.NoError(t, p.Join(0, 0))
+		return p
+	}
+	p1 := create(nil)
+	p2 := create(nil)
+
+	path := filepath.Join(t.TempDir(), "peers.yml")
+	require.NoError(t, WritePeersFile(path, []string{p1.Self().Address()}))
+	p3 := create(FileDiscoverer{Path: path, PollInterval: 10 * time.Millisecond})
+	require.Equal(t, 2, p3.ClusterSize())
+
+	// A peer added to the file is joined before the next refresh.
+	require.NoError(t, WritePeersFile(path, []string{p1.Self().Address(), p2.Self().Address()}))
+	require.Eventually(t, func() bool { return p2.ClusterSize() == 3 }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,148 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// EventType is the kind of a membership change.
+type EventType string
+
+const (
+	// EventJoined is sent for a node seen for the first time.
+	EventJoined EventType = "joined"
+	// EventLeft is sent for a node which left the cluster on purpose. Nodes
+	// which don't announce leaving in their NodeMeta, like older versions,
+	// are reported as failed instead.
+	EventLeft EventType = "left"
+	// EventFailed is sent for a node which stopped responding.
+	EventFailed EventType = "failed"
+	// EventRejoined is sent for a node which joined again after it left or
+	// failed.
+	EventRejoined EventType = "rejoined"
+	// EventUpdated is sent when the meta of a node changed.
+	EventUpdated EventType = "updated"
+	// EventRemoved is sent for a failed node which didn't rejoin within the
+	// reconnect timeout and isn't reconnected to anymore.
+	EventRemoved EventType = "removed"
+	// EventSettled is sent for the peer itself once Settle finished.
+	EventSettled EventType = "settled"
+)
+
+// Event is a membership change seen by a peer.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Name and Address of the node. The name of a removed node which never
+	// joined is empty.
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Settle is the outcome of Settle for EventSettled.
+	Settle *SettleStatus `json:"settle,omitempty"`
+}
+
+// eventHub sends the events of a peer to its subscribers.
+type eventHub struct {
+	logger *slog.Logger
+
+	mtx    sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+func newEventHub(l *slog.Logger) *eventHub {
+	return &eventHub{logger: l, subs: map[chan Event]struct{}{}}
+}
+
+// publish never blocks, events are dropped for subscribers which fell
+// behind.
+func (h *eventHub) publish(e Event) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for c := range h.subs {
+		select {
+		case c <- e:
+		default:
+			h.logger.Warn("dropping cluster event for slow subscriber", "type", e.Type, "node", e.Name)
+		}
+	}
+}
+
+func (h *eventHub) subscribe(buffer int) (<-chan Event, func()) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	c := make(chan Event, buffer)
+	if h.closed {
+		close(c)
+		return c, func() {}
+	}
+	h.subs[c] = struct{}{}
+	return c, func() {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		if _, ok := h.subs[c]; ok {
+			delete(h.subs, c)
+			close(c)
+		}
+	}
+}
+
+// close ends all subscriptions.
+func (h *eventHub) close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.closed = true
+	for c := range h.subs {
+		delete(h.subs, c)
+		close(c)
+	}
+}
+
+// Subscribe returns a channel receiving the membership events from now on
+// and a function ending the subscription, which closes the channel. It is
+// closed when the peer leaves, too. Events don't block the peer, they are
+// dropped if the channel already buffers buffer events.
+func (p *Peer) Subscribe(buffer int) (<-chan Event, func()) {
+	return p.events.subscribe(buffer)
+}
+
+// publishNode sends an event about n to the subscribers.
+func (p *Peer) publishNode(t EventType, n *memberlist.Node) {
+	p.events.publish(Event{Type: t, Time: p.clock.Now(), Name: n.Name, Address: n.Address()})
+}
+
+// WaitForEvent returns the first event on events for which match returns
+// true.
+func WaitForEvent(ctx context.Context, events <-chan Event, match func(Event) bool) (Event, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				return Event{}, errors.New("event stream closed")
+			}
+			if match(e) {
+				return e, nil
+			}
+		}
+	}
+}
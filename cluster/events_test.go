@@ -0,0 +1,137 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembershipEvents(t *testing.T) {
+	clock := quartz.NewMock(t)
+	o := testOptions()
+	o.Clock = clock
+	p := newTestPeer(t, o)
+	events, cancel := p.Subscribe(10)
+	defer cancel()
+
+	leaving, err := NodeMeta{Leaving: true}.encode(memberlist.MetaMaxSize)
+	require.NoError(t, err)
+
+	n := &memberlist.Node{Name: "other", Addr: net.ParseIP("10.0.0.2"), Port: 9094}
+	p.peerJoin(n)
+	p.peerLeave(n)
+	clock.Advance(time.Minute)
+	p.peerJoin(n)
+	n.Meta = leaving
+	p.peerUpdate(n)
+	p.peerLeave(n)
+	p.removeFailedPeers(0)
+
+	var got []EventType
+	for range 6 {
+		e := <-events
+		require.Equal(t, "other", e.Name)
+		require.Equal(t, "10.0.0.2:9094", e.Address)
+		got = append(got, e.Type)
+	}
+	require.Equal(t, []EventType{EventJoined, EventFailed, EventRejoined, EventUpdated, EventLeft, EventRemoved}, got)
+	require.NotContains(t, p.peers, "10.0.0.2:9094")
+	require.Empty(t, p.failedPeers)
+}
+
+func TestJoinedThroughKnownPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p1 := newTestPeer(t, testOptions())
+	o2 := testOptions()
+	o2.KnownPeers = []string{p1.Self().Address()}
+	p2 := newTestPeer(t, o2)
+	events, unsubscribe := p2.Subscribe(10)
+	defer unsubscribe()
+
+	// Known peers start out as failed, their first join isn't a rejoin.
+	require.NoError(t, p2.Join(DefaultReconnectInterval, DefaultReconnectTimeout))
+	e, err := WaitForEvent(ctx, events, func(e Event) bool { return e.Name == p1.Name() })
+	require.NoError(t, err)
+	require.Equal(t, EventJoined, e.Type)
+}
+
+func TestSettledEvent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	o := testOptions()
+	o.Clock = quartz.NewMock(t)
+	p := newTestPeer(t, o)
+	events, unsubscribe := p.Subscribe(1)
+	defer unsubscribe()
+
+	settleCtx, stop := context.WithCancel(ctx)
+	stop()
+	p.Settle(settleCtx, time.Minute)
+	e, err := WaitForEvent(ctx, events, func(e Event) bool { return e.Type == EventSettled })
+	require.NoError(t, err)
+	require.Equal(t, p.Name(), e.Name)
+	require.Equal(t, SettleOutcomeGaveUp, e.Settle.Outcome)
+}
+
+// drain returns the types of the events until the channel is closed.
+func drain(events <-chan Event) []EventType {
+	var types []EventType
+	for e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestSubscriptions(t *testing.T) {
+	o := testOptions()
+	o.Clock = quartz.NewMock(t)
+	p := newTestPeer(t, o)
+	n := &memberlist.Node{Name: "other", Addr: net.ParseIP("10.0.0.2"), Port: 9094}
+
+	// A slow subscriber doesn't block the peer.
+	slow, cancelSlow := p.Subscribe(1)
+	p.peerJoin(n)
+	p.peerUpdate(n)
+	require.Equal(t, EventJoined, (<-slow).Type)
+	select {
+	case e := <-slow:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+	cancelSlow()
+	_, ok := <-slow
+	require.False(t, ok)
+	cancelSlow()
+
+	// Leaving announces it in the meta of the peer first, the last event
+	// is about the peer leaving.
+	events, cancel := p.Subscribe(2)
+	defer cancel()
+	require.NoError(t, p.Leave(0))
+	require.Equal(t, []EventType{EventUpdated, EventLeft}, drain(events))
+	_, err := WaitForEvent(context.Background(), events, func(Event) bool { return true })
+	require.Error(t, err)
+
+	events, _ = p.Subscribe(1)
+	_, ok = <-events
+	require.False(t, ok)
+}
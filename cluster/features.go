@@ -0,0 +1,83 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+// peerFeatures are the optional features a peer announced in its full state.
+// Peers which never sent a full state are assumed to support none of them.
+type peerFeatures struct {
+	digests bool
+	flate   bool
+}
+
+// setFeatures records the features of the sender of fs.
+func (d *delegate) setFeatures(fs *clusterpb.FullState) {
+	if fs.From == "" {
+		return
+	}
+	d.featuresMtx.Lock()
+	defer d.featuresMtx.Unlock()
+	d.features[fs.From] = peerFeatures{
+		digests: len(fs.Digests) > 0,
+		flate:   slices.Contains(fs.AcceptEncodings, clusterpb.Encoding_FLATE),
+	}
+}
+
+// hasFeature reports whether the peer with the given name supports a feature.
+func (d *delegate) hasFeature(name string, f func(peerFeatures) bool) bool {
+	d.featuresMtx.Lock()
+	defer d.featuresMtx.Unlock()
+	return f(d.features[name])
+}
+
+// allHaveFeature reports whether all other members support a feature.
+func (d *delegate) allHaveFeature(f func(peerFeatures) bool) bool {
+	d.featuresMtx.Lock()
+	defer d.featuresMtx.Unlock()
+	self := d.mlist.LocalNode().Name
+	for _, n := range d.mlist.Members() {
+		if n.Name != self && !f(d.features[n.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func understandsDigests(f peerFeatures) bool { return f.digests }
+func acceptsFlate(f peerFeatures) bool       { return f.flate }
+
+// setSynced records that the full state of the peer with the given name was
+// merged.
+func (d *delegate) setSynced(name string) {
+	if name == "" {
+		return
+	}
+	d.featuresMtx.Lock()
+	defer d.featuresMtx.Unlock()
+	d.synced[name] = true
+}
+
+// syncedPeers returns the names of the peers whose full state was merged
+// since they joined.
+func (d *delegate) syncedPeers() map[string]bool {
+	d.featuresMtx.Lock()
+	defer d.featuresMtx.Unlock()
+	return maps.Clone(d.synced)
+}
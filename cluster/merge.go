@@ -0,0 +1,175 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+// errUnknownKey is returned for parts of a state which wasn't added.
+var errUnknownKey = errors.New("unknown state key")
+
+// MergeStats are the counters of the parts received for a state key.
+type MergeStats struct {
+	// Merged parts were applied to the state.
+	Merged uint64 `json:"merged"`
+	// Failed parts couldn't be decoded or merged.
+	Failed uint64 `json:"failed"`
+	// Unknown parts were received for a state which wasn't added, e.g. by a
+	// peer of a newer version.
+	Unknown uint64 `json:"unknown"`
+	// Quarantined parts were ignored as they came from an incompatible peer.
+	Quarantined uint64 `json:"quarantined"`
+	// Unattributed parts are the Failed and Unknown ones in strict mode from
+	// peers which don't send their name, so they can't be marked
+	// incompatible.
+	Unattributed uint64 `json:"unattributed"`
+}
+
+// mergeTracker counts the merged parts by state key and holds the peers
+// which were found incompatible in strict mode.
+type mergeTracker struct {
+	mtx          sync.Mutex
+	stats        map[string]*MergeStats
+	incompatible map[string]string
+}
+
+func newMergeTracker() *mergeTracker {
+	return &mergeTracker{
+		stats:        map[string]*MergeStats{},
+		incompatible: map[string]string{},
+	}
+}
+
+// record counts a part of the given key by the error merging it.
+func (t *mergeTracker) record(key string, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	s := t.statsOf(key)
+	switch {
+	case err == nil:
+		s.Merged++
+	case errors.Is(err, errUnknownKey):
+		s.Unknown++
+	default:
+		s.Failed++
+	}
+}
+
+// quarantine counts the parts of a full state which were ignored.
+func (t *mergeTracker) quarantine(parts []clusterpb.Part) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, p := range parts {
+		t.statsOf(p.Key).Quarantined++
+	}
+}
+
+// unattributed counts the parts of the given keys which couldn't be merged
+// and whose sender is unknown.
+func (t *mergeTracker) unattributed(keys []string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, key := range keys {
+		t.statsOf(key).Unattributed++
+	}
+}
+
+// statsOf must be called with t.mtx held.
+func (t *mergeTracker) statsOf(key string) *MergeStats {
+	s, ok := t.stats[key]
+	if !ok {
+		s = &MergeStats{}
+		t.stats[key] = s
+	}
+	return s
+}
+
+// markIncompatible records why the peer with the given name is incompatible.
+// It reports whether the peer wasn't marked before.
+func (t *mergeTracker) markIncompatible(name string, err error) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if _, ok := t.incompatible[name]; ok {
+		return false
+	}
+	t.incompatible[name] = err.Error()
+	return true
+}
+
+func (t *mergeTracker) isIncompatible(name string) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	_, ok := t.incompatible[name]
+	return ok
+}
+
+// forget clears the incompatibility of a peer.
+func (t *mergeTracker) forget(name string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.incompatible, name)
+}
+
+func (t *mergeTracker) snapshot() (map[string]MergeStats, map[string]string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	stats := make(map[string]MergeStats, len(t.stats))
+	for k, s := range t.stats {
+		stats[k] = *s
+	}
+	return stats, maps.Clone(t.incompatible)
+}
+
+// mergePart merges a received part into its state and counts the outcome.
+func (d *delegate) mergePart(p clusterpb.Part) error {
+	err := d.doMergePart(p)
+	d.merges.record(p.Key, err)
+	return err
+}
+
+func (d *delegate) doMergePart(p clusterpb.Part) error {
+	d.mtx.RLock()
+	s, ok := d.states[p.Key]
+	d.mtx.RUnlock()
+	if !ok {
+		return errUnknownKey
+	}
+	data, err := partData(p)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	if err := s.Merge(data); err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+	return nil
+}
+
+// MergeStats returns the counters of the received parts by their state key.
+func (p *Peer) MergeStats() map[string]MergeStats {
+	stats, _ := p.merges.snapshot()
+	return stats
+}
+
+// IncompatiblePeers returns why peers were found incompatible by their name.
+// It is only populated with Options.StrictStateKeys.
+func (p *Peer) IncompatiblePeers() map[string]string {
+	_, incompatible := p.merges.snapshot()
+	return incompatible
+}
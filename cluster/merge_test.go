@@ -0,0 +1,130 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+// addMapStates adds the states "a" and "b" to p.
+func addMapStates(p *Peer) (a, b *mapState) {
+	a, b = &mapState{entries: map[string]string{}}, &mapState{entries: map[string]string{}}
+	p.AddState("a", a)
+	p.AddState("b", b)
+	return a, b
+}
+
+func marshalFullState(t *testing.T, from string, parts ...clusterpb.Part) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&clusterpb.FullState{From: from, Parts: parts})
+	require.NoError(t, err)
+	return b
+}
+
+func TestMergeRemoteStateIsolatesParts(t *testing.T) {
+	o := testOptions()
+	p := newTestPeer(t, o)
+	a, b := addMapStates(p)
+	p.delegate.MergeRemoteState(marshalFullState(t, "other",
+		clusterpb.Part{Key: "unknown", Data: []byte("{}")},
+		clusterpb.Part{Key: "a", Data: []byte("invalid")},
+		clusterpb.Part{Key: "b", Data: []byte(`{"x":"1"}`)},
+	), false)
+	require.Equal(t, map[string]string{"x": "1"}, b.entries)
+	require.Empty(t, a.entries)
+
+	p.delegate.NotifyMsg(marshalPart(t, clusterpb.Part{Key: "a", Data: []byte(`{"y":"2"}`)}))
+	p.delegate.NotifyMsg(marshalPart(t, clusterpb.Part{Key: "unknown"}))
+	require.Equal(t, map[string]string{"y": "2"}, a.entries)
+
+	require.Equal(t, map[string]MergeStats{
+		"a":       {Merged: 1, Failed: 1},
+		"b":       {Merged: 1},
+		"unknown": {Unknown: 2},
+	}, p.MergeStats())
+	// Without strict mode, peers are never incompatible.
+	require.Empty(t, p.IncompatiblePeers())
+}
+
+func TestStrictStateKeys(t *testing.T) {
+	o := testOptions()
+	o.StrictStateKeys = true
+	p := newTestPeer(t, o)
+	a, _ := addMapStates(p)
+	p.delegate.MergeRemoteState(marshalFullState(t, "newer",
+		clusterpb.Part{Key: "a", Data: []byte(`{"x":"1"}`)},
+		clusterpb.Part{Key: "unknown", Data: []byte("{}")},
+	), false)
+	require.Contains(t, p.IncompatiblePeers(), "newer")
+	require.Contains(t, p.IncompatiblePeers()["newer"], "unknown state key")
+	// The parts it sent before being marked were merged.
+	require.Equal(t, map[string]string{"x": "1"}, a.entries)
+
+	// The state of an incompatible peer is ignored.
+	p.delegate.MergeRemoteState(marshalFullState(t, "newer",
+		clusterpb.Part{Key: "a", Data: []byte(`{"y":"2"}`)},
+	), false)
+	require.Equal(t, map[string]string{"x": "1"}, a.entries)
+	require.Equal(t, uint64(1), p.MergeStats()["a"].Quarantined)
+
+	// Other peers aren't affected.
+	p.delegate.MergeRemoteState(marshalFullState(t, "older",
+		clusterpb.Part{Key: "a", Data: []byte(`{"z":"3"}`)},
+	), false)
+	require.Equal(t, map[string]string{"x": "1", "z": "3"}, a.entries)
+	require.NotContains(t, p.IncompatiblePeers(), "older")
+
+	// After leaving, the peer may come back with a compatible version.
+	p.merges.forget("newer")
+	p.delegate.MergeRemoteState(marshalFullState(t, "newer",
+		clusterpb.Part{Key: "a", Data: []byte(`{"y":"2"}`)},
+	), false)
+	require.Equal(t, map[string]string{"x": "1", "y": "2", "z": "3"}, a.entries)
+	require.Empty(t, p.IncompatiblePeers())
+}
+
+func TestMergeStats(t *testing.T) {
+	o := testOptions()
+	o.StrictStateKeys = true
+	p := newTestPeer(t, o)
+	addMapStates(p)
+	p.delegate.MergeRemoteState(marshalFullState(t, "newer",
+		clusterpb.Part{Key: "unknown", Data: []byte("{}")},
+	), false)
+	require.Equal(t, map[string]MergeStats{"unknown": {Unknown: 1}}, p.MergeStats())
+	require.Contains(t, p.IncompatiblePeers(), "newer")
+
+	// A peer which doesn't send its name can't be marked.
+	p.delegate.MergeRemoteState(marshalFullState(t, "",
+		clusterpb.Part{Key: "unknown", Data: []byte("{}")},
+		clusterpb.Part{Key: "a", Data: []byte("invalid")},
+	), false)
+	require.Equal(t, map[string]MergeStats{
+		"a":       {Failed: 1, Unattributed: 1},
+		"unknown": {Unknown: 2, Unattributed: 1},
+	}, p.MergeStats())
+	require.Len(t, p.IncompatiblePeers(), 1)
+}
+
+func marshalPart(t *testing.T, p clusterpb.Part) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&p)
+	require.NoError(t, err)
+	return b
+}
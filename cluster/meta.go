@@ -0,0 +1,156 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/hashicorp/memberlist"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+// nodeMetaFormat is the format of the encoded NodeMeta.
+const nodeMetaFormat = 1
+
+// Optional protocol features announced in NodeMeta.
+const (
+	// FeatureDigests is announced by peers using AntiEntropyDigest.
+	FeatureDigests = "digests"
+	// FeatureCompression is announced by peers which accept compressed parts.
+	FeatureCompression = "compression"
+)
+
+// NodeMeta describes a peer to the others. It is empty for peers of older
+// versions.
+type NodeMeta struct {
+	Version   string    `json:"version"`
+	StateKeys []string  `json:"stateKeys"`
+	Features  []string  `json:"features"`
+	StartTime time.Time `json:"startTime"`
+	Role      string    `json:"role,omitempty"`
+	Zone      string    `json:"zone,omitempty"`
+	// Leaving is set by a peer shortly before it leaves the cluster on
+	// purpose, so the others can tell it from a failed one.
+	Leaving bool `json:"leaving,omitempty"`
+}
+
+// HasFeature reports whether the peer announced the feature.
+func (m NodeMeta) HasFeature(f string) bool {
+	return slices.Contains(m.Features, f)
+}
+
+// encode serializes the meta in at most limit bytes. The state keys are left
+// out if they don't fit.
+func (m NodeMeta) encode(limit int) ([]byte, error) {
+	pm := &clusterpb.NodeMeta{
+		Format:    nodeMetaFormat,
+		Version:   m.Version,
+		StateKeys: m.StateKeys,
+		Features:  m.Features,
+		StartTime: m.StartTime.UnixMilli(),
+		Role:      m.Role,
+		Zone:      m.Zone,
+		Leaving:   m.Leaving,
+	}
+	if pm.Size() > limit {
+		pm.StateKeys = nil
+	}
+	if pm.Size() > limit {
+		return nil, fmt.Errorf("node meta of %d bytes exceeds limit of %d bytes", pm.Size(), limit)
+	}
+	return proto.Marshal(pm)
+}
+
+// decodeNodeMeta parses the meta of a node. Empty meta, sent by older peers,
+// results in an empty NodeMeta.
+func decodeNodeMeta(b []byte) (NodeMeta, error) {
+	if len(b) == 0 {
+		return NodeMeta{}, nil
+	}
+	var pm clusterpb.NodeMeta
+	if err := proto.Unmarshal(b, &pm); err != nil {
+		return NodeMeta{}, err
+	}
+	if pm.Format != nodeMetaFormat {
+		return NodeMeta{}, fmt.Errorf("unknown node meta format %d", pm.Format)
+	}
+	return NodeMeta{
+		Version:   pm.Version,
+		StateKeys: pm.StateKeys,
+		Features:  pm.Features,
+		StartTime: time.UnixMilli(pm.StartTime).UTC(),
+		Role:      pm.Role,
+		Zone:      pm.Zone,
+		Leaving:   pm.Leaving,
+	}, nil
+}
+
+// fromOlderVersion reports whether the meta is the empty one of a peer of an
+// older version. Newer peers always announce their start time.
+func (m NodeMeta) fromOlderVersion() bool {
+	return m.StartTime.IsZero()
+}
+
+// localMeta returns the meta of the peer itself.
+func (p *Peer) localMeta() NodeMeta {
+	m := NodeMeta{
+		Version:   p.version,
+		Features:  []string{FeatureCompression},
+		StartTime: p.startTime,
+		Role:      p.role,
+		Zone:      p.zone,
+		Leaving:   p.leaving.Load(),
+	}
+	if p.antiEntropy == AntiEntropyDigest {
+		m.Features = append(m.Features, FeatureDigests)
+	}
+	p.mtx.RLock()
+	m.StateKeys = slices.Sorted(maps.Keys(p.states))
+	p.mtx.RUnlock()
+	return m
+}
+
+// leftOnPurpose reports whether the node announced that it leaves the
+// cluster before it was gone. memberlist doesn't tell a node which left from
+// one which failed, the state of the node it passes on is always alive.
+func leftOnPurpose(n *memberlist.Node) bool {
+	meta, _ := decodeNodeMeta(n.Meta)
+	return meta.Leaving
+}
+
+// buildVersion returns the version of the main module.
+func buildVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		return bi.Main.Version
+	}
+	return ""
+}
+
+// Versions groups the names of members by the version in their meta. More
+// than one version means that the cluster runs mixed versions, members of
+// older versions are grouped under the empty version.
+func Versions(members []ClusterMember) map[string][]string {
+	versions := map[string][]string{}
+	for _, m := range members {
+		v := m.Meta().Version
+		versions[v] = append(versions[v], m.Name())
+	}
+	return versions
+}
@@ -0,0 +1,97 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeMetaEncoding(t *testing.T) {
+	m := NodeMeta{
+		Version:   "v0.1.0",
+		StateKeys: []string{"nfl", "sil"},
+		Features:  []string{FeatureCompression, FeatureDigests},
+		StartTime: time.UnixMilli(1700000000000).UTC(),
+		Role:      "primary",
+		Zone:      "eu-1",
+		Leaving:   true,
+	}
+	b, err := m.encode(512)
+	require.NoError(t, err)
+	got, err := decodeNodeMeta(b)
+	require.NoError(t, err)
+	require.Equal(t, m, got)
+	require.True(t, got.HasFeature(FeatureDigests))
+
+	// The state keys are left out first.
+	m.StateKeys = []string{strings.Repeat("k", 500)}
+	b, err = m.encode(512)
+	require.NoError(t, err)
+	got, err = decodeNodeMeta(b)
+	require.NoError(t, err)
+	require.Empty(t, got.StateKeys)
+	require.Equal(t, m.Version, got.Version)
+
+	m.Role = strings.Repeat("r", 500)
+	_, err = m.encode(512)
+	require.Error(t, err)
+
+	// Older peers send no meta.
+	got, err = decodeNodeMeta(nil)
+	require.NoError(t, err)
+	require.Equal(t, NodeMeta{}, got)
+
+	_, err = decodeNodeMeta([]byte{0x08, 0x02})
+	require.Error(t, err)
+}
+
+func TestPeersMeta(t *testing.T) {
+	create := func(version, role string) *Peer {
+		o := testOptions()
+		o.Version = version
+		o.Role = role
+		return newTestPeer(t, o)
+	}
+	p1 := create("v1", "primary")
+	// The node of p1 is rewritten while AddState announces the state key.
+	addr := p1.Self().Address()
+	p1.AddState("nfl", bytesState("nfl"))
+	p2 := create("v2", "")
+	_, err := p2.mlist.Join([]string{addr})
+	require.NoError(t, err)
+
+	var meta map[string]NodeMeta
+	require.Eventually(t, func() bool {
+		meta = map[string]NodeMeta{}
+		for _, m := range p2.Peers() {
+			meta[m.Name()] = m.Meta()
+		}
+		return len(meta[p1.Name()].StateKeys) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "v1", meta[p1.Name()].Version)
+	require.Equal(t, "primary", meta[p1.Name()].Role)
+	require.Equal(t, []string{"nfl"}, meta[p1.Name()].StateKeys)
+	require.True(t, meta[p1.Name()].HasFeature(FeatureCompression))
+	require.Equal(t, "v2", meta[p2.Name()].Version)
+	require.Empty(t, meta[p2.Name()].StateKeys)
+
+	require.Equal(t, map[string][]string{
+		"v1": {p1.Name()},
+		"v2": {p2.Name()},
+	}, Versions(p2.Peers()))
+}
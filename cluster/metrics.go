@@ -0,0 +1,78 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	broadcastsQueuedDesc = prometheus.NewDesc(
+		"alertmanager_cluster_broadcasts_queued",
+		"Number of broadcasts of a state waiting to be gossiped.",
+		[]string{"key", "priority"}, nil,
+	)
+	broadcastsSentDesc = prometheus.NewDesc(
+		"alertmanager_cluster_broadcasts_sent_total",
+		"Total number of broadcasts of a state which were gossiped.",
+		[]string{"key", "priority"}, nil,
+	)
+	broadcastsSupersededDesc = prometheus.NewDesc(
+		"alertmanager_cluster_broadcasts_superseded_total",
+		"Total number of broadcasts of a state which were replaced by a newer one.",
+		[]string{"key", "priority"}, nil,
+	)
+	broadcastsDroppedDesc = prometheus.NewDesc(
+		"alertmanager_cluster_broadcasts_dropped_total",
+		"Total number of broadcasts of a state which were dropped because the queue was full.",
+		[]string{"key", "priority"}, nil,
+	)
+	broadcastsDroppedOversizedDesc = prometheus.NewDesc(
+		"alertmanager_cluster_broadcasts_dropped_oversized_total",
+		"Total number of oversized messages of a state which were dropped because too many were pending.",
+		[]string{"key", "priority"}, nil,
+	)
+)
+
+// broadcastCollector exports Peer.BroadcastStats.
+type broadcastCollector struct {
+	peer *Peer
+}
+
+// NewBroadcastCollector returns a collector of the broadcast counters of p,
+// see Peer.BroadcastStats.
+func NewBroadcastCollector(p *Peer) prometheus.Collector {
+	return broadcastCollector{peer: p}
+}
+
+func (c broadcastCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- broadcastsQueuedDesc
+	ch <- broadcastsSentDesc
+	ch <- broadcastsSupersededDesc
+	ch <- broadcastsDroppedDesc
+	ch <- broadcastsDroppedOversizedDesc
+}
+
+func (c broadcastCollector) Collect(ch chan<- prometheus.Metric) {
+	for key, s := range c.peer.BroadcastStats() {
+		labels := []string{key, strconv.Itoa(s.Priority)}
+		ch <- prometheus.MustNewConstMetric(broadcastsQueuedDesc, prometheus.GaugeValue, float64(s.Queued), labels...)
+		ch <- prometheus.MustNewConstMetric(broadcastsSentDesc, prometheus.CounterValue, float64(s.Sent), labels...)
+		ch <- prometheus.MustNewConstMetric(broadcastsSupersededDesc, prometheus.CounterValue, float64(s.Superseded), labels...)
+		ch <- prometheus.MustNewConstMetric(broadcastsDroppedDesc, prometheus.CounterValue, float64(s.Dropped), labels...)
+		ch <- prometheus.MustNewConstMetric(broadcastsDroppedOversizedDesc, prometheus.CounterValue, float64(s.DroppedOversized), labels...)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/hashicorp/memberlist"
+)
+
+// Options configures a peer created by Create. Durations and limits are used
+// as given, so start from DefaultOptions. Only unset Logger, Clock,
+// Priorities, Version and SettlePolicy are replaced by their defaults.
+type Options struct {
+	// Logger defaults to a logger which discards everything.
+	Logger *slog.Logger
+	// BindAddr is the host:port to listen on for gossip.
+	BindAddr string
+	// AdvertiseAddr is the host:port announced to other peers. If empty, it
+	// is derived from BindAddr.
+	AdvertiseAddr string
+	// KnownPeers are the initial peers to join.
+	KnownPeers []string
+	// Discoverer finds the peers to join instead of KnownPeers, on start and
+	// on every refresh.
+	Discoverer Discoverer
+	// WaitIfEmpty retries the resolution of KnownPeers, or the discovery,
+	// until at least one address was found or ResolvePeersTimeout expired.
+	WaitIfEmpty bool
+
+	PushPullInterval    time.Duration
+	GossipInterval      time.Duration
+	TCPTimeout          time.Duration
+	ResolvePeersTimeout time.Duration
+	ProbeTimeout        time.Duration
+	ProbeInterval       time.Duration
+
+	// TLSTransportConfig enables TLS for gossip if set.
+	TLSTransportConfig *TLSTransportConfig
+	// Transport replaces the default UDP and TCP transport of memberlist,
+	// e.g. by an in-memory one in tests. It excludes TLSTransportConfig.
+	Transport memberlist.Transport
+	// AllowInsecureAdvertise allows advertising a public address while
+	// binding to an unspecified one.
+	AllowInsecureAdvertise bool
+	// Label is prepended to all packets and must match on all peers.
+	Label string
+	// Name of the peer. A random ULID is used if empty.
+	Name string
+	// Clock defaults to the real clock.
+	Clock quartz.Clock
+	// AntiEntropy selects what is exchanged on push/pull, AntiEntropyFull by
+	// default.
+	AntiEntropy AntiEntropy
+	// Compress full states and oversized messages for peers which accept
+	// compressed parts. All peers accept them, but older versions don't.
+	// memberlist keeps compressing all traffic, so messages to older peers
+	// stay as small as without it.
+	Compress bool
+	// StrictStateKeys marks peers incompatible which send a full state with
+	// parts of unknown states or parts which can't be merged. Their full
+	// states are ignored until they leave, see Peer.IncompatiblePeers.
+	// By default, such parts are only skipped. Peers of older versions don't
+	// send their name with their full state and can't be marked, their
+	// parts which can't be merged are counted as MergeStats.Unattributed.
+	StrictStateKeys bool
+	// MaxQueuedBroadcasts bounds the broadcasts waiting to be gossiped. If
+	// it is 0, broadcasts are dropped right away.
+	MaxQueuedBroadcasts int
+	// Priorities of the states by their key, states without one have
+	// priority 0. Broadcasts of states with higher priorities are gossiped
+	// first and dropped last. DefaultPriorities are used if nil.
+	Priorities map[string]int
+
+	// RetryInterval between attempts to send oversized messages again which
+	// failed to reach a peer.
+	RetryInterval time.Duration
+	// RetryAttempts is how often a failed send is retried while the peer is
+	// a member. If it is 0, failed sends aren't retried.
+	RetryAttempts int
+	// RetryQueueSize bounds the messages waiting for a retry per peer, the
+	// oldest one is dropped if it is full. If it is 0, failed sends are
+	// dropped right away.
+	RetryQueueSize int
+	// OnDelivery is called with the outcome of every oversized message sent
+	// to a peer, after the retries if the first attempt failed.
+	OnDelivery func(Delivery)
+
+	// Version announced to other peers, the version of the main module by
+	// default.
+	Version string
+	// Role and Zone are optional labels announced to other peers.
+	Role string
+	Zone string
+
+	// SettlePolicy decides when Settle considers the peer ready,
+	// StablePeerCount by default. It must not be shared between peers.
+	SettlePolicy SettlePolicy
+}
+
+// DefaultOptions returns the options with the defaults of all durations and
+// limits, which Create used before it took Options. Only BindAddr must be set.
+func DefaultOptions() Options {
+	return Options{
+		PushPullInterval:    DefaultPushPullInterval,
+		GossipInterval:      DefaultGossipInterval,
+		TCPTimeout:          DefaultTCPTimeout,
+		ResolvePeersTimeout: DefaultResolvePeersTimeout,
+		ProbeTimeout:        DefaultProbeTimeout,
+		ProbeInterval:       DefaultProbeInterval,
+		MaxQueuedBroadcasts: DefaultMaxQueuedBroadcasts,
+		RetryInterval:       DefaultRetryInterval,
+		RetryAttempts:       DefaultRetryAttempts,
+		RetryQueueSize:      DefaultRetryQueueSize,
+	}
+}
+
+// withDefaults returns a copy of o with the defaults of unset fields applied,
+// which have no meaning if unset.
+func (o Options) withDefaults() Options {
+	if o.Logger == nil {
+		o.Logger = slog.New(slog.DiscardHandler)
+	}
+	if o.Clock == nil {
+		o.Clock = quartz.NewReal()
+	}
+	if o.Priorities == nil {
+		o.Priorities = DefaultPriorities
+	}
+	if o.Version == "" {
+		o.Version = buildVersion()
+	}
+	if o.SettlePolicy == nil {
+		o.SettlePolicy = &StablePeerCount{}
+	}
+	return o
+}
+
+// validate checks options with their defaults applied.
+func (o Options) validate() error {
+	if o.BindAddr == "" {
+		return errors.New("bind address is required")
+	}
+	for _, d := range []struct {
+		name string
+		v    time.Duration
+	}{
+		{"push-pull interval", o.PushPullInterval},
+		{"gossip interval", o.GossipInterval},
+		{"TCP timeout", o.TCPTimeout},
+		{"resolve peers timeout", o.ResolvePeersTimeout},
+		{"probe timeout", o.ProbeTimeout},
+		{"probe interval", o.ProbeInterval},
+		{"retry interval", o.RetryInterval},
+	} {
+		if d.v < 0 {
+			return fmt.Errorf("%s must not be negative, got %v", d.name, d.v)
+		}
+	}
+	for _, n := range []struct {
+		name string
+		v    int
+	}{
+		{"max queued broadcasts", o.MaxQueuedBroadcasts},
+		{"retry attempts", o.RetryAttempts},
+		{"retry queue size", o.RetryQueueSize},
+	} {
+		if n.v < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", n.name, n.v)
+		}
+	}
+	if len(o.KnownPeers) > 0 && o.Discoverer != nil {
+		return errors.New("known peers and a discoverer are mutually exclusive")
+	}
+	if o.AntiEntropy != AntiEntropyFull && o.AntiEntropy != AntiEntropyDigest {
+		return fmt.Errorf("unknown anti-entropy mode %v", o.AntiEntropy)
+	}
+	if o.Transport != nil && o.TLSTransportConfig != nil {
+		return errors.New("only one of Transport and TLSTransportConfig must be set")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsDefaults(t *testing.T) {
+	o := DefaultOptions()
+	o.BindAddr = "127.0.0.1:0"
+	o = o.withDefaults()
+	require.NoError(t, o.validate())
+	require.NotNil(t, o.Logger)
+	require.NotNil(t, o.Clock)
+	require.Equal(t, DefaultPushPullInterval, o.PushPullInterval)
+	require.Equal(t, DefaultGossipInterval, o.GossipInterval)
+	require.Equal(t, DefaultTCPTimeout, o.TCPTimeout)
+	require.Equal(t, DefaultResolvePeersTimeout, o.ResolvePeersTimeout)
+	require.Equal(t, DefaultProbeTimeout, o.ProbeTimeout)
+	require.Equal(t, DefaultProbeInterval, o.ProbeInterval)
+	require.Equal(t, DefaultMaxQueuedBroadcasts, o.MaxQueuedBroadcasts)
+	require.Equal(t, DefaultPriorities, o.Priorities)
+	require.Equal(t, DefaultRetryInterval, o.RetryInterval)
+	require.Equal(t, DefaultRetryAttempts, o.RetryAttempts)
+	require.Equal(t, DefaultRetryQueueSize, o.RetryQueueSize)
+	require.Equal(t, &StablePeerCount{}, o.SettlePolicy)
+}
+
+func TestOptionsKeepZeros(t *testing.T) {
+	o := Options{BindAddr: "127.0.0.1:0"}.withDefaults()
+	require.NoError(t, o.validate())
+	require.Zero(t, o.PushPullInterval)
+	require.Zero(t, o.GossipInterval)
+	require.Zero(t, o.MaxQueuedBroadcasts)
+	require.Zero(t, o.RetryAttempts)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		o    Options
+		err  string
+	}{
+		{
+			name: "missing bind address",
+			o:    Options{},
+			err:  "bind address is required",
+		},
+		{
+			name: "negative duration",
+			o:    Options{BindAddr: "127.0.0.1:0", TCPTimeout: -time.Second},
+			err:  "TCP timeout must not be negative, got -1s",
+		},
+		{
+			name: "negative queue limit",
+			o:    Options{BindAddr: "127.0.0.1:0", MaxQueuedBroadcasts: -1},
+			err:  "max queued broadcasts must not be negative, got -1",
+		},
+		{
+			name: "transport and TLS",
+			o:    Options{BindAddr: "127.0.0.1:0", Transport: &memberlist.MockTransport{}, TLSTransportConfig: &TLSTransportConfig{}},
+			err:  "only one of Transport and TLSTransportConfig must be set",
+		},
+		{
+			name: "known peers and discoverer",
+			o:    Options{BindAddr: "127.0.0.1:0", KnownPeers: []string{"127.0.0.1:9094"}, Discoverer: StaticDiscoverer{}},
+			err:  "known peers and a discoverer are mutually exclusive",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.EqualError(t, tc.o.withDefaults().validate(), tc.err)
+			_, err := Create(tc.o)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// DefaultPriorities gossip notification log entries before silences. A late
+// silence only delays muting, a late log entry causes duplicate notifications.
+var DefaultPriorities = map[string]int{"nfl": 1, "sil": 0}
+
+// BroadcastStats are the counters of the broadcasts of a state. The package
+// doesn't register Prometheus metrics, a program embedding the peer registers
+// NewBroadcastCollector to export them.
+type BroadcastStats struct {
+	Priority int `json:"priority"`
+	// Queued broadcasts are waiting to be gossiped.
+	Queued int `json:"queued"`
+	// Sent broadcasts were gossiped as often as memberlist retransmits them.
+	Sent uint64 `json:"sent"`
+	// Superseded broadcasts were replaced by a newer one of the same name.
+	Superseded uint64 `json:"superseded"`
+	// Dropped broadcasts were removed because the queue was full.
+	Dropped uint64 `json:"dropped"`
+	// DroppedOversized messages weren't sent because too many oversized
+	// messages were pending.
+	DroppedOversized uint64 `json:"dropped_oversized"`
+}
+
+// outcome is what happens to the broadcasts finished by an operation on the
+// queue.
+type outcome int
+
+const (
+	outcomeSent outcome = iota
+	outcomeSuperseded
+	outcomeDropped
+)
+
+// broadcastQueue holds the broadcasts of all states until memberlist gossiped
+// them. Broadcasts of states with a higher priority are gossiped first.
+//
+// The queue holds at most limit broadcasts, which bounds its memory as
+// broadcasts larger than half a gossip packet aren't queued. If it is full,
+// broadcasts of the lowest priority are dropped first, and among those the
+// ones which were retransmitted most often.
+type broadcastQueue struct {
+	numNodes   func() int
+	retransmit int
+	limit      int
+	priorities map[string]int
+
+	mtx sync.Mutex
+	// levels are sorted by descending priority.
+	levels []*priorityLevel
+	stats  map[string]*BroadcastStats
+	// outcome of the broadcasts finished by the running operation. memberlist
+	// calls Finished synchronously while the queue is locked.
+	outcome outcome
+}
+
+type priorityLevel struct {
+	priority int
+	*memberlist.TransmitLimitedQueue
+}
+
+func newBroadcastQueue(numNodes func() int, retransmit, limit int, priorities map[string]int) *broadcastQueue {
+	return &broadcastQueue{
+		numNodes:   numNodes,
+		retransmit: retransmit,
+		limit:      limit,
+		priorities: priorities,
+		stats:      map[string]*BroadcastStats{},
+	}
+}
+
+// queue adds a message of the state with the given key. Messages with a name
+// replace queued messages of the same name and key.
+func (q *broadcastQueue) queue(key, name string, msg []byte) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	s := q.statsOf(key)
+	b := &queuedBroadcast{msg: msg, stats: s, q: q}
+	lvl := q.level(s.Priority)
+	q.outcome = outcomeSuperseded
+	if name == "" {
+		lvl.QueueBroadcast(simpleBroadcast{b})
+	} else {
+		lvl.QueueBroadcast(namedBroadcast{queuedBroadcast: b, name: key + "/" + name})
+	}
+	s.Queued++
+
+	q.outcome = outcomeDropped
+	total := q.numQueued()
+	for i := len(q.levels) - 1; i >= 0 && total > q.limit; i-- {
+		n := q.levels[i].NumQueued()
+		keep := max(0, n-(total-q.limit))
+		q.levels[i].Prune(keep)
+		total -= n - keep
+	}
+}
+
+// getBroadcasts returns messages of at most limit bytes in total, starting
+// with those of the highest priority.
+func (q *broadcastQueue) getBroadcasts(overhead, limit int) [][]byte {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.outcome = outcomeSent
+	var msgs [][]byte
+	for _, lvl := range q.levels {
+		for _, m := range lvl.GetBroadcasts(overhead, limit) {
+			limit -= overhead + len(m)
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// pressure returns the share of the limit which is queued. A queue without
+// room is always full.
+func (q *broadcastQueue) pressure() float64 {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.limit == 0 {
+		return 1
+	}
+	return float64(q.numQueued()) / float64(q.limit)
+}
+
+// statsByKey returns a copy of the counters of all states.
+func (q *broadcastQueue) statsByKey() map[string]BroadcastStats {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	res := make(map[string]BroadcastStats, len(q.stats))
+	for k, s := range q.stats {
+		res[k] = *s
+	}
+	return res
+}
+
+func (q *broadcastQueue) numQueued() int {
+	var n int
+	for _, lvl := range q.levels {
+		n += lvl.NumQueued()
+	}
+	return n
+}
+
+func (q *broadcastQueue) statsOf(key string) *BroadcastStats {
+	s, ok := q.stats[key]
+	if !ok {
+		s = &BroadcastStats{Priority: q.priorities[key]}
+		q.stats[key] = s
+	}
+	return s
+}
+
+func (q *broadcastQueue) level(priority int) *priorityLevel {
+	i, ok := slices.BinarySearchFunc(q.levels, priority, func(lvl *priorityLevel, p int) int {
+		return p - lvl.priority
+	})
+	if !ok {
+		q.levels = slices.Insert(q.levels, i, &priorityLevel{
+			priority: priority,
+			TransmitLimitedQueue: &memberlist.TransmitLimitedQueue{
+				NumNodes:       q.numNodes,
+				RetransmitMult: q.retransmit,
+			},
+		})
+	}
+	return q.levels[i]
+}
+
+// queuedBroadcast counts its outcome once memberlist is done with it.
+type queuedBroadcast struct {
+	msg   []byte
+	stats *BroadcastStats
+	q     *broadcastQueue
+}
+
+func (b *queuedBroadcast) Message() []byte { return b.msg }
+
+func (b *queuedBroadcast) Finished() {
+	b.stats.Queued--
+	switch b.q.outcome {
+	case outcomeSent:
+		b.stats.Sent++
+	case outcomeSuperseded:
+		b.stats.Superseded++
+	case outcomeDropped:
+		b.stats.Dropped++
+	}
+}
+
+// simpleBroadcast never invalidates other broadcasts, so the queue doesn't
+// need to be scanned when it is added.
+type simpleBroadcast struct{ *queuedBroadcast }
+
+func (b simpleBroadcast) Invalidates(memberlist.Broadcast) bool { return false }
+func (b simpleBroadcast) UniqueBroadcast()                      {}
+
+// namedBroadcast replaces queued broadcasts of the same name.
+type namedBroadcast struct {
+	*queuedBroadcast
+	name string
+}
+
+func (b namedBroadcast) Name() string { return b.name }
+func (b namedBroadcast) Invalidates(other memberlist.Broadcast) bool {
+	nb, ok := other.(memberlist.NamedBroadcast)
+	return ok && nb.Name() == b.name
+}
@@ -0,0 +1,95 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcastQueuePriorities(t *testing.T) {
+	// Every message is gossiped once.
+	q := newBroadcastQueue(func() int { return 3 }, 1, 10, map[string]int{"high": 1, "low": -1})
+	for _, key := range []string{"low", "default", "high"} {
+		q.queue(key, "", []byte(key))
+	}
+
+	// Higher priorities are gossiped first, lower ones if they still fit.
+	require.Equal(t, [][]byte{[]byte("high")}, q.getBroadcasts(2, 6))
+	require.Equal(t, [][]byte{[]byte("default"), []byte("low")}, q.getBroadcasts(0, 100))
+	require.Empty(t, q.getBroadcasts(0, 100))
+}
+
+func TestBroadcastQueueLimit(t *testing.T) {
+	q := newBroadcastQueue(func() int { return 3 }, 1, 4, map[string]int{"high": 1})
+	q.queue("low", "", []byte("low-0"))
+	q.queue("low", "", []byte("low-1"))
+	q.queue("high", "", []byte("high-0"))
+	require.InDelta(t, 0.75, q.pressure(), 0.001)
+
+	// The lowest priority is dropped first, the oldest messages first.
+	for i := 1; i < 4; i++ {
+		q.queue("high", "", fmt.Appendf(nil, "high-%d", i))
+	}
+	require.Equal(t, 1.0, q.pressure())
+	require.Equal(t, map[string]BroadcastStats{
+		"high": {Priority: 1, Queued: 4},
+		"low":  {Dropped: 2},
+	}, q.statsByKey())
+
+	q.queue("high", "", []byte("high-4"))
+	require.Equal(t, map[string]BroadcastStats{
+		"high": {Priority: 1, Queued: 4, Dropped: 1},
+		"low":  {Dropped: 2},
+	}, q.statsByKey())
+	require.ElementsMatch(t, [][]byte{[]byte("high-1"), []byte("high-2"), []byte("high-3"), []byte("high-4")}, q.getBroadcasts(0, 100))
+}
+
+func TestBroadcastQueueSent(t *testing.T) {
+	// With a single node, memberlist retransmits a message once.
+	q := newBroadcastQueue(func() int { return 1 }, 1, 10, nil)
+	q.queue("a", "", []byte("a"))
+	require.Len(t, q.getBroadcasts(0, 100), 1)
+	require.Empty(t, q.getBroadcasts(0, 100))
+	require.Equal(t, BroadcastStats{Sent: 1}, q.statsByKey()["a"])
+	require.Zero(t, q.pressure())
+}
+
+func TestPeerBroadcastStats(t *testing.T) {
+	p := newTestPeer(t, testOptions())
+	c := p.AddState("nfl", digestState{})
+	p.AddState("sil", digestState{})
+	c.Broadcast([]byte("entry"))
+	c.Broadcast(make([]byte, MaxGossipPacketSize))
+	require.Equal(t, map[string]BroadcastStats{
+		"nfl": {Priority: 1, Queued: 1},
+		"sil": {},
+	}, p.BroadcastStats())
+}
+
+func TestBroadcastCollector(t *testing.T) {
+	p := newTestPeer(t, testOptions())
+	c := p.AddState("nfl", digestState{})
+	c.Broadcast([]byte("entry"))
+	require.NoError(t, testutil.CollectAndCompare(NewBroadcastCollector(p), strings.NewReader(`
+# HELP alertmanager_cluster_broadcasts_queued Number of broadcasts of a state waiting to be gossiped.
+# TYPE alertmanager_cluster_broadcasts_queued gauge
+alertmanager_cluster_broadcasts_queued{key="nfl",priority="1"} 1
+`), "alertmanager_cluster_broadcasts_queued"))
+	require.Equal(t, 5, testutil.CollectAndCount(NewBroadcastCollector(p)))
+}
@@ -0,0 +1,285 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/hashicorp/memberlist"
+)
+
+// DeliveryOutcome is the final outcome of sending an oversized message to a
+// peer.
+type DeliveryOutcome int
+
+const (
+	// Delivered messages were sent to the peer.
+	Delivered DeliveryOutcome = iota
+	// DeliveryFailed means that all attempts to send the message failed.
+	DeliveryFailed
+	// DeliveryDropped messages were removed from the retry queue, because it
+	// was full or because the peer was removed from the cluster.
+	DeliveryDropped
+)
+
+func (o DeliveryOutcome) String() string {
+	switch o {
+	case Delivered:
+		return "delivered"
+	case DeliveryFailed:
+		return "failed"
+	case DeliveryDropped:
+		return "dropped"
+	default:
+		return fmt.Sprintf("DeliveryOutcome(%d)", int(o))
+	}
+}
+
+// Delivery reports the outcome of sending an oversized message to a peer.
+type Delivery struct {
+	// Key of the state the message belongs to.
+	Key string
+	// Addr of the peer.
+	Addr     string
+	Outcome  DeliveryOutcome
+	Attempts int
+	// Err of the last failed attempt.
+	Err error
+}
+
+// retryQueue sends oversized messages to peers over TCP and retries failed
+// sends. Messages are queued by the address of the peer, so that a peer which
+// reconnects after a failure receives the messages it missed. Peers which
+// aren't members don't use up their attempts. The messages of a peer which
+// failed are kept until it is removed after the reconnect timeout, those of a
+// peer which announced leaving are dropped right away.
+type retryQueue struct {
+	send       func(*memberlist.Node, []byte) error
+	members    func() []*memberlist.Node
+	clock      quartz.Clock
+	logger     *slog.Logger
+	interval   time.Duration
+	attempts   int
+	size       int
+	onDelivery func(Delivery)
+
+	mtx     sync.Mutex
+	pending map[string][]*pendingMessage
+	// queuedc and joinedc signal that a message was queued or a peer with
+	// pending messages joined.
+	queuedc chan struct{}
+	joinedc chan struct{}
+}
+
+func newRetryQueue(send func(*memberlist.Node, []byte) error, members func() []*memberlist.Node, o Options) *retryQueue {
+	return &retryQueue{
+		send:       send,
+		members:    members,
+		clock:      o.Clock,
+		logger:     o.Logger,
+		interval:   o.RetryInterval,
+		attempts:   o.RetryAttempts,
+		size:       o.RetryQueueSize,
+		onDelivery: o.OnDelivery,
+		pending:    map[string][]*pendingMessage{},
+		queuedc:    make(chan struct{}, 1),
+		joinedc:    make(chan struct{}, 1),
+	}
+}
+
+type pendingMessage struct {
+	key      string
+	msg      []byte
+	attempts int
+	err      error
+}
+
+// sendReliable sends msg of the state with the given key to n. If that fails,
+// the message is queued for retries and the error is returned.
+func (q *retryQueue) sendReliable(key string, n *memberlist.Node, msg []byte) error {
+	addr := n.Address()
+	err := q.send(n, msg)
+	if err == nil {
+		q.report(Delivery{Key: key, Addr: addr, Outcome: Delivered, Attempts: 1})
+		return nil
+	}
+	m := &pendingMessage{key: key, msg: msg, attempts: 1, err: err}
+	switch {
+	case q.attempts == 0:
+		q.report(m.delivery(addr, DeliveryFailed))
+		return err
+	case q.size == 0:
+		q.report(m.delivery(addr, DeliveryDropped))
+		return err
+	}
+
+	q.mtx.Lock()
+	pending := q.pending[addr]
+	var dropped *pendingMessage
+	if len(pending) >= q.size {
+		dropped, pending = pending[0], pending[1:]
+	}
+	q.pending[addr] = append(pending, m)
+	q.mtx.Unlock()
+	signal(q.queuedc)
+
+	if dropped != nil {
+		q.report(dropped.delivery(addr, DeliveryDropped))
+	}
+	return err
+}
+
+// peerJoined retries the messages of a peer which joined again right away.
+func (q *retryQueue) peerJoined(addr string) {
+	q.mtx.Lock()
+	_, ok := q.pending[addr]
+	q.mtx.Unlock()
+	if ok {
+		signal(q.joinedc)
+	}
+}
+
+// peerRemoved drops the messages of a peer which was removed from the cluster.
+func (q *retryQueue) peerRemoved(addr string) {
+	q.mtx.Lock()
+	pending := q.pending[addr]
+	delete(q.pending, addr)
+	q.mtx.Unlock()
+
+	for _, m := range pending {
+		q.report(m.delivery(addr, DeliveryDropped))
+	}
+}
+
+// run retries failed sends after the retry interval and whenever a peer with
+// pending messages joins, until stopc is closed.
+func (q *retryQueue) run(stopc <-chan struct{}) {
+	for {
+		if !q.hasPending() {
+			select {
+			case <-stopc:
+				return
+			case <-q.queuedc:
+			}
+		}
+		timer := q.clock.NewTimer(q.interval, "retryQueue", "run")
+		select {
+		case <-stopc:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-q.joinedc:
+			timer.Stop()
+		}
+		q.retry()
+	}
+}
+
+// retry sends the pending messages of all members in order. It stops at the
+// first failure for each peer, as the following messages would likely fail,
+// too.
+func (q *retryQueue) retry() {
+	for _, n := range q.members() {
+		addr := n.Address()
+		for {
+			m := q.front(addr)
+			if m == nil {
+				break
+			}
+			if !q.finish(addr, m, q.send(n, m.msg)) {
+				break
+			}
+		}
+	}
+}
+
+// front returns the oldest pending message to addr.
+func (q *retryQueue) front(addr string) *pendingMessage {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if pending := q.pending[addr]; len(pending) > 0 {
+		return pending[0]
+	}
+	return nil
+}
+
+// finish records an attempt to send m to addr and reports whether to
+// continue with the next message.
+func (q *retryQueue) finish(addr string, m *pendingMessage, err error) bool {
+	q.mtx.Lock()
+	pending := q.pending[addr]
+	if len(pending) == 0 || pending[0] != m {
+		// The message was dropped and reported in the meantime.
+		q.mtx.Unlock()
+		return false
+	}
+	m.attempts++
+	m.err = err
+	done := err == nil || m.attempts > q.attempts
+	if done && len(pending) == 1 {
+		delete(q.pending, addr)
+	} else if done {
+		q.pending[addr] = pending[1:]
+	}
+	q.mtx.Unlock()
+
+	switch {
+	case err == nil:
+		q.report(m.delivery(addr, Delivered))
+		return true
+	case done:
+		q.report(m.delivery(addr, DeliveryFailed))
+	}
+	q.logger.Debug("failed to retry sending reliable", "key", m.key, "addr", addr, "attempts", m.attempts, "err", err)
+	return false
+}
+
+// numPending returns the number of messages waiting for a retry to addr.
+func (q *retryQueue) numPending(addr string) int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.pending[addr])
+}
+
+func (q *retryQueue) hasPending() bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.pending) > 0
+}
+
+// signal notifies the receiver of c without blocking.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (q *retryQueue) report(d Delivery) {
+	if q.onDelivery != nil {
+		q.onDelivery(d)
+	}
+}
+
+func (m *pendingMessage) delivery(addr string, o DeliveryOutcome) Delivery {
+	d := Delivery{Key: m.key, Addr: addr, Outcome: o, Attempts: m.attempts}
+	if o != Delivered {
+		d.Err = m.err
+	}
+	return d
+}
@@ -0,0 +1,228 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReliable is a network of peers which are up or down.
+type fakeReliable struct {
+	mtx        sync.Mutex
+	members    []*memberlist.Node
+	down       map[string]bool
+	received   map[string][]string
+	deliveries []Delivery
+}
+
+func newFakeReliable(names ...string) *fakeReliable {
+	f := &fakeReliable{down: map[string]bool{}, received: map[string][]string{}}
+	for i, name := range names {
+		f.members = append(f.members, &memberlist.Node{Name: name, Addr: net.IPv4(10, 0, 0, byte(i+1)), Port: 9094})
+	}
+	return f
+}
+
+func (f *fakeReliable) send(n *memberlist.Node, b []byte) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.down[n.Address()] {
+		return errors.New("connection refused")
+	}
+	f.received[n.Address()] = append(f.received[n.Address()], string(b))
+	return nil
+}
+
+func (f *fakeReliable) getMembers() []*memberlist.Node {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.members
+}
+
+func (f *fakeReliable) setMembers(members ...*memberlist.Node) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.members = members
+}
+
+func (f *fakeReliable) setDown(addr string, down bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.down[addr] = down
+}
+
+func (f *fakeReliable) outcomes() []Delivery {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	res := make([]Delivery, 0, len(f.deliveries))
+	for _, d := range f.deliveries {
+		d.Err = nil
+		res = append(res, d)
+	}
+	return res
+}
+
+func (f *fakeReliable) newQueue(clock quartz.Clock, attempts, size int) *retryQueue {
+	o := Options{
+		Clock:          clock,
+		RetryInterval:  time.Second,
+		RetryAttempts:  attempts,
+		RetryQueueSize: size,
+		OnDelivery: func(d Delivery) {
+			f.mtx.Lock()
+			defer f.mtx.Unlock()
+			f.deliveries = append(f.deliveries, d)
+		},
+	}.withDefaults()
+	return newRetryQueue(f.send, f.getMembers, o)
+}
+
+func TestRetryQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clock := quartz.NewMock(t)
+	trap := clock.Trap().NewTimer("retryQueue", "run")
+	defer trap.Close()
+	f := newFakeReliable("a", "b")
+	a, b := f.members[0], f.members[1]
+	q := f.newQueue(clock, 2, 10)
+	stopc := make(chan struct{})
+	defer close(stopc)
+	go q.run(stopc)
+
+	f.setDown(b.Address(), true)
+	require.NoError(t, q.sendReliable("nfl", a, []byte("1")))
+	require.Error(t, q.sendReliable("nfl", b, []byte("1")))
+	require.Error(t, q.sendReliable("nfl", b, []byte("2")))
+	require.Equal(t, 2, q.numPending(b.Address()))
+
+	// The first retry fails, the second one delivers all messages in order.
+	trap.MustWait(ctx).MustRelease(ctx)
+	clock.Advance(time.Second).MustWait(ctx)
+	trap.MustWait(ctx).MustRelease(ctx)
+	f.setDown(b.Address(), false)
+	clock.Advance(time.Second).MustWait(ctx)
+	require.Eventually(t, func() bool { return q.numPending(b.Address()) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, []string{"1", "2"}, f.received[b.Address()])
+	require.Equal(t, []Delivery{
+		{Key: "nfl", Addr: a.Address(), Outcome: Delivered, Attempts: 1},
+		{Key: "nfl", Addr: b.Address(), Outcome: Delivered, Attempts: 3},
+		{Key: "nfl", Addr: b.Address(), Outcome: Delivered, Attempts: 2},
+	}, f.outcomes())
+}
+
+func TestRetryQueueGivesUp(t *testing.T) {
+	f := newFakeReliable("a")
+	a := f.members[0]
+	q := f.newQueue(quartz.NewMock(t), 2, 10)
+	f.setDown(a.Address(), true)
+
+	require.Error(t, q.sendReliable("nfl", a, []byte("1")))
+	q.retry()
+	require.Equal(t, 1, q.numPending(a.Address()))
+	q.retry()
+	require.Zero(t, q.numPending(a.Address()))
+	require.Equal(t, []Delivery{{Key: "nfl", Addr: a.Address(), Outcome: DeliveryFailed, Attempts: 3}}, f.outcomes())
+	require.EqualError(t, f.deliveries[0].Err, "connection refused")
+}
+
+func TestRetryQueueDisabled(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		attempts, size int
+		outcome        DeliveryOutcome
+	}{
+		{name: "no attempts", attempts: 0, size: 10, outcome: DeliveryFailed},
+		{name: "no room", attempts: 2, size: 0, outcome: DeliveryDropped},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeReliable("a")
+			a := f.members[0]
+			q := f.newQueue(quartz.NewMock(t), tc.attempts, tc.size)
+			f.setDown(a.Address(), true)
+
+			require.Error(t, q.sendReliable("nfl", a, []byte("1")))
+			require.Zero(t, q.numPending(a.Address()))
+			require.Equal(t, []Delivery{{Key: "nfl", Addr: a.Address(), Outcome: tc.outcome, Attempts: 1}}, f.outcomes())
+		})
+	}
+}
+
+func TestRetryQueueSize(t *testing.T) {
+	f := newFakeReliable("a")
+	a := f.members[0]
+	q := f.newQueue(quartz.NewMock(t), 2, 2)
+	f.setDown(a.Address(), true)
+
+	for _, msg := range []string{"1", "2", "3"} {
+		require.Error(t, q.sendReliable("nfl", a, []byte(msg)))
+	}
+	require.Equal(t, 2, q.numPending(a.Address()))
+	require.Equal(t, []Delivery{{Key: "nfl", Addr: a.Address(), Outcome: DeliveryDropped, Attempts: 1}}, f.outcomes())
+
+	f.setDown(a.Address(), false)
+	q.retry()
+	require.Equal(t, []string{"2", "3"}, f.received[a.Address()])
+}
+
+func TestRetryQueuePeerAway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clock := quartz.NewMock(t)
+	trap := clock.Trap().NewTimer("retryQueue", "run")
+	defer trap.Close()
+	f := newFakeReliable("a", "b")
+	a, b := f.members[0], f.members[1]
+	q := f.newQueue(clock, 1, 10)
+	stopc := make(chan struct{})
+	defer close(stopc)
+	go q.run(stopc)
+
+	f.setDown(a.Address(), true)
+	f.setDown(b.Address(), true)
+	require.Error(t, q.sendReliable("nfl", a, []byte("1")))
+	require.Error(t, q.sendReliable("sil", b, []byte("1")))
+
+	// Peers which aren't members don't use up their attempts.
+	f.setMembers()
+	for range 3 {
+		trap.MustWait(ctx).MustRelease(ctx)
+		clock.Advance(time.Second).MustWait(ctx)
+	}
+	trap.MustWait(ctx).MustRelease(ctx)
+	require.Equal(t, 1, q.numPending(a.Address()))
+
+	// A peer which rejoins gets its messages without waiting for the interval,
+	// a removed one never.
+	f.setDown(a.Address(), false)
+	f.setMembers(a)
+	q.peerJoined(a.Address())
+	q.peerRemoved(b.Address())
+	require.Eventually(t, func() bool { return q.numPending(a.Address()) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Zero(t, q.numPending(b.Address()))
+	require.Equal(t, []Delivery{
+		{Key: "sil", Addr: b.Address(), Outcome: DeliveryDropped, Attempts: 1},
+		{Key: "nfl", Addr: a.Address(), Outcome: Delivered, Attempts: 2},
+	}, f.outcomes())
+}
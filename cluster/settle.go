@@ -0,0 +1,210 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SettleState is what a SettlePolicy decides on.
+type SettleState struct {
+	// Self is the name of the peer.
+	Self string
+	// Members are the names of the alive members, including the peer itself.
+	Members []string
+	// Synced are the names of the members whose full state was merged by a
+	// push/pull since they joined. Peers of older versions are never synced.
+	Synced map[string]bool
+	// Older are the names of the members of older versions, which announce
+	// no NodeMeta.
+	Older map[string]bool
+	// Polls is the number of the previous polls.
+	Polls   int
+	Elapsed time.Duration
+}
+
+// SettlePolicy decides when a peer has settled, so that it may start sending
+// notifications. A policy is used by a single peer.
+type SettlePolicy interface {
+	// Name describes the policy in logs and the settle status.
+	Name() string
+	// Settled is called on every poll of Settle. It reports whether the
+	// peer has settled and why.
+	Settled(s SettleState) (bool, string)
+}
+
+// StablePeerCount considers the peer settled once the number of members
+// didn't change for Polls polls, 3 by default. It doesn't know whether any
+// state was exchanged.
+type StablePeerCount struct {
+	Polls int
+
+	members int
+	okay    int
+}
+
+// Name implements SettlePolicy.
+func (s *StablePeerCount) Name() string { return "stable-peer-count" }
+
+// Settled implements SettlePolicy.
+func (s *StablePeerCount) Settled(st SettleState) (bool, string) {
+	polls := s.Polls
+	if polls == 0 {
+		polls = 3
+	}
+	n := len(st.Members)
+	if s.okay >= polls {
+		return true, fmt.Sprintf("%d members for %d polls", n, s.okay)
+	}
+	if n == s.members {
+		s.okay++
+	} else {
+		s.okay = 0
+	}
+	s.members = n
+	return false, fmt.Sprintf("%d members for %d polls", n, s.okay)
+}
+
+// ExpectedClusterSize considers the peer settled once the cluster has at least
+// Size members, including the peer itself.
+type ExpectedClusterSize struct {
+	Size int
+}
+
+// Name implements SettlePolicy.
+func (s ExpectedClusterSize) Name() string { return fmt.Sprintf("expected-cluster-size(%d)", s.Size) }
+
+// Settled implements SettlePolicy.
+func (s ExpectedClusterSize) Settled(st SettleState) (bool, string) {
+	return len(st.Members) >= s.Size, fmt.Sprintf("%d of %d members", len(st.Members), s.Size)
+}
+
+// PushPullWithAllPeers considers the peer settled once it merged the full
+// state of every other member, so that it knows their notification log before
+// sending notifications. Peers of older versions don't announce themselves in
+// their full state, they are skipped and counted as unknown.
+type PushPullWithAllPeers struct{}
+
+// Name implements SettlePolicy.
+func (PushPullWithAllPeers) Name() string { return "push-pull-with-all-peers" }
+
+// Settled implements SettlePolicy.
+func (PushPullWithAllPeers) Settled(st SettleState) (bool, string) {
+	var (
+		missing []string
+		synced  int
+		unknown int
+	)
+	for _, m := range st.Members {
+		switch {
+		case m == st.Self:
+		case st.Synced[m]:
+			synced++
+		case st.Older[m]:
+			unknown++
+		default:
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) > 0 {
+		return false, fmt.Sprintf("waiting for the state of %s", strings.Join(missing, ", "))
+	}
+	if unknown > 0 {
+		return true, fmt.Sprintf("synced with %d peers, %d of older versions unknown", synced, unknown)
+	}
+	return true, fmt.Sprintf("synced with %d peers", synced)
+}
+
+// AllOf considers the peer settled once all policies do. They are asked on
+// every poll, even if one of them hasn't settled yet.
+func AllOf(policies ...SettlePolicy) SettlePolicy {
+	return allOf(policies)
+}
+
+type allOf []SettlePolicy
+
+// Name implements SettlePolicy.
+func (a allOf) Name() string {
+	names := make([]string, 0, len(a))
+	for _, p := range a {
+		names = append(names, p.Name())
+	}
+	return "all-of(" + strings.Join(names, ", ") + ")"
+}
+
+// Settled implements SettlePolicy.
+func (a allOf) Settled(st SettleState) (bool, string) {
+	settled := true
+	reasons := make([]string, 0, len(a))
+	for _, p := range a {
+		ok, reason := p.Settled(st)
+		settled = settled && ok
+		reasons = append(reasons, reason)
+	}
+	return settled, strings.Join(reasons, "; ")
+}
+
+// SettleOutcome is the result of Settle.
+type SettleOutcome string
+
+const (
+	SettleOutcomeSettling SettleOutcome = "settling"
+	SettleOutcomeSettled  SettleOutcome = "settled"
+	// SettleOutcomeGaveUp means that the peer is ready even though the
+	// policy wasn't satisfied, as the context of Settle was done.
+	SettleOutcomeGaveUp SettleOutcome = "gave-up"
+)
+
+// SettleStatus describes the progress of Settle.
+type SettleStatus struct {
+	Policy  string        `json:"policy"`
+	Outcome SettleOutcome `json:"outcome"`
+	// Reason is the explanation of the policy at the last poll.
+	Reason  string        `json:"reason"`
+	Polls   int           `json:"polls"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// settleState returns the state of the peer for its settle policy.
+func (p *Peer) settleState(polls int, elapsed time.Duration) SettleState {
+	st := SettleState{
+		Self:    p.Name(),
+		Synced:  p.delegate.syncedPeers(),
+		Older:   map[string]bool{},
+		Polls:   polls,
+		Elapsed: elapsed,
+	}
+	for _, m := range p.Peers() {
+		st.Members = append(st.Members, m.Name())
+		if m.Meta().fromOlderVersion() {
+			st.Older[m.Name()] = true
+		}
+	}
+	return st
+}
+
+func (p *Peer) setSettleStatus(s SettleStatus) {
+	p.settleMtx.Lock()
+	defer p.settleMtx.Unlock()
+	p.settleStatus = s
+}
+
+// SettleStatus returns the policy Settle uses and how far it got.
+func (p *Peer) SettleStatus() SettleStatus {
+	p.settleMtx.Lock()
+	defer p.settleMtx.Unlock()
+	return p.settleStatus
+}
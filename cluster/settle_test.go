@@ -0,0 +1,163 @@
+// Copyright The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster/clusterpb"
+)
+
+func TestSettlePolicies(t *testing.T) {
+	two := SettleState{Self: "a", Members: []string{"a", "b"}}
+	synced := SettleState{Self: "a", Members: []string{"a", "b"}, Synced: map[string]bool{"b": true}}
+	for _, tc := range []struct {
+		name    string
+		policy  SettlePolicy
+		states  []SettleState
+		settled []bool
+		reason  string
+	}{
+		{
+			name:    "stable peer count",
+			policy:  &StablePeerCount{Polls: 2},
+			states:  []SettleState{two, two, {Members: []string{"a"}}, two, two, two, two},
+			settled: []bool{false, false, false, false, false, false, true},
+			reason:  "2 members for 2 polls",
+		},
+		{
+			name:    "expected cluster size",
+			policy:  ExpectedClusterSize{Size: 2},
+			states:  []SettleState{{Members: []string{"a"}}, two},
+			settled: []bool{false, true},
+			reason:  "2 of 2 members",
+		},
+		{
+			name:    "push/pull with all peers",
+			policy:  PushPullWithAllPeers{},
+			states:  []SettleState{two, synced},
+			settled: []bool{false, true},
+			reason:  "synced with 1 peers",
+		},
+		{
+			name:    "push/pull alone",
+			policy:  PushPullWithAllPeers{},
+			states:  []SettleState{{Self: "a", Members: []string{"a"}}},
+			settled: []bool{true},
+			reason:  "synced with 0 peers",
+		},
+		{
+			name:   "push/pull with older peers",
+			policy: PushPullWithAllPeers{},
+			states: []SettleState{{
+				Self:    "a",
+				Members: []string{"a", "b", "c"},
+				Synced:  map[string]bool{"b": true},
+				Older:   map[string]bool{"c": true},
+			}},
+			settled: []bool{true},
+			reason:  "synced with 1 peers, 1 of older versions unknown",
+		},
+		{
+			name:    "all of",
+			policy:  AllOf(ExpectedClusterSize{Size: 2}, PushPullWithAllPeers{}),
+			states:  []SettleState{{Self: "a", Members: []string{"a"}}, two, synced},
+			settled: []bool{false, false, true},
+			reason:  "2 of 2 members; synced with 1 peers",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var reason string
+			for i, st := range tc.states {
+				var settled bool
+				settled, reason = tc.policy.Settled(st)
+				require.Equal(t, tc.settled[i], settled, "poll %d", i)
+			}
+			require.Equal(t, tc.reason, reason)
+		})
+	}
+
+	_, reason := PushPullWithAllPeers{}.Settled(two)
+	require.Equal(t, "waiting for the state of b", reason)
+	require.Equal(t, "all-of(expected-cluster-size(2), push-pull-with-all-peers)", AllOf(ExpectedClusterSize{Size: 2}, PushPullWithAllPeers{}).Name())
+}
+
+func TestSettleAfterPushPull(t *testing.T) {
+	create := func(policy SettlePolicy) *Peer {
+		o := testOptions()
+		o.SettlePolicy = policy
+		return newTestPeer(t, o)
+	}
+	p1 := create(nil)
+	p2 := create(AllOf(ExpectedClusterSize{Size: 2}, PushPullWithAllPeers{}))
+	require.Equal(t, SettleStatus{
+		Policy:  "all-of(expected-cluster-size(2), push-pull-with-all-peers)",
+		Outcome: SettleOutcomeSettling,
+	}, p2.SettleStatus())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go p2.Settle(ctx, 10*time.Millisecond)
+	require.Never(t, p2.Ready, 100*time.Millisecond, 10*time.Millisecond)
+	require.Equal(t, "settling", p2.Status())
+	require.Equal(t, "1 of 2 members; synced with 0 peers", p2.SettleStatus().Reason)
+
+	// Joining pushes and pulls the full state of p1.
+	_, err := p2.mlist.Join([]string{p1.Self().Address()})
+	require.NoError(t, err)
+	require.NoError(t, p2.WaitReady(ctx))
+	require.Equal(t, "ready", p2.Status())
+	st := p2.SettleStatus()
+	require.Equal(t, SettleOutcomeSettled, st.Outcome)
+	require.Equal(t, "2 of 2 members; synced with 1 peers", st.Reason)
+}
+
+func TestSettleGivesUp(t *testing.T) {
+	o := testOptions()
+	o.SettlePolicy = ExpectedClusterSize{Size: 3}
+	p := newTestPeer(t, o)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	p.Settle(ctx, 10*time.Millisecond)
+	require.Equal(t, "ready", p.Status())
+	st := p.SettleStatus()
+	require.Equal(t, SettleOutcomeGaveUp, st.Outcome)
+	require.Equal(t, "1 of 3 members", st.Reason)
+	require.Positive(t, st.Polls)
+}
+
+func TestSyncedAfterMerge(t *testing.T) {
+	o := testOptions()
+	p := newTestPeer(t, o)
+	addMapStates(p)
+	p.delegate.MergeRemoteState(marshalFullState(t, "other",
+		clusterpb.Part{Key: "a", Data: []byte("invalid")},
+	), false)
+	require.Empty(t, p.delegate.syncedPeers())
+
+	// States the peer doesn't have don't prevent syncing.
+	p.delegate.MergeRemoteState(marshalFullState(t, "other",
+		clusterpb.Part{Key: "a", Data: []byte(`{"x":"1"}`)},
+		clusterpb.Part{Key: "unknown", Data: []byte("{}")},
+	), false)
+	require.Equal(t, map[string]bool{"other": true}, p.delegate.syncedPeers())
+
+	p.delegate.forgetPeer("other")
+	require.Empty(t, p.delegate.syncedPeers())
+}
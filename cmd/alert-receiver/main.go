@@ -2,19 +2,24 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 type Alert struct {
 	Status       string            `json:"status"`
+	Fingerprint  string            `json:"fingerprint"`
 	Labels       map[string]string `json:"labels"`
 	Annotations  map[string]string `json:"annotations"`
 	StartsAt     time.Time         `json:"startsAt"`
@@ -34,9 +39,58 @@ type WebhookMessage struct {
 	Alerts            []Alert           `json:"alerts"`
 }
 
+// journalEntry is a single line of the journal file.
+type journalEntry struct {
+	Time time.Time `json:"time"`
+	WebhookMessage
+}
+
+// journal records every received notification as a line of JSON, so that
+// deliveries can be analysed after a run.
+type journal struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+func (j *journal) record(msg WebhookMessage) {
+	if j == nil {
+		return
+	}
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if err := j.enc.Encode(journalEntry{Time: time.Now(), WebhookMessage: msg}); err != nil {
+		logger.Error("Error writing journal", logKeyEvent, "journal", "err", err)
+	}
+}
+
+var deliveries *journal
+
+// responseDelay delays every response, simulating a slow receiver.
+var responseDelay time.Duration
+
+// Fields of the log records, the same as the orchestrator uses in
+// orchestrate/log.go, so that the records of the receiver can be told apart
+// from the ones of the Alertmanager instances.
+const (
+	logKeyInstance = "instance"
+	logKeyEvent    = "event"
+	logKeyGroupKey = "group_key"
+	logKeyReceiver = "receiver"
+	logKeyStatus   = "status"
+
+	instanceReceiver = "receiver"
+)
+
+var logger = newLogger(slog.NewTextHandler(os.Stdout, nil))
+
+// newLogger returns a logger which marks every record as one of the
+// receiver.
+func newLogger(h slog.Handler) *slog.Logger {
+	return slog.New(h).With(logKeyInstance, instanceReceiver)
+}
+
 // --- Handler ---
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("------------------------------------------------------")
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
@@ -44,55 +98,70 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	var msg WebhookMessage
 	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		log.Printf("Error decoding JSON: %v", err)
+		logger.Error("Error decoding JSON", logKeyEvent, "notification", "err", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	// 1. Log receipt
-	// Log the alerts to stdout
-	fmt.Printf("Received Group (Status: %s) | Receiver: %s\n", msg.Status, msg.Receiver)
+	deliveries.record(msg)
 
-	fmt.Printf("Sent by Alertmanager: %s\n", msg.ExternalURL)
-
-	for i, alert := range msg.Alerts {
-		fmt.Printf(" [%d] Alert: %s @ %s\n", i+1, alert.Labels["alertname"], alert.StartsAt.Format(time.RFC3339))
-		fmt.Println("     Labels:")
-		for k, v := range alert.Labels {
-			fmt.Printf("       %s: %s\n", k, v)
+	// 1. Log receipt
+	l := logger.With(logKeyGroupKey, msg.GroupKey, logKeyReceiver, msg.Receiver)
+	l.Info("Notification received", logKeyEvent, "notification", logKeyStatus, msg.Status, "external_url", msg.ExternalURL, "alerts", len(msg.Alerts))
+	for _, alert := range msg.Alerts {
+		attrs := []any{
+			logKeyEvent, "alert",
+			logKeyStatus, alert.Status,
+			"alertname", alert.Labels["alertname"],
+			"fingerprint", alert.Fingerprint,
+			"labels", labelsString(alert.Labels),
+			"starts_at", alert.StartsAt,
 		}
-
 		if val, ok := alert.Annotations["summary"]; ok {
-			fmt.Printf("     Summary: %s\n", val)
+			attrs = append(attrs, "summary", val)
 		}
 		if val, ok := alert.Annotations["description"]; ok {
-			fmt.Printf("     Description: %s\n", val)
+			attrs = append(attrs, "description", val)
 		}
-		fmt.Println("")
+		l.Info("Alert", attrs...)
 	}
 
 	// 2. Identify and Terminate the Sender
 	if msg.ExternalURL != "" {
-		fmt.Println("⚠️  Attempting to terminate sender...")
-		if err := terminateSender(msg.ExternalURL); err != nil {
-			fmt.Printf("❌ Failed to terminate sender: %v\n", err)
+		if err := terminateSender(l, msg.ExternalURL); err != nil {
+			l.Error("Failed to terminate sender", logKeyEvent, "terminate", "err", err)
 			// We continue to respond 200 OK even if kill failed,
 			// otherwise Alertmanager retries indefinitely.
 		}
 	} else {
-		fmt.Println("❌ No ExternalURL found in payload. Cannot identify sender.")
+		l.Warn("No ExternalURL found in payload, cannot identify sender", logKeyEvent, "terminate")
 	}
 
+	time.Sleep(responseDelay)
+
 	// 4. Send Response (Sender might be dead by now!)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Alert received. Sender terminated."))
-	fmt.Println("------------------------------------------------------")
+}
+
+// labelsString formats labels like Prometheus does, sorted by name.
+func labelsString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
 }
 
 // --- Helper Functions ---
 
 // terminateSender parses the URL, finds the port, and kills the process
-func terminateSender(rawURL string) error {
+func terminateSender(l *slog.Logger, rawURL string) error {
 	// 1. Parse URL to get the port
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
@@ -104,9 +173,8 @@ func terminateSender(rawURL string) error {
 		return fmt.Errorf("no port found in URL %s (is it standard 80/443?)", rawURL)
 	}
 
-	fmt.Printf("-> Identified sender port: %s\n", port)
 	if port != "9093" || port == "9093" {
-		fmt.Printf("--> Skipping Term\n")
+		l.Debug("Skipping termination of sender", logKeyEvent, "terminate", "port", port)
 		return nil
 	}
 
@@ -139,8 +207,6 @@ func terminateSender(rawURL string) error {
 			continue
 		} // Safety skip
 
-		fmt.Printf("-> Found PID %s. Sending SIGTERM...\n", pidStr)
-
 		// Convert PID string to int for syscall
 		var pidInt int
 		fmt.Sscan(pidStr, &pidInt)
@@ -148,14 +214,14 @@ func terminateSender(rawURL string) error {
 		// Send SIGTERM
 		p, err := os.FindProcess(pidInt)
 		if err != nil {
-			fmt.Printf("   Failed to find process: %v\n", err)
+			l.Error("Failed to find process", logKeyEvent, "terminate", "pid", pidInt, "err", err)
 			continue
 		}
 
 		if err := p.Signal(syscall.SIGKILL); err != nil {
-			fmt.Printf("   Failed to send SIGTERM: %v\n", err)
+			l.Error("Failed to signal process", logKeyEvent, "terminate", "pid", pidInt, "err", err)
 		} else {
-			fmt.Printf("   ✅ SIGTERM sent to PID %d\n", pidInt)
+			l.Info("Signalled sender", logKeyEvent, "terminate", "pid", pidInt)
 			killedPids[pidStr] = true
 		}
 	}
@@ -164,11 +230,44 @@ func terminateSender(rawURL string) error {
 }
 
 func main() {
+	journalFile := flag.String("journal.file", "", "Append every received notification as JSON to this file.")
+	flag.DurationVar(&responseDelay, "response.delay", 0, "Wait this long before responding to a notification.")
+	logFormat := flag.String("log.format", "text", "Log format, text or json.")
+	logLevel := flag.String("log.level", "info", "Log level, one of debug, info, warn and error.")
+	flag.Parse()
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch *logFormat {
+	case "text":
+		logger = newLogger(slog.NewTextHandler(os.Stdout, opts))
+	case "json":
+		logger = newLogger(slog.NewJSONHandler(os.Stdout, opts))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown log format %q\n", *logFormat)
+		os.Exit(2)
+	}
+
+	if *journalFile != "" {
+		f, err := os.OpenFile(*journalFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			logger.Error("Failed to open journal", "err", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		deliveries = &journal{enc: json.NewEncoder(f)}
+	}
+
 	http.HandleFunc("/alerts", webhookHandler)
 
 	port := ":9080"
-	log.Printf("Listening for alerts on %s/alerts...", port)
+	logger.Info("Listening for alerts", logKeyEvent, "start", "address", port+"/alerts")
 	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatal(err)
+		logger.Error("Server failed", "err", err)
+		os.Exit(1)
 	}
 }
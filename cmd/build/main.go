@@ -2,11 +2,34 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"slices"
+	"strings"
 
 	"github.com/SoloJacobs/am/orchestrate"
 )
 
 func main() {
-	_, err := orchestrate.NewBuild()
-	println(fmt.Sprintf("%v", err))
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	bins := b.Binaries()
+	slices.SortFunc(bins, func(a, b orchestrate.Binary) int { return strings.Compare(a.Name, b.Name) })
+
+	failed := false
+	for _, bin := range bins {
+		path, err := b.Path(bin.Name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s\t%s\n", bin.Name, path)
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
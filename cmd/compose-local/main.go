@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/SoloJacobs/am/orchestrate"
+)
+
+func main() {
+	file := flag.String("file", "assets/docker-compose.yaml", "Compose file describing the topology.")
+	logFormat := flag.String("log.format", orchestrate.LogFormatColor, "Output format of the orchestrator and its processes: color, text or json.")
+	flag.Parse()
+
+	logger, err := orchestrate.NewLogger(os.Stdout, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	orchestrate.SetLogger(logger)
+
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	c, err := orchestrate.LoadCompose(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := c.Up(ctx, b); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/SoloJacobs/am/orchestrate"
+)
+
+type paramFlags []orchestrate.Param
+
+func (p *paramFlags) String() string { return "" }
+
+func (p *paramFlags) Set(s string) error {
+	param, err := orchestrate.ParseParam(s)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, param)
+	return nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func main() {
+	var params paramFlags
+	scenario := flag.String("scenario", "all-peers", "Scenario to sweep, one of: "+strings.Join(slices.Sorted(maps.Keys(orchestrate.Scenarios)), ", ")+".")
+	flag.Var(&params, "param", "Parameter to sweep as name=v1,v2,... (repeatable), e.g. group_interval=2s,30s.")
+	runs := flag.Int("runs", 5, "Runs per parameter combination.")
+	format := flag.String("format", "csv", "Output format, csv or json.")
+	out := flag.String("out", "", "Write the matrix to this file instead of stdout.")
+	dir := flag.String("dir", "", "Directory for the run reports. Defaults to a temporary directory.")
+	logFormat := flag.String("log.format", orchestrate.LogFormatColor, "Output format of the orchestrator and its processes: color, text or json.")
+	flag.Parse()
+
+	logger, err := orchestrate.NewLogger(os.Stdout, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	orchestrate.SetLogger(logger)
+
+	s, ok := orchestrate.Scenarios[*scenario]
+	if !ok {
+		fatal(fmt.Errorf("unknown scenario %q", *scenario))
+	}
+	if *format != "csv" && *format != "json" {
+		fatal(fmt.Errorf("unknown format %q", *format))
+	}
+	if *dir == "" {
+		var err error
+		if *dir, err = os.MkdirTemp("", "am-sweep-"); err != nil {
+			fatal(err)
+		}
+	}
+	fmt.Printf("Writing reports to %s\n", *dir)
+
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	sweep := &orchestrate.Sweep{Scenario: s, Params: params, Runs: *runs, Dir: *dir}
+	results, err := sweep.Run(ctx, b)
+	if err != nil {
+		// Still write the points which completed.
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if err := writeMatrix(*out, *format, params, results); err != nil {
+		fatal(err)
+	}
+}
+
+// writeMatrix writes the results to the file at path, or to stdout if path is
+// empty. The file is closed before returning, so that it is complete once
+// the program exits.
+func writeMatrix(path, format string, params []orchestrate.Param, results []orchestrate.Result) (err error) {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+		w = f
+	}
+	if format == "json" {
+		return orchestrate.WriteJSON(w, results)
+	}
+	return orchestrate.WriteCSV(w, params, results)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/SoloJacobs/am/orchestrate"
+)
+
+func main() {
+	groupKey := flag.String("group-key", "", "Only show entries of this group key.")
+	alertName := flag.String("alertname", "", "Only show entries mentioning this alertname.")
+	sources := flag.String("source", "", "Comma-separated list of sources to show, e.g. 01-zebra,receiver.")
+	asJSON := flag.Bool("json", false, "Write the timeline as JSON lines.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <report dir or log file>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := orchestrate.ReadTimeline(flag.Args()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	filter := orchestrate.TimelineFilter{GroupKey: *groupKey, AlertName: *alertName}
+	if *sources != "" {
+		filter.Sources = strings.Split(*sources, ",")
+	}
+	var selected []orchestrate.LogEntry
+	for _, e := range entries {
+		if filter.Match(e) {
+			selected = append(selected, e)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range selected {
+			if err := enc.Encode(e); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+	if err := orchestrate.WriteTimeline(os.Stdout, selected); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -19,12 +19,16 @@ package nflog
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
+	"maps"
 	"math/rand"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -32,7 +36,7 @@ import (
 	"github.com/matttproud/golang_protobuf_extensions/pbutil"
 	"github.com/prometheus/common/promslog"
 
-	"github.com/prometheus/alertmanager/cluster"
+	"github.com/SoloJacobs/am/cluster"
 	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
 )
 
@@ -82,9 +86,13 @@ type Log struct {
 
 	// For now we only store the most recently added log entry.
 	// The key is a serialized concatenation of group key and receiver.
-	mtx       sync.RWMutex
-	st        state
-	broadcast func([]byte)
+	mtx sync.RWMutex
+	st  state
+	// broadcast is called with the state key of the entry as name if b
+	// holds a single entry, and without a name otherwise.
+	broadcast func(name string, b []byte)
+	// batch collects entries to broadcast while BatchBroadcasts runs.
+	batch *batch
 }
 
 // maintenanceFunc represents the function to run as part of the periodic maintenance for the nflog.
@@ -140,10 +148,12 @@ func decodeState(r io.Reader) (state, error) {
 	return st, nil
 }
 
-func marshalMeshEntry(e *pb.MeshEntry) ([]byte, error) {
+func marshalMeshEntries(entries ...*pb.MeshEntry) ([]byte, error) {
 	var buf bytes.Buffer
-	if _, err := pbutil.WriteDelimited(&buf, e); err != nil {
-		return nil, err
+	for _, e := range entries {
+		if _, err := pbutil.WriteDelimited(&buf, e); err != nil {
+			return nil, err
+		}
 	}
 	return buf.Bytes(), nil
 }
@@ -156,6 +166,8 @@ type Options struct {
 	Retention time.Duration
 
 	Logger *slog.Logger
+	// Clock defaults to the real clock.
+	Clock quartz.Clock
 }
 
 func (o *Options) validate() error {
@@ -178,12 +190,15 @@ func New(o Options) (*Log, error) {
 		retention: o.Retention,
 		logger:    promslog.NewNopLogger(),
 		st:        state{},
-		broadcast: func([]byte) {},
+		broadcast: func(string, []byte) {},
 	}
 
 	if o.Logger != nil {
 		l.logger = o.Logger
 	}
+	if o.Clock != nil {
+		l.clock = o.Clock
+	}
 
 	if o.SnapshotFile != "" {
 		if r, err := os.Open(o.SnapshotFile); err != nil {
@@ -320,16 +335,124 @@ func (l *Log) Log(r *pb.Receiver, gkey string, firingAlerts, resolvedAlerts []ui
 		ExpiresAt: expiresAt,
 	}
 
-	b, err := marshalMeshEntry(e)
-	if err != nil {
-		return err
-	}
 	l.st.merge(e, l.now())
-	l.broadcast(b)
+	return l.send(e)
+}
 
+// send broadcasts entries or adds them to the current batch. It must be
+// called with l.mtx held.
+func (l *Log) send(entries ...*pb.MeshEntry) error {
+	if l.batch == nil {
+		b, err := marshalMeshEntries(entries...)
+		if err != nil {
+			return err
+		}
+		var name string
+		if len(entries) == 1 {
+			name = stateKey(string(entries[0].Entry.GroupKey), entries[0].Entry.Receiver)
+		}
+		l.broadcast(name, b)
+		return nil
+	}
+	for _, e := range entries {
+		b, err := marshalMeshEntries(e)
+		if err != nil {
+			return err
+		}
+		key := stateKey(string(e.Entry.GroupKey), e.Entry.Receiver)
+		if !l.batch.fits(key, b) {
+			l.flush()
+		}
+		l.batch.add(key, b)
+		// Entries exceeding the budget on their own go out alone.
+		if l.batch.size > batchBudget {
+			l.flush()
+		}
+	}
 	return nil
 }
 
+// batchBudget is the maximum size of a batch. Batches within the budget are
+// gossiped to other peers instead of being sent to all of them over TCP. It
+// leaves room for the framing added by cluster.Channel.
+const batchBudget = cluster.MaxGossipPacketSize/2 - 64
+
+// batch holds serialized entries by their state key until they're broadcast
+// together. A newer entry replaces an older one of the same key.
+type batch struct {
+	keys    []string
+	entries map[string][]byte
+	size    int
+}
+
+func newBatch() *batch {
+	return &batch{entries: map[string][]byte{}}
+}
+
+// fits reports whether adding the entry keeps the batch within the budget.
+func (b *batch) fits(key string, e []byte) bool {
+	return b.size-len(b.entries[key])+len(e) <= batchBudget
+}
+
+func (b *batch) add(key string, e []byte) {
+	if prev, ok := b.entries[key]; ok {
+		b.size -= len(prev)
+	} else {
+		b.keys = append(b.keys, key)
+	}
+	b.entries[key] = e
+	b.size += len(e)
+}
+
+// flush broadcasts the current batch as a single message. It must be called
+// with l.mtx held.
+func (l *Log) flush() {
+	if len(l.batch.keys) == 0 {
+		return
+	}
+	var name string
+	if len(l.batch.keys) == 1 {
+		name = l.batch.keys[0]
+	}
+	buf := make([]byte, 0, l.batch.size)
+	for _, k := range l.batch.keys {
+		buf = append(buf, l.batch.entries[k]...)
+	}
+	l.batch = newBatch()
+	l.broadcast(name, buf)
+}
+
+// BatchBroadcasts coalesces the entries logged or newly merged within each
+// interval into as few broadcasts as the gossip packet budget allows. Without
+// it, every entry is broadcast on its own.
+// Terminates on receiving from stopc after broadcasting the pending entries.
+func (l *Log) BatchBroadcasts(interval time.Duration, stopc <-chan struct{}) {
+	if interval == 0 || stopc == nil {
+		l.logger.Error("interval or stop signal are missing - not batching broadcasts")
+		return
+	}
+	l.mtx.Lock()
+	l.batch = newBatch()
+	l.mtx.Unlock()
+
+	t := l.clock.NewTicker(interval, "nflog", "BatchBroadcasts")
+	defer t.Stop()
+	for {
+		select {
+		case <-stopc:
+			l.mtx.Lock()
+			l.flush()
+			l.batch = nil
+			l.mtx.Unlock()
+			return
+		case <-t.C:
+			l.mtx.Lock()
+			l.flush()
+			l.mtx.Unlock()
+		}
+	}
+}
+
 // gc implements the Log interface.
 func (l *Log) gc() (int, error) {
 	now := l.now()
@@ -414,6 +537,62 @@ func (l *Log) MarshalBinary() ([]byte, error) {
 	return l.st.MarshalBinary()
 }
 
+// Digest implements cluster.Digester. Unlike MarshalBinary, it doesn't depend
+// on the order of the entries in memory.
+func (l *Log) Digest() ([]byte, error) {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(l.st)) {
+		if _, err := pbutil.WriteDelimited(h, l.st[k]); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+var _ cluster.BucketState = (*Log)(nil)
+
+// BucketDigests implements cluster.BucketState.
+func (l *Log) BucketDigests(n int) ([][]byte, error) {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	hashes := make([]hash.Hash, n)
+	for i := range hashes {
+		hashes[i] = sha256.New()
+	}
+	for _, k := range slices.Sorted(maps.Keys(l.st)) {
+		if _, err := pbutil.WriteDelimited(hashes[cluster.BucketOf(k, n)], l.st[k]); err != nil {
+			return nil, err
+		}
+	}
+	digests := make([][]byte, n)
+	for i, h := range hashes {
+		// Half of the digest is plenty to tell buckets apart.
+		digests[i] = h.Sum(nil)[:16]
+	}
+	return digests, nil
+}
+
+// MarshalBuckets implements cluster.BucketState.
+func (l *Log) MarshalBuckets(n int, buckets []int) ([]byte, error) {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	var buf bytes.Buffer
+	for k, e := range l.st {
+		if !slices.Contains(buckets, cluster.BucketOf(k, n)) {
+			continue
+		}
+		if _, err := pbutil.WriteDelimited(&buf, e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
 // Merge merges notification log state received from the cluster with the local state.
 func (l *Log) Merge(b []byte) error {
 	st, err := decodeState(bytes.NewReader(b))
@@ -424,22 +603,33 @@ func (l *Log) Merge(b []byte) error {
 	defer l.mtx.Unlock()
 	now := l.now()
 
+	var merged []*pb.MeshEntry
 	for _, e := range st {
-		if merged := l.st.merge(e, now); merged && !cluster.OversizedMessage(b) {
-			// If this is the first we've seen the message and it's
-			// not oversized, gossip it to other nodes. We don't
-			// propagate oversized messages because they're sent to
-			// all nodes already.
-			l.broadcast(b)
-			l.logger.Debug("gossiping new entry", "entry", e)
+		if l.st.merge(e, now) {
+			merged = append(merged, e)
 		}
 	}
-	return nil
+	// Gossip the entries we've seen for the first time to other nodes. We
+	// don't propagate oversized messages because they're sent to all nodes
+	// already.
+	if len(merged) == 0 || cluster.OversizedMessage(b) {
+		return nil
+	}
+	l.logger.Debug("gossiping new entries", "entries", len(merged))
+	return l.send(merged...)
 }
 
 // SetBroadcast sets a broadcast callback that will be invoked with serialized state
 // on updates.
 func (l *Log) SetBroadcast(f func([]byte)) {
+	l.SetNamedBroadcast(func(_ string, b []byte) { f(b) })
+}
+
+// SetNamedBroadcast is like SetBroadcast, but the callback also receives the
+// state key of the entry if the state holds a single one. Newer entries can
+// then replace older entries of the same key which are still queued, see
+// cluster.ClusterChannel.
+func (l *Log) SetNamedBroadcast(f func(name string, b []byte)) {
 	l.mtx.Lock()
 	l.broadcast = f
 	l.mtx.Unlock()
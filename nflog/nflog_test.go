@@ -15,10 +15,12 @@ package nflog
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -28,6 +30,8 @@ import (
 
 	"github.com/coder/quartz"
 	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster"
 )
 
 func TestLogGC(t *testing.T) {
@@ -132,13 +136,13 @@ func TestWithMaintenance_SupportsCustomCallback(t *testing.T) {
 	f, err := os.CreateTemp(t.TempDir(), "snapshot")
 	require.NoError(t, err, "creating temp file failed")
 	stopc := make(chan struct{})
+	clock := quartz.NewMock(t)
 	opts := Options{
 		SnapshotFile: f.Name(),
+		Clock:        clock,
 	}
 
 	l, err := New(opts)
-	clock := quartz.NewMock(t)
-	l.clock = clock
 	require.NoError(t, err)
 
 	var calls atomic.Int32
@@ -264,6 +268,80 @@ func TestStateMerge(t *testing.T) {
 	}
 }
 
+func TestLogDigest(t *testing.T) {
+	clock := quartz.NewMock(t)
+	recv := &pb.Receiver{GroupName: "abc", Integration: "test", Idx: 1}
+	newLog := func(gkeys ...string) *Log {
+		l, err := New(Options{Retention: time.Hour, Clock: clock})
+		require.NoError(t, err)
+		for _, gkey := range gkeys {
+			require.NoError(t, l.Log(recv, gkey, []uint64{1}, nil, time.Hour))
+		}
+		return l
+	}
+	a := newLog("a", "b", "c")
+	b, err := a.MarshalBinary()
+	require.NoError(t, err)
+
+	// A log merged from the serialized entries has the same digest.
+	merged := newLog()
+	require.NoError(t, merged.Merge(b))
+	want, err := a.Digest()
+	require.NoError(t, err)
+	got, err := merged.Digest()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	clock.Advance(time.Second)
+	require.NoError(t, merged.Log(recv, "a", []uint64{2}, nil, time.Hour))
+	got, err = merged.Digest()
+	require.NoError(t, err)
+	require.NotEqual(t, want, got)
+}
+
+func TestLogBuckets(t *testing.T) {
+	clock := quartz.NewMock(t)
+	recv := &pb.Receiver{GroupName: "abc", Integration: "test", Idx: 1}
+	a, err := New(Options{Retention: time.Hour, Clock: clock})
+	require.NoError(t, err)
+	b, err := New(Options{Retention: time.Hour, Clock: clock})
+	require.NoError(t, err)
+	for _, gkey := range []string{"1", "2", "3", "4", "5", "6"} {
+		require.NoError(t, a.Log(recv, gkey, []uint64{1}, nil, time.Hour))
+	}
+	full, err := a.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, b.Merge(full))
+	clock.Advance(time.Second)
+	require.NoError(t, a.Log(recv, "7", []uint64{1}, nil, time.Hour))
+	require.NoError(t, a.Log(recv, "1", []uint64{2}, nil, time.Hour))
+
+	const n = 16
+	da, err := a.BucketDigests(n)
+	require.NoError(t, err)
+	db, err := b.BucketDigests(n)
+	require.NoError(t, err)
+	require.Len(t, da, n)
+	var divergent []int
+	for i := range da {
+		if !bytes.Equal(da[i], db[i]) {
+			divergent = append(divergent, i)
+		}
+	}
+	want := []int{cluster.BucketOf(stateKey("1", recv), n), cluster.BucketOf(stateKey("7", recv), n)}
+	slices.Sort(want)
+	require.Equal(t, slices.Compact(want), divergent)
+
+	// Sending the divergent buckets is enough to converge.
+	part, err := a.MarshalBuckets(n, divergent)
+	require.NoError(t, err)
+	require.Less(t, len(part), len(full))
+	require.NoError(t, b.Merge(part))
+	db, err = b.BucketDigests(n)
+	require.NoError(t, err)
+	require.Equal(t, da, db)
+}
+
 func TestStateDataCoding(t *testing.T) {
 	// Check whether encoding and decoding the data is symmetric.
 	mockClock := quartz.NewMock(t)
@@ -373,3 +451,126 @@ func TestStateDecodingError(t *testing.T) {
 func gosched() {
 	time.Sleep(1 * time.Millisecond)
 }
+
+// recordBroadcasts collects the messages broadcast by l.
+func recordBroadcasts(l *Log) func() [][]byte {
+	var (
+		mtx  sync.Mutex
+		msgs [][]byte
+	)
+	l.SetBroadcast(func(b []byte) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		msgs = append(msgs, b)
+	})
+	return func() [][]byte {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return slices.Clone(msgs)
+	}
+}
+
+func TestMergeRebroadcastsNewEntries(t *testing.T) {
+	clock := quartz.NewMock(t)
+	recv := &pb.Receiver{GroupName: "abc", Integration: "test", Idx: 1}
+	a, err := New(Options{Retention: time.Hour, Clock: clock})
+	require.NoError(t, err)
+	b, err := New(Options{Retention: time.Hour, Clock: clock})
+	require.NoError(t, err)
+	for _, gkey := range []string{"1", "2", "3"} {
+		require.NoError(t, a.Log(recv, gkey, []uint64{1}, nil, time.Hour))
+	}
+	require.NoError(t, b.Log(recv, "1", []uint64{1}, nil, time.Hour))
+	msg, err := a.MarshalBinary()
+	require.NoError(t, err)
+
+	sent := recordBroadcasts(b)
+	require.NoError(t, b.Merge(msg))
+	msgs := sent()
+	require.Len(t, msgs, 1)
+	st, err := decodeState(bytes.NewReader(msgs[0]))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{stateKey("2", recv), stateKey("3", recv)}, slices.Collect(maps.Keys(st)))
+
+	// Nothing is new the second time.
+	require.NoError(t, b.Merge(msg))
+	require.Len(t, sent(), 1)
+}
+
+func TestBatchBroadcasts(t *testing.T) {
+	ctx := t.Context()
+	clock := quartz.NewMock(t)
+	trap := clock.Trap().NewTicker("nflog", "BatchBroadcasts")
+	defer trap.Close()
+	l, err := New(Options{Retention: time.Hour, Clock: clock})
+	require.NoError(t, err)
+	sent := recordBroadcasts(l)
+
+	stopc := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.BatchBroadcasts(time.Second, stopc)
+	}()
+	trap.MustWait(ctx).MustRelease(ctx)
+
+	recv := &pb.Receiver{GroupName: "abc", Integration: "test", Idx: 1}
+	const n = 50
+	for i := range n {
+		require.NoError(t, l.Log(recv, fmt.Sprint(i), []uint64{1, 2, 3}, nil, time.Hour))
+	}
+	// Logging an entry again replaces the batched one.
+	clock.Advance(time.Millisecond)
+	require.NoError(t, l.Log(recv, fmt.Sprint(n-1), []uint64{4}, nil, time.Hour))
+	// Some batches were full, the rest waits for the interval.
+	full := len(sent())
+	require.Positive(t, full)
+	clock.Advance(time.Second - time.Millisecond).MustWait(ctx)
+	require.Eventually(t, func() bool { return len(sent()) > full }, 5*time.Second, 10*time.Millisecond)
+
+	seen := map[string]*pb.MeshEntry{}
+	for _, msg := range sent() {
+		require.False(t, cluster.OversizedMessage(msg))
+		st, err := decodeState(bytes.NewReader(msg))
+		require.NoError(t, err)
+		for k, e := range st {
+			require.NotContains(t, seen, k)
+			seen[k] = e
+		}
+	}
+	require.Len(t, seen, n)
+	require.Equal(t, []uint64{4}, seen[stateKey(fmt.Sprint(n-1), recv)].Entry.FiringAlerts)
+
+	// Pending entries are broadcast on stop, later ones right away.
+	require.NoError(t, l.Log(recv, "last", []uint64{1}, nil, time.Hour))
+	before := len(sent())
+	close(stopc)
+	<-done
+	require.Len(t, sent(), before+1)
+	require.NoError(t, l.Log(recv, "after", []uint64{1}, nil, time.Hour))
+	require.Len(t, sent(), before+2)
+}
+
+func TestNamedBroadcasts(t *testing.T) {
+	clock := quartz.NewMock(t)
+	recv := &pb.Receiver{GroupName: "abc", Integration: "test", Idx: 1}
+	a, err := New(Options{Retention: time.Hour, Clock: clock})
+	require.NoError(t, err)
+	b, err := New(Options{Retention: time.Hour, Clock: clock})
+	require.NoError(t, err)
+	var names []string
+	a.SetNamedBroadcast(func(name string, _ []byte) { names = append(names, name) })
+	b.SetNamedBroadcast(func(name string, _ []byte) { names = append(names, name) })
+
+	// Single entries are named by their state key.
+	require.NoError(t, a.Log(recv, "1", []uint64{1}, nil, time.Hour))
+	require.NoError(t, a.Log(recv, "2", []uint64{1}, nil, time.Hour))
+	require.Equal(t, []string{stateKey("1", recv), stateKey("2", recv)}, names)
+
+	// Several entries can't replace a single one.
+	names = nil
+	msg, err := a.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, b.Merge(msg))
+	require.Equal(t, []string{""}, names)
+}
@@ -0,0 +1,160 @@
+package orchestrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SoloJacobs/am/cluster"
+)
+
+// Matcher is a silence matcher of the Alertmanager API.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+// Silence is a silence as returned by the Alertmanager API.
+type Silence struct {
+	ID        string    `json:"id,omitempty"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	Comment   string    `json:"comment"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+	Status    struct {
+		State string `json:"state"`
+	} `json:"status"`
+}
+
+// ClusterStatus is the cluster part of /api/v2/status.
+type ClusterStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Peers  []struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	} `json:"peers"`
+}
+
+func getJSON(port int, path string, v any) error {
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("GET %s: unexpected status %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// CreateSilence creates a silence for the given equality matchers on the
+// Alertmanager listening on port and returns its ID.
+func CreateSilence(port int, matchers map[string]string, d time.Duration) (string, error) {
+	now := time.Now()
+	sil := struct {
+		Silence
+		CreatedBy string `json:"createdBy"`
+	}{
+		Silence: Silence{
+			StartsAt: now,
+			EndsAt:   now.Add(d),
+			Comment:  "Created by the orchestrator.",
+		},
+		CreatedBy: "orchestrator",
+	}
+	for name, value := range matchers {
+		sil.Matchers = append(sil.Matchers, Matcher{Name: name, Value: value, IsEqual: true})
+	}
+	b, err := json.Marshal(sil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/api/v2/silences", port), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("create silence: unexpected status %s", resp.Status)
+	}
+	var res struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	return res.SilenceID, nil
+}
+
+// ListSilences returns all silences known to the Alertmanager listening on
+// port.
+func ListSilences(port int) ([]Silence, error) {
+	var sils []Silence
+	return sils, getJSON(port, "/api/v2/silences", &sils)
+}
+
+// GetClusterStatus returns the cluster status of the Alertmanager listening on
+// port.
+func GetClusterStatus(port int) (ClusterStatus, error) {
+	var status struct {
+		Cluster ClusterStatus `json:"cluster"`
+	}
+	return status.Cluster, getJSON(port, "/api/v2/status", &status)
+}
+
+// Digests returns the view of the Alertmanager listening on port on the
+// cluster. Alertmanagers serving cluster.DigestPath report the digests of all
+// their states. For all others, including upstream releases, the silence
+// digest is derived from the API and the digest of the notification log is
+// cluster.UnknownDigest, as the API doesn't expose it.
+func Digests(ctx context.Context, port int) (cluster.MemberDigests, error) {
+	md, err := cluster.HTTPDigests(nil, fmt.Sprintf("http://localhost:%d%s", port, cluster.DigestPath))(ctx)
+	if err == nil {
+		return md, nil
+	}
+	status, err := GetClusterStatus(port)
+	if err != nil {
+		return cluster.MemberDigests{}, err
+	}
+	sils, err := ListSilences(port)
+	if err != nil {
+		return cluster.MemberDigests{}, err
+	}
+	md = cluster.MemberDigests{Name: status.Name}
+	for _, p := range status.Peers {
+		md.Members = append(md.Members, p.Name)
+	}
+	slices.Sort(md.Members)
+	slices.SortFunc(sils, func(a, b Silence) int { return strings.Compare(a.ID, b.ID) })
+	h := sha256.New()
+	for _, sil := range sils {
+		fmt.Fprintf(h, "%s %s %s\n", sil.ID, sil.UpdatedAt.Format(time.RFC3339Nano), sil.EndsAt.Format(time.RFC3339Nano))
+	}
+	md.States = map[string]string{"sil": hex.EncodeToString(h.Sum(nil)), "nfl": cluster.UnknownDigest}
+	return md, nil
+}
+
+// WaitFor calls f every second until it succeeds or timeout has passed. It
+// returns the last error of f.
+func WaitFor(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,68 @@
+package orchestrate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/SoloJacobs/am/cluster"
+)
+
+func serverPort(t *testing.T, srv *httptest.Server) int {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return port
+}
+
+func TestDigestsFromAPI(t *testing.T) {
+	silences := `[{"id":"b","updatedAt":"2025-01-01T00:00:00Z"},{"id":"a","updatedAt":"2025-01-01T00:00:00Z"}]`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/status", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"cluster":{"name":"self","status":"ready","peers":[{"name":"self"},{"name":"other"}]}}`))
+	})
+	mux.HandleFunc("/api/v2/silences", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(silences))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	md, err := Digests(context.Background(), serverPort(t, srv))
+	require.NoError(t, err)
+	require.Equal(t, "self", md.Name)
+	require.Equal(t, []string{"other", "self"}, md.Members)
+	require.Contains(t, md.States, "sil")
+	require.Equal(t, cluster.UnknownDigest, md.States["nfl"])
+
+	// The order of the silences doesn't matter, their updates do.
+	silences = `[{"id":"a","updatedAt":"2025-01-01T00:00:00Z"},{"id":"b","updatedAt":"2025-01-01T00:00:00Z"}]`
+	reordered, err := Digests(context.Background(), serverPort(t, srv))
+	require.NoError(t, err)
+	require.Equal(t, md, reordered)
+	silences = `[{"id":"a","updatedAt":"2025-01-01T00:00:01Z"},{"id":"b","updatedAt":"2025-01-01T00:00:00Z"}]`
+	updated, err := Digests(context.Background(), serverPort(t, srv))
+	require.NoError(t, err)
+	require.Equal(t, []string{"sil"}, cluster.Divergent([]cluster.MemberDigests{md, updated}))
+}
+
+func TestDigestsFromDebugEndpoint(t *testing.T) {
+	want := cluster.MemberDigests{Name: "self", Members: []string{"self"}, States: map[string]string{"nfl": "1", "sil": "2"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(cluster.DigestPath, func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(want)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	md, err := Digests(context.Background(), serverPort(t, srv))
+	require.NoError(t, err)
+	require.Equal(t, want, md)
+}
@@ -1,6 +1,8 @@
 package orchestrate
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -9,83 +11,440 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
+// DefaultBinary is the manifest entry used for instances which don't ask for
+// a specific binary.
+const DefaultBinary = "alertmanager"
+
+// Source describes one way of obtaining a binary. Exactly one of Path,
+// Tarball, Checkout and Script is set.
+type Source struct {
+	Path     string `yaml:"path,omitempty"`
+	Tarball  string `yaml:"tarball,omitempty"`
+	Member   string `yaml:"member,omitempty"`
+	Checkout string `yaml:"checkout,omitempty"`
+	Package  string `yaml:"package,omitempty"`
+	// Commit and Go pin the commit of a checkout and the version of the
+	// go toolchain building it. A checkout which doesn't match isn't built.
+	Commit string `yaml:"commit,omitempty"`
+	Go     string `yaml:"go,omitempty"`
+	Script string `yaml:"script,omitempty"`
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Path != "":
+		return "path " + s.Path
+	case s.Tarball != "":
+		return "tarball " + s.Tarball
+	case s.Checkout != "":
+		return "checkout " + s.Checkout
+	default:
+		return "script " + s.Script
+	}
+}
+
+func (s Source) validate() error {
+	n := 0
+	for _, v := range []string{s.Path, s.Tarball, s.Checkout, s.Script} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of path, tarball, checkout and script must be set")
+	}
+	if s.Tarball != "" && s.Member == "" {
+		return errors.New("tarball source requires member")
+	}
+	if s.Checkout == "" && (s.Commit != "" || s.Go != "") {
+		return errors.New("commit and go are only valid for checkout sources")
+	}
+	return nil
+}
+
+// Binary is an entry of the binary manifest.
+type Binary struct {
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version,omitempty"`
+	SHA256  string   `yaml:"sha256,omitempty"`
+	Sources []Source `yaml:"sources"`
+}
+
+type manifest struct {
+	Binaries []Binary `yaml:"binaries"`
+}
+
+// Build resolves the binaries listed in scripts/build/binaries.yml.
 type Build struct {
-	rootDir string
+	rootDir  string
+	cacheDir string
+	binaries map[string]Binary
+
+	mtx      sync.Mutex
+	resolved map[string]string
 }
 
-func NewBuild() (Build, error) {
+// NewBuild loads the binary manifest of the repository. Binaries are only
+// provisioned once they are requested via Path.
+//
+// The cache directory is $AM_CACHE_DIR, or am/ below the user cache directory.
+func NewBuild() (*Build, error) {
 	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
 	if err != nil {
-		return Build{}, fmt.Errorf("failed to determine project root: %w", err)
+		return nil, fmt.Errorf("failed to determine project root: %w", err)
 	}
 	rootDir := strings.TrimSpace(string(out))
-	binaries := []struct {
-		Name string
-		SHA  string
-	}{
-		{
-			"am_v0_31_0",
-			"f33d4897a96da0ecf9c93dcdcd89ae25b42c056f53bff991340ec685c7f0bf0a",
-		},
-		{
-			"am_proto",
-			"698094ea606fb992b060026c661b6c7dbdd0f05f20b328f27910b6b778d7ce3e",
-		},
+
+	cacheDir := os.Getenv("AM_CACHE_DIR")
+	if cacheDir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine cache directory: %w", err)
+		}
+		cacheDir = filepath.Join(userCache, "am")
+	}
+	return loadBuild(rootDir, cacheDir, filepath.Join(rootDir, "scripts", "build", "binaries.yml"))
+}
+
+func loadBuild(rootDir, cacheDir, manifestPath string) (*Build, error) {
+	b, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", manifestPath, err)
 	}
 
-	for _, bin := range binaries {
-		if _, err := ensure(rootDir, bin.Name, bin.SHA); err != nil {
-			return Build{}, err
+	binaries := map[string]Binary{}
+	for _, bin := range m.Binaries {
+		if _, ok := binaries[bin.Name]; ok {
+			return nil, fmt.Errorf("binary %s listed twice", bin.Name)
+		}
+		if len(bin.Sources) == 0 {
+			return nil, fmt.Errorf("binary %s has no sources", bin.Name)
+		}
+		for _, src := range bin.Sources {
+			if err := src.validate(); err != nil {
+				return nil, fmt.Errorf("binary %s: %w", bin.Name, err)
+			}
+			// Builds are only reproducible from the same commit with the
+			// same toolchain.
+			if bin.SHA256 != "" && src.Checkout != "" && (src.Commit == "" || src.Go == "") {
+				return nil, fmt.Errorf("binary %s: checkout source of a binary with sha256 requires commit and go", bin.Name)
+			}
 		}
+		binaries[bin.Name] = bin
 	}
-	return Build{}, err
+	return &Build{
+		rootDir:  rootDir,
+		cacheDir: cacheDir,
+		binaries: binaries,
+		resolved: map[string]string{},
+	}, nil
 }
 
-func build(scriptPath string, binaryPath string) error {
-	tmpDir := filepath.Join(os.TempDir(), fmt.Sprintf("build-%d", time.Now().UnixNano()))
-	fmt.Printf("Creating %s \n", tmpDir)
-	err := os.Mkdir(tmpDir, 0o700)
+// Binaries returns the manifest entries.
+func (b *Build) Binaries() []Binary {
+	bins := make([]Binary, 0, len(b.binaries))
+	for _, bin := range b.binaries {
+		bins = append(bins, bin)
+	}
+	return bins
+}
+
+// Path returns the path of the named binary inside the content-addressed
+// cache, provisioning it first if necessary.
+func (b *Build) Path(name string) (string, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if path, ok := b.resolved[name]; ok {
+		return path, nil
+	}
+	bin, ok := b.binaries[name]
+	if !ok {
+		return "", fmt.Errorf("binary %s is not in the manifest", name)
+	}
+	path, err := b.ensure(bin)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("binary %s: %w", name, err)
 	}
-	defer os.RemoveAll(tmpDir)
+	b.resolved[name] = path
+	return path, nil
+}
 
-	cmd := exec.Command(scriptPath, binaryPath)
-	cmd.Dir = tmpDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func (b *Build) casPath(sha string) string {
+	return filepath.Join(b.cacheDir, "sha256", sha)
+}
 
-	return cmd.Run()
+// tarballPath is the path of the tarball of src, relative paths are below
+// the cache directory.
+func (b *Build) tarballPath(src Source) string {
+	if filepath.IsAbs(src.Tarball) {
+		return src.Tarball
+	}
+	return filepath.Join(b.cacheDir, "tarballs", src.Tarball)
 }
 
-func ensure(rootDir string, name string, expectedSHA string) (string, error) {
-	scriptPath := filepath.Join(rootDir, "scripts", "build", name+".sh")
-	binaryPath := filepath.Join(rootDir, "bin", name)
+func (b *Build) abs(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(b.rootDir, path)
+}
 
-	if _, err := os.Stat(binaryPath); errors.Is(err, os.ErrNotExist) {
-		fmt.Printf("Building %s using %s...\n", name, filepath.Base(scriptPath))
-		err = build(scriptPath, binaryPath)
-		if err != nil {
+func (b *Build) ensure(bin Binary) (string, error) {
+	if bin.SHA256 != "" {
+		path := b.casPath(bin.SHA256)
+		if sha, err := computeSHA(path); err == nil && sha == bin.SHA256 {
+			logger.Info("Found binary in cache", logKeyEvent, "build", "binary", bin.Name, "path", path)
+			return path, nil
+		}
+	}
+
+	for _, dir := range []string{"sha256", "sources"} {
+		if err := os.MkdirAll(filepath.Join(b.cacheDir, dir), 0o755); err != nil {
 			return "", err
 		}
-	} else if err != nil {
+	}
+	var errs []error
+	for _, src := range bin.Sources {
+		// Without sha256, the binary is looked up by the input of its
+		// source instead.
+		var key string
+		if bin.SHA256 == "" {
+			key = b.sourceKey(src)
+			if path, ok := b.lookupSource(key); ok {
+				logger.Info("Found binary in cache", logKeyEvent, "build", "binary", bin.Name, "source", src, "path", path)
+				return path, nil
+			}
+		}
+		path, err := b.provision(bin, src)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", src, err))
+			continue
+		}
+		if key != "" {
+			if err := b.recordSource(key, filepath.Base(path)); err != nil {
+				logger.Warn("Failed to record binary source", logKeyEvent, "build", "binary", bin.Name, "source", src, "err", err)
+			}
+		}
+		return path, nil
+	}
+	return "", errors.Join(errs...)
+}
+
+// sourceKey identifies the input of src: the size and modification time of
+// files and the commit and go version of clean checkouts. It is empty if the
+// input can't be identified, like for scripts, which always provision again.
+func (b *Build) sourceKey(src Source) string {
+	switch {
+	case src.Path != "":
+		return fileKey(b.abs(src.Path))
+	case src.Tarball != "":
+		if key := fileKey(b.tarballPath(src)); key != "" {
+			return key + " " + src.Member
+		}
+	case src.Checkout != "":
+		dir := b.abs(src.Checkout)
+		out, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+		if err != nil || len(out) > 0 {
+			return ""
+		}
+		head, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+		if err != nil {
+			return ""
+		}
+		cmd := exec.Command("go", "env", "GOVERSION")
+		cmd.Dir = dir
+		goVersion, err := cmd.Output()
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("checkout %s %s %s %s", dir, src.Package, strings.TrimSpace(string(head)), strings.TrimSpace(string(goVersion)))
+	}
+	return ""
+}
+
+func fileKey(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("file %s %d %d", path, fi.Size(), fi.ModTime().UnixNano())
+}
+
+func (b *Build) sourcePath(key string) string {
+	return filepath.Join(b.cacheDir, "sources", fmt.Sprintf("%x", sha256.Sum256([]byte(key))))
+}
+
+// lookupSource returns the cached binary provisioned from the source with
+// the given key.
+func (b *Build) lookupSource(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	sha, err := os.ReadFile(b.sourcePath(key))
+	if err != nil {
+		return "", false
+	}
+	path := b.casPath(string(sha))
+	if got, err := computeSHA(path); err != nil || got != string(sha) {
+		return "", false
+	}
+	return path, true
+}
+
+func (b *Build) recordSource(key, sha string) error {
+	return os.WriteFile(b.sourcePath(key), []byte(sha), 0o644)
+}
+
+// provision obtains the binary from src, verifies it and moves it into the
+// cache.
+func (b *Build) provision(bin Binary, src Source) (string, error) {
+	tmp, err := os.CreateTemp(b.cacheDir, "tmp-"+bin.Name+"-")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	logger.Info("Provisioning binary", logKeyEvent, "build", "binary", bin.Name, "source", src)
+	switch {
+	case src.Path != "":
+		err = copyFile(tmp, b.abs(src.Path))
+	case src.Tarball != "":
+		err = extractMember(tmp, b.tarballPath(src), src.Member)
+	case src.Checkout != "":
+		pkg := src.Package
+		if pkg == "" {
+			pkg = "./cmd/alertmanager"
+		}
+		if err = checkCheckout(b.abs(src.Checkout), src.Commit, src.Go); err == nil {
+			err = goBuild(b.abs(src.Checkout), pkg, tmpPath)
+		}
+	case src.Script != "":
+		err = build(b.abs(src.Script), tmpPath)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		return "", err
-	} else {
-		fmt.Printf("Found %v\n", binaryPath)
 	}
 
-	fmt.Printf("Computing sha of %v\n", binaryPath)
-	sha, err := computeSHA(binaryPath)
+	sha, err := computeSHA(tmpPath)
 	if err != nil {
 		return "", err
 	}
-	if sha != expectedSHA {
-		return "", fmt.Errorf("SHA-mismatch: got %v, expected %v", sha, expectedSHA)
+	if bin.SHA256 != "" && sha != bin.SHA256 {
+		return "", fmt.Errorf("SHA-mismatch: got %v, expected %v", sha, bin.SHA256)
+	}
+	if err := os.Chmod(tmpPath, 0o755); err != nil {
+		return "", err
+	}
+	path := b.casPath(sha)
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func copyFile(dst io.Writer, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+func extractMember(dst io.Writer, tarball, member string) error {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
 	}
-	return binaryPath, nil
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s not found in %s", member, tarball)
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == member {
+			_, err = io.Copy(dst, tr)
+			return err
+		}
+	}
+}
+
+// checkCheckout verifies that the checkout in dir is at commit and that the
+// go toolchain there has version goVersion, unless they are empty. commit may
+// be abbreviated.
+func checkCheckout(dir, commit, goVersion string) error {
+	if commit != "" {
+		out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+		if err != nil {
+			return fmt.Errorf("determine commit: %w", err)
+		}
+		if head := strings.TrimSpace(string(out)); !strings.HasPrefix(head, commit) {
+			return fmt.Errorf("checkout is at commit %s, expected %s", head, commit)
+		}
+	}
+	if goVersion != "" {
+		cmd := exec.Command("go", "env", "GOVERSION")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("determine go version: %w", err)
+		}
+		if v := strings.TrimSpace(string(out)); v != "go"+goVersion {
+			return fmt.Errorf("go version is %s, expected go%s", v, goVersion)
+		}
+	}
+	return nil
+}
+
+func goBuild(dir, pkg, binaryPath string) error {
+	cmd := exec.Command("go", "build", "-trimpath", "-buildvcs=false", "-ldflags", "-s -w", "-o", binaryPath, pkg)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func build(scriptPath string, binaryPath string) error {
+	tmpDir := filepath.Join(os.TempDir(), fmt.Sprintf("build-%d", time.Now().UnixNano()))
+	err := os.Mkdir(tmpDir, 0o700)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmd := exec.Command(scriptPath, binaryPath)
+	cmd.Dir = tmpDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
 }
 
 func computeSHA(path string) (string, error) {
@@ -0,0 +1,179 @@
+package orchestrate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeManifest(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "binaries.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestBuildPathSource(t *testing.T) {
+	root, cache := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "am"), []byte("binary"), 0o755))
+	sha, err := computeSHA(filepath.Join(root, "am"))
+	require.NoError(t, err)
+
+	b, err := loadBuild(root, cache, writeManifest(t, root, `
+binaries:
+  - name: am
+    sha256: `+sha+`
+    sources:
+      - path: missing
+      - path: am
+`))
+	require.NoError(t, err)
+
+	path, err := b.Path("am")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cache, "sha256", sha), path)
+
+	// The cached binary is found without any source.
+	require.NoError(t, os.Remove(filepath.Join(root, "am")))
+	b.resolved = map[string]string{}
+	path, err = b.Path("am")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cache, "sha256", sha), path)
+
+	_, err = b.Path("unknown")
+	require.Error(t, err)
+}
+
+func TestBuildUnpinnedCache(t *testing.T) {
+	root, cache := t.TempDir(), t.TempDir()
+	src := filepath.Join(root, "am")
+	require.NoError(t, os.WriteFile(src, []byte("binary"), 0o755))
+	manifest := writeManifest(t, root, `
+binaries:
+  - name: am
+    sources:
+      - path: am
+`)
+	resolve := func() string {
+		t.Helper()
+		b, err := loadBuild(root, cache, manifest)
+		require.NoError(t, err)
+		path, err := b.Path("am")
+		require.NoError(t, err)
+		return path
+	}
+	first := resolve()
+
+	// A source with the same size and modification time isn't copied again.
+	fi, err := os.Stat(src)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(src, []byte("BINARY"), 0o755))
+	require.NoError(t, os.Chtimes(src, fi.ModTime(), fi.ModTime()))
+	require.Equal(t, first, resolve())
+
+	require.NoError(t, os.Chtimes(src, fi.ModTime().Add(time.Second), fi.ModTime().Add(time.Second)))
+	second := resolve()
+	require.NotEqual(t, first, second)
+	sha, err := computeSHA(src)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cache, "sha256", sha), second)
+}
+
+func TestBuildChecksumMismatch(t *testing.T) {
+	root, cache := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "am"), []byte("binary"), 0o755))
+
+	b, err := loadBuild(root, cache, writeManifest(t, root, `
+binaries:
+  - name: am
+    sha256: 0000
+    sources:
+      - path: am
+`))
+	require.NoError(t, err)
+	_, err = b.Path("am")
+	require.ErrorContains(t, err, "SHA-mismatch")
+}
+
+func TestBuildTarballSource(t *testing.T) {
+	root, cache := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(cache, "tarballs"), 0o755))
+
+	f, err := os.Create(filepath.Join(cache, "tarballs", "am.tar.gz"))
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	content := []byte("release binary")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "am-1.0/alertmanager", Mode: 0o755, Size: int64(len(content))}))
+	_, err = tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, f.Close())
+
+	b, err := loadBuild(root, cache, writeManifest(t, root, `
+binaries:
+  - name: am
+    sources:
+      - tarball: am.tar.gz
+        member: am-1.0/alertmanager
+`))
+	require.NoError(t, err)
+	path, err := b.Path("am")
+	require.NoError(t, err)
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+}
+
+func TestBuildInvalidManifest(t *testing.T) {
+	root := t.TempDir()
+	_, err := loadBuild(root, t.TempDir(), writeManifest(t, root, `
+binaries:
+  - name: am
+    sources:
+      - path: am
+        checkout: ../am
+`))
+	require.Error(t, err)
+}
+
+func TestBuildCheckoutPinned(t *testing.T) {
+	root, cache := t.TempDir(), t.TempDir()
+	_, err := loadBuild(root, cache, writeManifest(t, root, `
+binaries:
+  - name: am
+    sha256: 0000
+    sources:
+      - checkout: ../am
+`))
+	require.ErrorContains(t, err, "requires commit and go")
+
+	// A checkout at another commit isn't built.
+	checkout := filepath.Join(root, "am")
+	require.NoError(t, os.Mkdir(checkout, 0o755))
+	require.NoError(t, exec.Command("git", "-C", checkout, "init", "-q").Run())
+	require.NoError(t, exec.Command("git", "-C", checkout, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init").Run())
+	b, err := loadBuild(root, cache, writeManifest(t, root, `
+binaries:
+  - name: am
+    sources:
+      - checkout: am
+        commit: 0000000
+`))
+	require.NoError(t, err)
+	_, err = b.Path("am")
+	require.ErrorContains(t, err, "expected 0000000")
+}
+
+func TestBuildRepositoryManifest(t *testing.T) {
+	b, err := loadBuild("..", t.TempDir(), "../scripts/build/binaries.yml")
+	require.NoError(t, err)
+	require.Contains(t, b.binaries, DefaultBinary)
+}
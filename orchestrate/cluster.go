@@ -0,0 +1,352 @@
+package orchestrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SoloJacobs/am/cluster"
+)
+
+// stopTimeout is how long an instance gets to write its snapshots on
+// shutdown before it is killed.
+const stopTimeout = 30 * time.Second
+
+type member struct {
+	inst    Instance
+	storage string
+	proc    *process
+	// proxy delays the gossip to the instance, if the timing asks for
+	// latency. It outlives restarts of the instance.
+	proxy *latencyProxy
+}
+
+// Cluster is a set of local Alertmanager processes. Instances keep their
+// storage directory across restarts, so that a restarted instance loads its
+// nflog and silence snapshots like it would in a real deployment.
+type Cluster struct {
+	build        *Build
+	templatePath string
+
+	mtx       sync.Mutex
+	params    ConfigParams
+	timing    Timing
+	logLevel  string
+	report    *Report
+	configDir string
+	members   []*member
+	receiver  *process
+}
+
+// NewCluster prepares a cluster of the given instances using the
+// configuration template of a setup. No process is started yet.
+func NewCluster(b *Build, setupName string, instances []Instance) *Cluster {
+	cwd, _ := os.Getwd()
+	c := &Cluster{
+		build:        b,
+		templatePath: filepath.Join(cwd, "setups", setupName, "alertmanager.yml.tmpl"),
+	}
+	for _, inst := range instances {
+		if inst.Binary == "" {
+			inst.Binary = DefaultBinary
+		}
+		c.members = append(c.members, &member{inst: inst})
+	}
+	return c
+}
+
+// SetParams sets the parameters the configuration template is rendered
+// with. They apply to all instances started afterwards.
+func (c *Cluster) SetParams(p ConfigParams) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.params = p
+}
+
+// SetTiming sets the cluster timing flags. They apply to all instances
+// started afterwards.
+func (c *Cluster) SetTiming(t Timing) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.timing = t
+}
+
+// SetLogLevel sets the log level of the instances started afterwards. The
+// default is info; debug logs every flush and gossip message, which is what
+// the timeline needs to explain a race.
+func (c *Cluster) SetLogLevel(level string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.logLevel = level
+}
+
+// SetReport makes the cluster write logs, configs, snapshots and its actions
+// to r. It must be called before Start.
+func (c *Cluster) SetReport(r *Report) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.report = r
+}
+
+// StartReceiver starts bin/alert-receiver with the given arguments. The
+// receiver is stopped by Shutdown after all instances, and writes its journal
+// to the report, if any.
+func (c *Cluster) StartReceiver(args ...string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.receiver != nil {
+		return errors.New("receiver is already running")
+	}
+	p, err := startReceiver(c.report, args...)
+	if err != nil {
+		return err
+	}
+	c.receiver = p
+	return nil
+}
+
+// Instances returns the instances of the cluster with the binary they
+// currently run.
+func (c *Cluster) Instances() []Instance {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	instances := make([]Instance, 0, len(c.members))
+	for _, m := range c.members {
+		instances = append(instances, m.inst)
+	}
+	return instances
+}
+
+// ErrUnknownStates is returned by WaitConverged if the instances agree on
+// everything but states which can't be compared.
+var ErrUnknownStates = errors.New("convergence of states is unknown")
+
+// WaitConverged waits until all running instances agree on the members of
+// the cluster and their gossiped state, see Digests. If the instances agree
+// but some states can't be compared, as for the notification log of
+// Alertmanagers which don't serve cluster.DigestPath, it returns an error
+// wrapping ErrUnknownStates. The unknown states are listed in
+// Convergence.Unknown.
+func (c *Cluster) WaitConverged(ctx context.Context) (cluster.Convergence, error) {
+	c.mtx.Lock()
+	checker := cluster.ConvergenceChecker{Interval: 200 * time.Millisecond}
+	for _, m := range c.members {
+		if m.proc != nil {
+			port := m.inst.WebPort
+			checker.Sources = append(checker.Sources, func(ctx context.Context) (cluster.MemberDigests, error) {
+				return Digests(ctx, port)
+			})
+		}
+	}
+	c.mtx.Unlock()
+
+	res, err := checker.Wait(ctx)
+	if err != nil {
+		return res, err
+	}
+	if len(res.Unknown) > 0 {
+		unknown := strings.Join(res.Unknown, ", ")
+		logger.Warn("Convergence of some states is unknown", logKeyEvent, "converged", "states", unknown)
+		c.report.Event(sourceOrchestrator, "converged", "except for unknown states %s after %v and %d polls", unknown, res.Elapsed.Round(time.Millisecond), res.Polls)
+		return res, fmt.Errorf("%w: %s", ErrUnknownStates, unknown)
+	}
+	c.report.Event(sourceOrchestrator, "converged", "after %v and %d polls", res.Elapsed.Round(time.Millisecond), res.Polls)
+	return res, nil
+}
+
+// Cmds returns the commands of all running instances.
+func (c *Cluster) Cmds() []*exec.Cmd {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var cmds []*exec.Cmd
+	for _, m := range c.members {
+		if m.proc != nil {
+			cmds = append(cmds, m.proc.cmd)
+		}
+	}
+	return cmds
+}
+
+// Start starts all instances in order. Every instance joins the instances
+// started before it.
+func (c *Cluster) Start() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, m := range c.members {
+		if err := c.start(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) member(name string) (*member, error) {
+	for _, m := range c.members {
+		if m.inst.Name == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown instance %s", name)
+}
+
+// start must be called with c.mtx held.
+func (c *Cluster) start(m *member) error {
+	if m.proc != nil {
+		return fmt.Errorf("instance %s is already running", m.inst.Name)
+	}
+	binaryPath, err := c.build.Path(m.inst.Binary)
+	if err != nil {
+		return err
+	}
+	if c.configDir == "" {
+		if c.configDir, err = os.MkdirTemp("", "am-config-"); err != nil {
+			return err
+		}
+	}
+	config, err := RenderConfig(c.templatePath, c.params, m.inst)
+	if err != nil {
+		return err
+	}
+	configPath := filepath.Join(c.configDir, m.inst.Name+".yml")
+	if err := os.WriteFile(configPath, config, 0o600); err != nil {
+		return err
+	}
+	if err := c.report.addConfig(m.inst.Name, config); err != nil {
+		return err
+	}
+	raw, err := c.report.logWriter(m.inst.Name)
+	if err != nil {
+		return err
+	}
+	if m.storage == "" {
+		if m.storage, err = os.MkdirTemp("", fmt.Sprintf("am-storage-%s-", m.inst.Name)); err != nil {
+			return err
+		}
+	}
+
+	if c.timing.Latency > 0 && m.proxy == nil {
+		if m.proxy, err = startLatencyProxy(c.timing.gossipPort(m.inst), m.inst.ClusterPort, c.timing.Latency); err != nil {
+			return fmt.Errorf("start latency proxy: %w", err)
+		}
+	}
+
+	var peers []string
+	for _, other := range c.members {
+		if other != m && other.proc != nil {
+			peers = append(peers, fmt.Sprintf("127.0.0.1:%d", c.timing.gossipPort(other.inst)))
+		}
+	}
+	c.report.Event(m.inst.Name, "start", "binary %s, peers %v", m.inst.Binary, peers)
+	m.proc, err = startAlertmanager(m.inst, binaryPath, configPath, m.storage, c.logLevel, c.timing, peers, raw)
+	return err
+}
+
+// Stop gracefully stops an instance.
+func (c *Cluster) Stop(name string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	m, err := c.member(name)
+	if err != nil {
+		return err
+	}
+	return c.stop(m)
+}
+
+// stop must be called with c.mtx held.
+func (c *Cluster) stop(m *member) error {
+	if m.proc == nil {
+		return nil
+	}
+	logger.Info("Stopping Alertmanager", logKeyInstance, m.inst.Name, logKeyEvent, "stop")
+	c.report.Event(m.inst.Name, "stop", "")
+	err := m.proc.stop(stopTimeout)
+	m.proc = nil
+	return errors.Join(err, c.report.addSnapshots(m.inst.Name, m.storage))
+}
+
+// Restart stops an instance and starts it again on the given binary. An
+// empty binary keeps the current one.
+func (c *Cluster) Restart(name, binary string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	m, err := c.member(name)
+	if err != nil {
+		return err
+	}
+	if err := c.stop(m); err != nil {
+		return err
+	}
+	if binary != "" {
+		logger.Info("Switching binary", logKeyInstance, name, logKeyEvent, "switch-binary", "from", m.inst.Binary, "to", binary)
+		c.report.Event(name, "switch-binary", "%s -> %s", m.inst.Binary, binary)
+		m.inst.Binary = binary
+	}
+	return c.start(m)
+}
+
+// RollingUpgrade restarts the instances one by one onto binary. After each
+// restart it waits for interval, so that the restarted instance can rejoin
+// and catch up on gossip before the next one goes down. If after is not nil,
+// it is called once the interval has passed, while the cluster still runs
+// mixed versions.
+func (c *Cluster) RollingUpgrade(ctx context.Context, binary string, interval time.Duration, after func(Instance) error) error {
+	for _, inst := range c.Instances() {
+		if inst.Binary == binary {
+			continue
+		}
+		if err := c.Restart(inst.Name, binary); err != nil {
+			return fmt.Errorf("upgrade %s: %w", inst.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if after == nil {
+			continue
+		}
+		inst.Binary = binary
+		if err := after(inst); err != nil {
+			return fmt.Errorf("after upgrading %s: %w", inst.Name, err)
+		}
+	}
+	return nil
+}
+
+// Shutdown stops all instances and then the receiver.
+func (c *Cluster) Shutdown() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var errs []error
+	for _, m := range slices.Backward(c.members) {
+		if err := c.stop(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, m := range c.members {
+		if m.proxy != nil {
+			errs = append(errs, m.proxy.close())
+			m.proxy = nil
+		}
+	}
+	if c.receiver != nil {
+		c.report.Event("receiver", "stop", "")
+		errs = append(errs, c.receiver.stop(5*time.Second))
+		c.receiver = nil
+	}
+	return errors.Join(errs...)
+}
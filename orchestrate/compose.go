@@ -0,0 +1,381 @@
+package orchestrate
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Ports the images in assets/ listen on inside their containers.
+const (
+	containerWebPort      = 9093
+	containerClusterPort  = 9094
+	containerReceiverPort = 9080
+)
+
+type serviceKind int
+
+const (
+	kindAlertmanager serviceKind = iota
+	kindReceiver
+	kindPrometheus
+)
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+	Configs  map[string]struct {
+		File string `yaml:"file"`
+	} `yaml:"configs"`
+}
+
+type composeService struct {
+	Image string `yaml:"image"`
+	Build struct {
+		Context    string `yaml:"context"`
+		Dockerfile string `yaml:"dockerfile"`
+	} `yaml:"build"`
+	Ports []struct {
+		Target    int `yaml:"target"`
+		Published int `yaml:"published"`
+	} `yaml:"ports"`
+	Configs []struct {
+		Source string `yaml:"source"`
+		Target string `yaml:"target"`
+	} `yaml:"configs"`
+	Command []string `yaml:"command"`
+}
+
+func (s composeService) kind() (serviceKind, error) {
+	switch {
+	case s.Build.Dockerfile == "Dockerfile.alertmanager" || strings.Contains(s.Image, "alertmanager"):
+		return kindAlertmanager, nil
+	case s.Build.Dockerfile == "Dockerfile.alert-receiver":
+		return kindReceiver, nil
+	case strings.Contains(s.Image, "prometheus"):
+		return kindPrometheus, nil
+	default:
+		return 0, fmt.Errorf("unsupported service (image %q, dockerfile %q)", s.Image, s.Build.Dockerfile)
+	}
+}
+
+func (s composeService) published(target int) int {
+	for _, p := range s.Ports {
+		if p.Target == target && p.Published != 0 {
+			return p.Published
+		}
+	}
+	return 0
+}
+
+// Compose is a docker compose project translated into local processes. Every
+// service address "<service>:<container port>" is mapped to a port on
+// 127.0.0.1.
+type Compose struct {
+	dir      string
+	file     composeFile
+	names    []string
+	ports    map[string]int
+	rewrites *strings.Replacer
+}
+
+// LoadCompose reads a compose file and assigns local ports to all services.
+// Published ports are kept, all other ports are picked by the kernel.
+func LoadCompose(path string) (*Compose, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Compose{
+		dir:   filepath.Dir(path),
+		ports: map[string]int{},
+	}
+	if err := yaml.Unmarshal(b, &c.file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for name := range c.file.Services {
+		c.names = append(c.names, name)
+	}
+	slices.Sort(c.names)
+
+	var pairs []string
+	for _, name := range c.names {
+		svc := c.file.Services[name]
+		kind, err := svc.kind()
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		var targets []int
+		switch kind {
+		case kindAlertmanager:
+			targets = []int{containerWebPort, containerClusterPort}
+		case kindReceiver:
+			// The receiver always listens on 9080.
+			c.ports[serviceAddr(name, containerReceiverPort)] = containerReceiverPort
+		}
+		for _, target := range targets {
+			port := svc.published(target)
+			if port == 0 {
+				if port, err = freePort(); err != nil {
+					return nil, err
+				}
+			}
+			c.ports[serviceAddr(name, target)] = port
+		}
+	}
+	if err := c.checkPorts(); err != nil {
+		return nil, err
+	}
+
+	addrs := slices.Sorted(maps.Keys(c.ports))
+	for _, addr := range addrs {
+		pairs = append(pairs, addr, fmt.Sprintf("127.0.0.1:%d", c.ports[addr]))
+	}
+	c.rewrites = strings.NewReplacer(pairs...)
+	return c, nil
+}
+
+func serviceAddr(service string, port int) string {
+	return net.JoinHostPort(service, strconv.Itoa(port))
+}
+
+func (c *Compose) checkPorts() error {
+	seen := map[int]string{}
+	for addr, port := range c.ports {
+		if other, ok := seen[port]; ok {
+			return fmt.Errorf("%s and %s both map to local port %d", addr, other, port)
+		}
+		seen[port] = addr
+	}
+	return nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Rewrite replaces all service addresses in s with their local equivalent.
+func (c *Compose) Rewrite(s string) string {
+	return c.rewrites.Replace(s)
+}
+
+// configFile returns the file on the host which is mounted at target inside
+// the container of service.
+func (c *Compose) configFile(service, target string) (string, error) {
+	for _, cfg := range c.file.Services[service].Configs {
+		if cfg.Target != target {
+			continue
+		}
+		src, ok := c.file.Configs[cfg.Source]
+		if !ok {
+			return "", fmt.Errorf("service %s: unknown config %s", service, cfg.Source)
+		}
+		return filepath.Join(c.dir, src.File), nil
+	}
+	return "", fmt.Errorf("service %s: nothing mounted at %s", service, target)
+}
+
+// rewriteFile writes a copy of src with all service addresses rewritten into
+// dir.
+func (c *Compose) rewriteFile(dir, name, src string) (string, error) {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, name)
+	return dst, os.WriteFile(dst, []byte(c.Rewrite(string(b))), 0o600)
+}
+
+// alertmanagerArgs translates the container command of an Alertmanager
+// service into local flags.
+func (c *Compose) alertmanagerArgs(dir, name string) ([]string, error) {
+	var args []string
+	for _, arg := range c.file.Services[name].Command {
+		flag, value, _ := strings.Cut(arg, "=")
+		switch flag {
+		case "--config.file":
+			src, err := c.configFile(name, value)
+			if err != nil {
+				return nil, err
+			}
+			dst, err := c.rewriteFile(dir, name+".yml", src)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--config.file="+dst)
+		case "--storage.path", "--web.listen-address", "--cluster.listen-address":
+			// Managed locally.
+		default:
+			args = append(args, c.Rewrite(arg))
+		}
+	}
+	storage, err := os.MkdirTemp(dir, "storage-"+name+"-")
+	if err != nil {
+		return nil, err
+	}
+	return append(args,
+		"--storage.path="+storage,
+		fmt.Sprintf("--web.listen-address=127.0.0.1:%d", c.ports[serviceAddr(name, containerWebPort)]),
+		fmt.Sprintf("--cluster.listen-address=127.0.0.1:%d", c.ports[serviceAddr(name, containerClusterPort)]),
+		"--cluster.peer-name="+name,
+	), nil
+}
+
+type promConfig struct {
+	Global struct {
+		EvaluationInterval model.Duration    `yaml:"evaluation_interval"`
+		ExternalLabels     map[string]string `yaml:"external_labels"`
+	} `yaml:"global"`
+	Alerting struct {
+		Alertmanagers []struct {
+			StaticConfigs []struct {
+				Targets []string `yaml:"targets"`
+			} `yaml:"static_configs"`
+		} `yaml:"alertmanagers"`
+	} `yaml:"alerting"`
+	RuleFiles []string `yaml:"rule_files"`
+}
+
+// prometheusOptions builds the Prometheus stand-in from the configuration of
+// a Prometheus service.
+func (c *Compose) prometheusOptions(name string) (PrometheusOptions, error) {
+	var configPath string
+	for _, arg := range c.file.Services[name].Command {
+		if flag, value, _ := strings.Cut(arg, "="); flag == "--config.file" {
+			configPath = value
+		}
+	}
+	if configPath == "" {
+		configPath = "/etc/prometheus/prometheus.yml"
+	}
+	src, err := c.configFile(name, configPath)
+	if err != nil {
+		return PrometheusOptions{}, err
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return PrometheusOptions{}, err
+	}
+	var cfg promConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return PrometheusOptions{}, fmt.Errorf("parse %s: %w", src, err)
+	}
+
+	o := PrometheusOptions{
+		EvaluationInterval: time.Duration(cfg.Global.EvaluationInterval),
+		ExternalLabels:     cfg.Global.ExternalLabels,
+	}
+	for _, am := range cfg.Alerting.Alertmanagers {
+		for _, sc := range am.StaticConfigs {
+			for _, target := range sc.Targets {
+				o.Targets = append(o.Targets, "http://"+c.Rewrite(target))
+			}
+		}
+	}
+	for _, rf := range cfg.RuleFiles {
+		path, err := c.configFile(name, rf)
+		if err != nil {
+			return PrometheusOptions{}, err
+		}
+		rules, err := LoadRules(path)
+		if err != nil {
+			return PrometheusOptions{}, err
+		}
+		o.Rules = append(o.Rules, rules...)
+	}
+	return o, nil
+}
+
+// Up starts all services of the compose project as local processes and runs
+// the Prometheus stand-ins until ctx is done. The processes are killed on
+// return. Alertmanager services run the default binary of b.
+func (c *Compose) Up(ctx context.Context, b *Build) error {
+	binaryPath, err := b.Path(DefaultBinary)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "am-compose-")
+	if err != nil {
+		return err
+	}
+	logger.Info("Writing rewritten configs", logKeyEvent, "compose", "dir", dir)
+
+	var (
+		procs []*process
+		proms []*Prometheus
+	)
+	defer func() {
+		for _, p := range procs {
+			_ = p.stop(10 * time.Second)
+		}
+	}()
+
+	for _, name := range c.names {
+		kind, _ := c.file.Services[name].kind()
+		var p *process
+		switch kind {
+		case kindAlertmanager:
+			args, err := c.alertmanagerArgs(dir, name)
+			if err != nil {
+				return err
+			}
+			logger.Info("Starting Alertmanager", logKeyInstance, name, logKeyEvent, "start", "port", c.ports[serviceAddr(name, containerWebPort)])
+			p, err = startProcess(name, nil, binaryPath, args...)
+			if err != nil {
+				return err
+			}
+		case kindReceiver:
+			logger.Info("Starting receiver", logKeyInstance, name, logKeyEvent, "start", "port", containerReceiverPort)
+			receiverPath, err := receiverBinary()
+			if err != nil {
+				return err
+			}
+			if p, err = startProcess(name, nil, receiverPath); err != nil {
+				return err
+			}
+		case kindPrometheus:
+			o, err := c.prometheusOptions(name)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", name, err)
+			}
+			p, err := NewPrometheus(o)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", name, err)
+			}
+			proms = append(proms, p)
+		}
+		if p != nil {
+			procs = append(procs, p)
+		}
+	}
+
+	// Give the cluster a moment to form before the first evaluation.
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(3 * time.Second):
+	}
+	var wg sync.WaitGroup
+	for _, p := range proms {
+		wg.Go(func() { p.Run(ctx) })
+	}
+	<-ctx.Done()
+	wg.Wait()
+	return nil
+}
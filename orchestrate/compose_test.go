@@ -0,0 +1,43 @@
+package orchestrate
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCompose(t *testing.T) {
+	c, err := LoadCompose("../assets/docker-compose.yaml")
+	require.NoError(t, err)
+
+	// Published ports are kept.
+	require.Equal(t, "127.0.0.1:9093", c.Rewrite("alertmanager_one:9093"))
+	require.Equal(t, "127.0.0.1:9094", c.Rewrite("alertmanager_two:9093"))
+	require.Equal(t, "http://127.0.0.1:9080/alerts", c.Rewrite("http://alert_receiver:9080/alerts"))
+	// The cluster ports aren't published and must not collide with them.
+	require.NotEqual(t, "127.0.0.1:9094", c.Rewrite("alertmanager_one:9094"))
+	require.NotEqual(t, c.Rewrite("alertmanager_one:9094"), c.Rewrite("alertmanager_two:9094"))
+
+	dir := t.TempDir()
+	args, err := c.alertmanagerArgs(dir, "alertmanager_one")
+	require.NoError(t, err)
+	require.Contains(t, args, "--cluster.peer="+c.Rewrite("alertmanager_two:9094"))
+	require.Contains(t, args, "--web.listen-address=127.0.0.1:9093")
+	require.Contains(t, args, "--cluster.peer-name=alertmanager_one")
+
+	i := slices.IndexFunc(args, func(s string) bool { return strings.HasPrefix(s, "--config.file=") })
+	require.GreaterOrEqual(t, i, 0)
+	b, err := os.ReadFile(strings.TrimPrefix(args[i], "--config.file="))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "http://127.0.0.1:9080/alerts")
+	require.NotContains(t, string(b), "alert_receiver")
+
+	o, err := c.prometheusOptions("prometheus")
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://127.0.0.1:9093", "http://127.0.0.1:9094"}, o.Targets)
+	require.Len(t, o.Rules, 1)
+	require.Equal(t, "AlwaysFiringTest", o.Rules[0].Name)
+}
@@ -0,0 +1,84 @@
+package orchestrate
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"text/template"
+
+	"github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/common/model"
+)
+
+// DefaultReceiverURL is the webhook endpoint of bin/alert-receiver.
+const DefaultReceiverURL = "http://localhost:9080/alerts"
+
+// ConfigParams are the variables available to the alertmanager.yml.tmpl
+// templates of the setups. Timing knobs which are zero are left to the
+// template, which usually provides its own default:
+//
+//	group_interval: {{ or .GroupInterval "2s" }}
+type ConfigParams struct {
+	ReceiverURL    string
+	GroupWait      model.Duration
+	GroupInterval  model.Duration
+	RepeatInterval model.Duration
+	// Vars holds free-form values for setup specific knobs, accessible as
+	// {{ .Vars.name }}.
+	Vars map[string]string
+
+	// The instance the configuration is rendered for. These are set by the
+	// orchestrator.
+	InstanceName string
+	WebPort      int
+	ClusterPort  int
+}
+
+// merge returns p with all non-zero values of o applied.
+func (p ConfigParams) merge(o ConfigParams) ConfigParams {
+	if o.ReceiverURL != "" {
+		p.ReceiverURL = o.ReceiverURL
+	}
+	if o.GroupWait != 0 {
+		p.GroupWait = o.GroupWait
+	}
+	if o.GroupInterval != 0 {
+		p.GroupInterval = o.GroupInterval
+	}
+	if o.RepeatInterval != 0 {
+		p.RepeatInterval = o.RepeatInterval
+	}
+	if len(o.Vars) > 0 {
+		vars := maps.Clone(p.Vars)
+		if vars == nil {
+			vars = map[string]string{}
+		}
+		maps.Copy(vars, o.Vars)
+		p.Vars = vars
+	}
+	return p
+}
+
+// RenderConfig executes the template at tmplPath for inst and validates the
+// result as an Alertmanager configuration. Per-instance overrides of inst
+// take precedence over p.
+func RenderConfig(tmplPath string, p ConfigParams, inst Instance) ([]byte, error) {
+	tmpl, err := template.New("").Option("missingkey=error").ParseFiles(tmplPath)
+	if err != nil {
+		return nil, err
+	}
+	p = p.merge(inst.Config)
+	if p.ReceiverURL == "" {
+		p.ReceiverURL = DefaultReceiverURL
+	}
+	p.InstanceName, p.WebPort, p.ClusterPort = inst.Name, inst.WebPort, inst.ClusterPort
+
+	var buf bytes.Buffer
+	if err := tmpl.Templates()[0].Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	if _, err := config.Load(buf.String()); err != nil {
+		return nil, fmt.Errorf("invalid config rendered from %s for %s: %w", tmplPath, inst.Name, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package orchestrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderSetupConfigs(t *testing.T) {
+	tmpls, err := filepath.Glob("../setups/*/alertmanager.yml.tmpl")
+	require.NoError(t, err)
+	require.NotEmpty(t, tmpls)
+
+	for _, tmpl := range tmpls {
+		b, err := RenderConfig(tmpl, ConfigParams{}, DefaultInstances[0])
+		require.NoError(t, err, tmpl)
+		cfg, err := config.Load(string(b))
+		require.NoError(t, err, tmpl)
+		require.Equal(t, DefaultReceiverURL, cfg.Receivers[0].WebhookConfigs[0].URL.String(), tmpl)
+	}
+}
+
+func TestRenderConfigOverrides(t *testing.T) {
+	tmpl := "../setups/load-profile/alertmanager.yml.tmpl"
+	p := ConfigParams{
+		ReceiverURL:   "http://127.0.0.1:9999/alerts",
+		GroupInterval: model.Duration(30 * time.Second),
+	}
+
+	b, err := RenderConfig(tmpl, p, DefaultInstances[0])
+	require.NoError(t, err)
+	cfg, err := config.Load(string(b))
+	require.NoError(t, err)
+	require.Equal(t, p.GroupInterval, *cfg.Route.GroupInterval)
+	// The template default applies to knobs which aren't set.
+	require.Equal(t, model.Duration(time.Second), *cfg.Route.GroupWait)
+	require.Equal(t, "http://127.0.0.1:9999/alerts", cfg.Receivers[0].WebhookConfigs[0].URL.String())
+
+	inst := DefaultInstances[1]
+	inst.Config = ConfigParams{GroupInterval: model.Duration(2 * time.Second)}
+	b, err = RenderConfig(tmpl, p, inst)
+	require.NoError(t, err)
+	cfg, err = config.Load(string(b))
+	require.NoError(t, err)
+	require.Equal(t, model.Duration(2*time.Second), *cfg.Route.GroupInterval)
+}
+
+func TestRenderConfigInvalid(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "alertmanager.yml.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`
+route:
+  receiver: missing
+  group_interval: {{ .GroupInterval }}
+receivers:
+- name: other
+`), 0o600))
+
+	_, err := RenderConfig(tmpl, ConfigParams{GroupInterval: model.Duration(time.Second)}, DefaultInstances[0])
+	require.ErrorContains(t, err, "invalid config")
+
+	// Unknown variables are rejected instead of rendering "<no value>".
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ .Vars.missing }}`), 0o600))
+	_, err = RenderConfig(tmpl, ConfigParams{Vars: map[string]string{}}, DefaultInstances[0])
+	require.Error(t, err)
+}
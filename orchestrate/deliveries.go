@@ -0,0 +1,112 @@
+package orchestrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DeliveredAlert is an alert of a webhook notification.
+type DeliveredAlert struct {
+	Status      string            `json:"status"`
+	Fingerprint string            `json:"fingerprint"`
+	Labels      map[string]string `json:"labels"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+func (a DeliveredAlert) id() string {
+	if a.Fingerprint != "" {
+		return a.Fingerprint
+	}
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s=%q,", name, a.Labels[name])
+	}
+	return sb.String()
+}
+
+// Delivery is a notification as recorded in the journal of the
+// alert-receiver.
+type Delivery struct {
+	Time        time.Time        `json:"time"`
+	GroupKey    string           `json:"groupKey"`
+	Status      string           `json:"status"`
+	Receiver    string           `json:"receiver"`
+	ExternalURL string           `json:"externalURL"`
+	Alerts      []DeliveredAlert `json:"alerts"`
+}
+
+// key identifies the content of a notification. Alertmanager doesn't send the
+// same content twice for a group within the repeat interval.
+func (d Delivery) key() string {
+	alerts := make([]string, 0, len(d.Alerts))
+	for _, a := range d.Alerts {
+		alerts = append(alerts, a.id()+"/"+a.Status)
+	}
+	slices.Sort(alerts)
+	return strings.Join(append([]string{d.Receiver, d.GroupKey, d.Status}, alerts...), "|")
+}
+
+// ReadDeliveries reads the journal written by alert-receiver --journal.file.
+func ReadDeliveries(path string) ([]Delivery, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ds []Delivery
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var d Delivery
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		ds = append(ds, d)
+	}
+	return ds, scanner.Err()
+}
+
+// Duplicate is a notification which was delivered again.
+type Duplicate struct {
+	First  Delivery
+	Repeat Delivery
+}
+
+func (d Duplicate) String() string {
+	return fmt.Sprintf("group %s (%s) delivered at %s by %s and again at %s by %s",
+		d.First.GroupKey, d.First.Status,
+		d.First.Time.Format(time.RFC3339Nano), d.First.ExternalURL,
+		d.Repeat.Time.Format(time.RFC3339Nano), d.Repeat.ExternalURL)
+}
+
+// FindDuplicates returns all deliveries whose content was already delivered
+// less than within before. Use the repeat interval of the route for within,
+// or zero to report every repeated delivery.
+func FindDuplicates(ds []Delivery, within time.Duration) []Duplicate {
+	ds = slices.Clone(ds)
+	slices.SortStableFunc(ds, func(a, b Delivery) int { return a.Time.Compare(b.Time) })
+
+	var dups []Duplicate
+	last := map[string]Delivery{}
+	for _, d := range ds {
+		k := d.key()
+		if prev, ok := last[k]; ok && (within == 0 || d.Time.Sub(prev.Time) < within) {
+			dups = append(dups, Duplicate{First: prev, Repeat: d})
+			continue
+		}
+		last[k] = d
+	}
+	return dups
+}
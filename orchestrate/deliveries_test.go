@@ -0,0 +1,50 @@
+package orchestrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	now := time.Now()
+	firing := func(ts time.Time, url string, fps ...string) Delivery {
+		d := Delivery{Time: ts, GroupKey: "{}:{alertname=\"A\"}", Status: "firing", Receiver: "r", ExternalURL: url}
+		for _, fp := range fps {
+			d.Alerts = append(d.Alerts, DeliveredAlert{Status: "firing", Fingerprint: fp})
+		}
+		return d
+	}
+
+	ds := []Delivery{
+		firing(now, "http://a", "1"),
+		// New content, not a duplicate.
+		firing(now.Add(time.Second), "http://a", "1", "2"),
+		// Same content from the other peer.
+		firing(now.Add(2*time.Second), "http://b", "2", "1"),
+		// Repeat after the repeat interval.
+		firing(now.Add(time.Hour), "http://a", "1", "2"),
+	}
+	dups := FindDuplicates(ds, time.Minute)
+	require.Len(t, dups, 1)
+	require.Equal(t, "http://a", dups[0].First.ExternalURL)
+	require.Equal(t, "http://b", dups[0].Repeat.ExternalURL)
+
+	require.Len(t, FindDuplicates(ds, 0), 2)
+}
+
+func TestReadDeliveries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"time":"2026-01-02T03:04:05Z","groupKey":"g","status":"firing","receiver":"r","externalURL":"http://a","alerts":[{"status":"firing","fingerprint":"f","labels":{"alertname":"A"}}]}`+"\n",
+	), 0o600))
+
+	ds, err := ReadDeliveries(path)
+	require.NoError(t, err)
+	require.Len(t, ds, 1)
+	require.Equal(t, "g", ds[0].GroupKey)
+	require.Equal(t, "A", ds[0].Alerts[0].Labels["alertname"])
+}
@@ -37,11 +37,11 @@ func killProcByPort(port int) error {
 
 	// Kill each unique PID found
 	for pid := range pids {
-		fmt.Printf("-> Found PID %s. Sending SIGTERM...\n", pid)
+		logger.Info("Sending SIGTERM", logKeyEvent, "kill", "port", port, "pid", pid)
 
 		killCmd := exec.Command("kill", "-TERM", pid)
 		if err := killCmd.Run(); err != nil {
-			fmt.Printf("Warning: Failed to kill PID %s: %v\n", pid, err)
+			logger.Warn("Failed to kill process", logKeyEvent, "kill", "port", port, "pid", pid, "err", err)
 		}
 	}
 
@@ -51,8 +51,8 @@ func killProcByPort(port int) error {
 func KillProcByPort(port int) {
 	err := killProcByPort(port)
 	if err != nil {
-		fmt.Printf("❌ Failed to kill process: %v\n", err)
+		logger.Error("Failed to kill process", logKeyEvent, "kill", "port", port, "err", err)
 		os.Exit(1)
 	}
-	fmt.Println("🚀 SIGTERM sent successfully to instance on port 9093.")
+	logger.Info("SIGTERM sent", logKeyEvent, "kill", "port", port)
 }
@@ -0,0 +1,207 @@
+package orchestrate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// latencyPortOffset is added to the cluster port of an instance to get the
+// port of its latency proxy.
+const latencyPortOffset = 10000
+
+// latencyProxy delays the gossip to an instance. It forwards the UDP packets
+// and TCP streams sent to its port to the cluster port of the instance, and
+// the answers back, each after delay. Instances advertise the port of their
+// proxy, so all gossip passes the proxy of its receiver.
+type latencyProxy struct {
+	delay  time.Duration
+	target *net.UDPAddr
+	udp    *net.UDPConn
+	tcp    net.Listener
+	wg     sync.WaitGroup
+
+	mtx       sync.Mutex
+	closed    bool
+	upstreams map[string]*net.UDPConn
+	conns     map[net.Conn]struct{}
+}
+
+func startLatencyProxy(port, targetPort int, delay time.Duration) (*latencyProxy, error) {
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	p := &latencyProxy{
+		delay:     delay,
+		target:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: targetPort},
+		udp:       udp,
+		tcp:       tcp,
+		upstreams: map[string]*net.UDPConn{},
+		conns:     map[net.Conn]struct{}{},
+	}
+	p.wg.Go(p.servePackets)
+	p.wg.Go(p.serveStreams)
+	return p, nil
+}
+
+func (p *latencyProxy) servePackets() {
+	buf := make([]byte, 65536)
+	for {
+		n, src, err := p.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		up, err := p.upstream(src)
+		if err != nil {
+			logger.Warn("Failed to forward packet", logKeyEvent, "latency", "target", p.target, "err", err)
+			continue
+		}
+		b := append([]byte(nil), buf[:n]...)
+		time.AfterFunc(p.delay, func() { _, _ = up.Write(b) })
+	}
+}
+
+// upstream returns the connection forwarding the packets of src. Answers to
+// it are sent back to src.
+func (p *latencyProxy) upstream(src *net.UDPAddr) (*net.UDPConn, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.closed {
+		return nil, net.ErrClosed
+	}
+	if up, ok := p.upstreams[src.String()]; ok {
+		return up, nil
+	}
+	up, err := net.DialUDP("udp", nil, p.target)
+	if err != nil {
+		return nil, err
+	}
+	p.upstreams[src.String()] = up
+	p.wg.Go(func() {
+		buf := make([]byte, 65536)
+		for {
+			n, err := up.Read(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				// Nothing listens on the target while it restarts.
+				continue
+			}
+			b := append([]byte(nil), buf[:n]...)
+			time.AfterFunc(p.delay, func() { _, _ = p.udp.WriteToUDP(b, src) })
+		}
+	})
+	return up, nil
+}
+
+func (p *latencyProxy) serveStreams() {
+	for {
+		conn, err := p.tcp.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Go(func() { p.forwardStream(conn) })
+	}
+}
+
+func (p *latencyProxy) forwardStream(conn net.Conn) {
+	defer conn.Close()
+	up, err := net.Dial("tcp", p.target.String())
+	if err != nil {
+		return
+	}
+	defer up.Close()
+	if !p.track(conn, up) {
+		return
+	}
+	defer p.untrack(conn, up)
+
+	var wg sync.WaitGroup
+	wg.Go(func() { delayCopy(up, conn, p.delay) })
+	wg.Go(func() { delayCopy(conn, up, p.delay) })
+	wg.Wait()
+}
+
+// track registers open streams, so that close ends them. It reports false if
+// the proxy is closed already.
+func (p *latencyProxy) track(conns ...net.Conn) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.closed {
+		return false
+	}
+	for _, c := range conns {
+		p.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (p *latencyProxy) untrack(conns ...net.Conn) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, c := range conns {
+		delete(p.conns, c)
+	}
+}
+
+// delayCopy copies src to dst, writing everything delay after it was read.
+// The write side of dst is closed once src is.
+func delayCopy(dst, src net.Conn, delay time.Duration) {
+	type chunk struct {
+		at time.Time
+		b  []byte
+	}
+	chunks := make(chan chunk, 64)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				chunks <- chunk{at: time.Now().Add(delay), b: append([]byte(nil), buf[:n]...)}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for c := range chunks {
+		time.Sleep(time.Until(c.at))
+		if _, err := dst.Write(c.b); err != nil {
+			// Unblock the reader, its chunks can't be written anymore.
+			src.Close()
+			for range chunks {
+			}
+			return
+		}
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+}
+
+// close stops forwarding and waits until all forwarders returned.
+func (p *latencyProxy) close() error {
+	p.mtx.Lock()
+	p.closed = true
+	errs := []error{p.udp.Close(), p.tcp.Close()}
+	for _, up := range p.upstreams {
+		errs = append(errs, up.Close())
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mtx.Unlock()
+	p.wg.Wait()
+	return errors.Join(errs...)
+}
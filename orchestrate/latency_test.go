@@ -0,0 +1,73 @@
+package orchestrate
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyProxy(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer udp.Close()
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	require.NoError(t, err)
+	defer tcp.Close()
+	// The target echoes packets and streams.
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, src, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteToUDP(buf[:n], src)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	p, err := startLatencyProxy(0, port, delay)
+	require.NoError(t, err)
+	defer p.close()
+
+	start := time.Now()
+	pc, err := net.DialUDP("udp", nil, p.udp.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer pc.Close()
+	_, err = pc.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := pc.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf[:n]))
+	require.GreaterOrEqual(t, time.Since(start), 2*delay)
+
+	start = time.Now()
+	conn, err := net.Dial("tcp", p.tcp.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("push/pull"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "push/pull", string(b))
+	require.GreaterOrEqual(t, time.Since(start), 2*delay)
+}
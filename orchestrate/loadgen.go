@@ -0,0 +1,255 @@
+package orchestrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LoadProfile describes the shape of the alert traffic produced by a
+// Generator.
+type LoadProfile struct {
+	// Alerts is the number of distinct alerts in the working set.
+	Alerts int
+	// Labels maps a label name to the number of distinct values it takes,
+	// e.g. {"instance": 200, "job": 5}. The product of all cardinalities must
+	// be at least Alerts, so that every alert has a unique label set.
+	Labels map[string]int
+	// AlertName is the alertname label of all generated alerts, unless
+	// Labels contains "alertname". Defaults to "LoadTest".
+	AlertName string
+
+	// Rate is the number of alerts posted per second.
+	Rate float64
+	// BatchSize is the maximum number of alerts per POST request.
+	BatchSize int
+
+	// Stagger spreads the first StartsAt of the alerts uniformly over this
+	// window. Alerts don't exist before their StartsAt.
+	Stagger time.Duration
+	// FlapRatio is the fraction of alerts which fire and resolve in cycles.
+	FlapRatio float64
+	// FlapInterval is the length of a single fire/resolve cycle. A flapping
+	// alert fires for the first half of the cycle and is resolved for the
+	// second half.
+	FlapInterval time.Duration
+	// ResolveTimeout is added to the send time to compute EndsAt of firing
+	// alerts, similar to how Prometheus extends EndsAt on every resend.
+	ResolveTimeout time.Duration
+
+	// PeerSkew is the maximum delay between sending a batch to the first and
+	// the last peer. Each peer gets a random delay in [0, PeerSkew).
+	PeerSkew time.Duration
+
+	// Seed makes the generated label sets and delays reproducible.
+	Seed int64
+}
+
+func (p *LoadProfile) validate() error {
+	if p.Alerts <= 0 {
+		return errors.New("alerts must be positive")
+	}
+	if p.Rate <= 0 {
+		return errors.New("rate must be positive")
+	}
+	if p.batchInterval() < time.Millisecond {
+		return fmt.Errorf("rate %v is too high for batches of %d alerts, they would be sent less than 1ms apart", p.Rate, p.BatchSize)
+	}
+	if p.FlapRatio < 0 || p.FlapRatio > 1 {
+		return fmt.Errorf("flap ratio %v not in [0, 1]", p.FlapRatio)
+	}
+	if p.FlapRatio > 0 && p.FlapInterval <= 0 {
+		return errors.New("flapping requires a positive flap interval")
+	}
+	for name, c := range p.Labels {
+		if c <= 0 {
+			return fmt.Errorf("cardinality of label %q must be positive", name)
+		}
+	}
+	// The product is capped at Alerts, so it can't overflow.
+	combinations := 1
+	for _, c := range p.Labels {
+		if c > p.Alerts/combinations {
+			combinations = p.Alerts
+		} else {
+			combinations *= c
+		}
+	}
+	if combinations < p.Alerts {
+		return fmt.Errorf("label cardinality allows %d distinct alerts, need %d", combinations, p.Alerts)
+	}
+	return nil
+}
+
+// batchInterval is the time between two batches.
+func (p *LoadProfile) batchInterval() time.Duration {
+	return time.Duration(float64(p.BatchSize) / p.Rate * float64(time.Second))
+}
+
+type genAlert struct {
+	labels map[string]string
+	// offset from the generator start at which the alert fires first.
+	offset time.Duration
+	flaps  bool
+}
+
+// Generator produces alerts according to a LoadProfile.
+type Generator struct {
+	profile LoadProfile
+	alerts  []genAlert
+	start   time.Time
+	next    int
+
+	mtx sync.Mutex
+	rng *rand.Rand
+}
+
+// NewGenerator creates the working set of alerts for the profile. All
+// StartsAt values are relative to start.
+func NewGenerator(p LoadProfile, start time.Time) (*Generator, error) {
+	if p.AlertName == "" {
+		p.AlertName = "LoadTest"
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = 64
+	}
+	if p.ResolveTimeout <= 0 {
+		p.ResolveTimeout = 5 * time.Minute
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid load profile: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(p.Seed))
+	names := make([]string, 0, len(p.Labels))
+	for name := range p.Labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	alerts := make([]genAlert, p.Alerts)
+	for i := range alerts {
+		labels := map[string]string{"alertname": p.AlertName}
+		// Mixed radix over the label cardinalities guarantees distinct label sets.
+		rest := i
+		for _, name := range names {
+			c := p.Labels[name]
+			labels[name] = fmt.Sprintf("%s-%d", name, rest%c)
+			rest /= c
+		}
+		var offset time.Duration
+		if p.Stagger > 0 {
+			offset = time.Duration(rng.Int63n(int64(p.Stagger)))
+		}
+		alerts[i] = genAlert{
+			labels: labels,
+			offset: offset,
+			flaps:  rng.Float64() < p.FlapRatio,
+		}
+	}
+	// Shuffle so that consecutive batches don't only differ in the last label.
+	rng.Shuffle(len(alerts), func(i, j int) { alerts[i], alerts[j] = alerts[j], alerts[i] })
+
+	return &Generator{
+		profile: p,
+		alerts:  alerts,
+		start:   start,
+		rng:     rng,
+	}, nil
+}
+
+// alertAt returns the alert as it would be sent at time now. It returns false
+// if the alert doesn't exist yet.
+func (g *Generator) alertAt(a genAlert, now time.Time) (Alert, bool) {
+	first := g.start.Add(a.offset)
+	if now.Before(first) {
+		return Alert{}, false
+	}
+	alert := Alert{
+		Labels:      a.labels,
+		Annotations: map[string]string{"summary": "Generated by the load generator."},
+		StartsAt:    first,
+		EndsAt:      now.Add(g.profile.ResolveTimeout),
+	}
+	if !a.flaps {
+		return alert, true
+	}
+
+	interval := g.profile.FlapInterval
+	cycles := now.Sub(first) / interval
+	cycleStart := first.Add(cycles * interval)
+	alert.StartsAt = cycleStart
+	if resolvedAt := cycleStart.Add(interval / 2); !now.Before(resolvedAt) {
+		alert.EndsAt = resolvedAt
+	}
+	return alert, true
+}
+
+// Batch returns the next batch of alerts with their state at time now. The
+// working set is traversed round-robin, alerts which haven't started yet are
+// skipped. A batch never contains the same alert twice.
+func (g *Generator) Batch(now time.Time) []Alert {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	batch := make([]Alert, 0, g.profile.BatchSize)
+	for range min(g.profile.BatchSize, len(g.alerts)) {
+		a := g.alerts[g.next]
+		g.next = (g.next + 1) % len(g.alerts)
+		if alert, ok := g.alertAt(a, now); ok {
+			batch = append(batch, alert)
+		}
+	}
+	return batch
+}
+
+func (g *Generator) skew() time.Duration {
+	if g.profile.PeerSkew <= 0 {
+		return 0
+	}
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return time.Duration(g.rng.Int63n(int64(g.profile.PeerSkew)))
+}
+
+// Run posts batches to the Alertmanagers listening on ports until ctx is
+// done. Every batch is sent to all peers, each with its own random delay.
+func (g *Generator) Run(ctx context.Context, ports []int) error {
+	interval := g.profile.batchInterval()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	logger.Info("Sending alerts", logKeyInstance, sourceLoadGen, logKeyEvent, "start", "batch_size", g.profile.BatchSize, "interval", interval, "ports", ports)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-tick.C:
+			batch := g.Batch(now)
+			if len(batch) == 0 {
+				continue
+			}
+			for _, port := range ports {
+				wg.Add(1)
+				go func(port int, delay time.Duration) {
+					defer wg.Done()
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(delay):
+					}
+					if err := postAlerts(batch, port); err != nil {
+						logger.Error("Failed to send alerts", logKeyInstance, sourceLoadGen, logKeyEvent, "send-alerts", "alerts", len(batch), "port", port, "err", err)
+					}
+				}(port, g.skew())
+			}
+		}
+	}
+}
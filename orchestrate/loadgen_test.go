@@ -0,0 +1,104 @@
+package orchestrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratorUniqueLabelSets(t *testing.T) {
+	start := time.Now()
+	g, err := NewGenerator(LoadProfile{
+		Alerts:    50,
+		Labels:    map[string]int{"job": 5, "instance": 10},
+		Rate:      1,
+		BatchSize: 50,
+	}, start)
+	require.NoError(t, err)
+
+	seen := map[string]struct{}{}
+	for _, a := range g.Batch(start) {
+		key := a.Labels["job"] + "/" + a.Labels["instance"]
+		_, dup := seen[key]
+		require.False(t, dup, "duplicate label set %s", key)
+		seen[key] = struct{}{}
+		require.Equal(t, "LoadTest", a.Labels["alertname"])
+	}
+	require.Len(t, seen, 50)
+}
+
+func TestGeneratorCardinalityTooLow(t *testing.T) {
+	_, err := NewGenerator(LoadProfile{
+		Alerts: 51,
+		Labels: map[string]int{"job": 5, "instance": 10},
+		Rate:   1,
+	}, time.Now())
+	require.Error(t, err)
+
+	// Other labels with enough values don't hide an invalid one.
+	for range 20 {
+		_, err = NewGenerator(LoadProfile{
+			Alerts: 10,
+			Labels: map[string]int{"a": 1000, "b": 0},
+			Rate:   1,
+		}, time.Now())
+		require.EqualError(t, err, `invalid load profile: cardinality of label "b" must be positive`)
+	}
+}
+
+func TestGeneratorRateTooHigh(t *testing.T) {
+	_, err := NewGenerator(LoadProfile{Alerts: 10, BatchSize: 1, Rate: 1e4}, time.Now())
+	require.EqualError(t, err, "invalid load profile: rate 10000 is too high for batches of 1 alerts, they would be sent less than 1ms apart")
+
+	_, err = NewGenerator(LoadProfile{Alerts: 10, Labels: map[string]int{"job": 10}, BatchSize: 10, Rate: 1e4}, time.Now())
+	require.NoError(t, err)
+}
+
+func TestGeneratorFlapping(t *testing.T) {
+	start := time.Now()
+	g, err := NewGenerator(LoadProfile{
+		Alerts:         1,
+		Rate:           1,
+		FlapRatio:      1,
+		FlapInterval:   10 * time.Second,
+		ResolveTimeout: time.Minute,
+	}, start)
+	require.NoError(t, err)
+
+	// First half of the cycle: firing.
+	a := g.Batch(start.Add(2 * time.Second))
+	require.Len(t, a, 1)
+	require.Equal(t, start, a[0].StartsAt)
+	require.Equal(t, start.Add(time.Minute+2*time.Second), a[0].EndsAt)
+
+	// Second half of the cycle: resolved.
+	a = g.Batch(start.Add(7 * time.Second))
+	require.Equal(t, start.Add(5*time.Second), a[0].EndsAt)
+
+	// Next cycle fires again with a new StartsAt.
+	a = g.Batch(start.Add(12 * time.Second))
+	require.Equal(t, start.Add(10*time.Second), a[0].StartsAt)
+	require.True(t, a[0].EndsAt.After(start.Add(12*time.Second)))
+}
+
+func TestGeneratorStagger(t *testing.T) {
+	start := time.Now()
+	g, err := NewGenerator(LoadProfile{
+		Alerts:    100,
+		Labels:    map[string]int{"instance": 100},
+		Rate:      1,
+		BatchSize: 100,
+		Stagger:   time.Minute,
+		Seed:      1,
+	}, start)
+	require.NoError(t, err)
+
+	early := g.Batch(start.Add(30 * time.Second))
+	require.NotEmpty(t, early)
+	require.Less(t, len(early), 100)
+	for _, a := range early {
+		require.False(t, a.StartsAt.After(start.Add(30*time.Second)))
+	}
+	require.Len(t, g.Batch(start.Add(time.Minute)), 100)
+}
@@ -0,0 +1,194 @@
+package orchestrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Log formats of NewLogger.
+const (
+	// LogFormatColor prefixes every line with the instance it concerns in
+	// its own colour. It is meant for terminals only.
+	LogFormatColor = "color"
+	LogFormatText  = "text"
+	LogFormatJSON  = "json"
+)
+
+// Fields used consistently across the orchestrator and the alert-receiver.
+const (
+	logKeyInstance = "instance"
+	logKeyEvent    = "event"
+	logKeyGroupKey = "group_key"
+	logKeyReceiver = "receiver"
+	logKeyStatus   = "status"
+)
+
+// Instance values of records which don't concern an Alertmanager instance.
+const (
+	sourceOrchestrator = "orchestrator"
+	sourceReceiver     = "receiver"
+	sourcePrometheus   = "prometheus"
+	sourceLoadGen      = "loadgen"
+)
+
+// eventOutput marks a line of output of a child process. The message is the
+// line itself.
+const eventOutput = "output"
+
+// ANSI Colors for terminal output
+var colors = []string{
+	"\033[36m", // Cyan
+	"\033[32m", // Green
+	"\033[33m", // Yellow
+	"\033[35m", // Magenta
+	"\033[31m", // Red
+	"\033[34m", // Blue
+}
+
+const colorReset = "\033[0m"
+
+// logger is used for all output of the orchestrator, including the output of
+// the processes it starts. The format is taken from $AM_LOG_FORMAT.
+var logger = mustLogger(os.Stdout, os.Getenv("AM_LOG_FORMAT"))
+
+func mustLogger(w io.Writer, format string) *slog.Logger {
+	l, err := NewLogger(w, format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v, falling back to %s\n", err, LogFormatColor)
+		l, _ = NewLogger(w, LogFormatColor)
+	}
+	return l
+}
+
+// NewLogger returns a logger writing to w in the given format. An empty format
+// is LogFormatColor.
+func NewLogger(w io.Writer, format string) (*slog.Logger, error) {
+	switch format {
+	case "", LogFormatColor:
+		return slog.New(newColorHandler(w)), nil
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, nil)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// SetLogger replaces the logger of the package.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+// colorHandler renders records as "[instance] message key=value ...". Every
+// instance gets its own colour in the order the instances first appear.
+type colorHandler struct {
+	shared *colorState
+	attrs  []slog.Attr
+	group  string
+}
+
+type colorState struct {
+	mtx    sync.Mutex
+	w      io.Writer
+	colors map[string]string
+	next   int
+}
+
+func newColorHandler(w io.Writer) *colorHandler {
+	return &colorHandler{shared: &colorState{
+		w: w,
+		colors: map[string]string{
+			sourceOrchestrator: colors[5],
+			sourceLoadGen:      colors[5],
+			sourceReceiver:     colors[4],
+			sourcePrometheus:   colors[3],
+		},
+	}}
+}
+
+func (s *colorState) color(instance string) string {
+	if c, ok := s.colors[instance]; ok {
+		return c
+	}
+	// Instances use the colours which aren't reserved above.
+	c := colors[s.next%3]
+	s.next++
+	s.colors[instance] = c
+	return c
+}
+
+func (h *colorHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= slog.LevelInfo
+}
+
+func (h *colorHandler) Handle(_ context.Context, r slog.Record) error {
+	instance, event := sourceOrchestrator, ""
+	var sb strings.Builder
+	add := func(a slog.Attr) {
+		switch a.Key {
+		case logKeyInstance:
+			instance = a.Value.String()
+		case logKeyEvent:
+			event = a.Value.String()
+		default:
+			v := a.Value.String()
+			if v == "" || strings.ContainsAny(v, " =\"") {
+				v = fmt.Sprintf("%q", v)
+			}
+			fmt.Fprintf(&sb, " %s=%s", a.Key, v)
+		}
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		add(h.grouped(a))
+		return true
+	})
+
+	h.shared.mtx.Lock()
+	defer h.shared.mtx.Unlock()
+
+	line := fmt.Sprintf("%s[%s]%s ", h.shared.color(instance), instance, colorReset)
+	if event == eventOutput {
+		// The output of child processes is passed through untouched.
+		line += r.Message
+	} else {
+		if r.Level >= slog.LevelWarn {
+			line += "!!! "
+		}
+		line += r.Message + sb.String()
+	}
+	_, err := fmt.Fprintln(h.shared.w, line)
+	return err
+}
+
+// grouped qualifies the key of a with the current group.
+func (h *colorHandler) grouped(a slog.Attr) slog.Attr {
+	if h.group != "" {
+		a.Key = h.group + "." + a.Key
+	}
+	return a
+}
+
+func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	all := slices.Clip(h.attrs)
+	for _, a := range attrs {
+		all = append(all, h.grouped(a))
+	}
+	return &colorHandler{shared: h.shared, attrs: all, group: h.group}
+}
+
+func (h *colorHandler) WithGroup(name string) slog.Handler {
+	if h.group != "" {
+		name = h.group + "." + name
+	}
+	return &colorHandler{shared: h.shared, attrs: h.attrs, group: name}
+}
@@ -0,0 +1,47 @@
+package orchestrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestColorHandler(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := NewLogger(&buf, LogFormatColor)
+	require.NoError(t, err)
+
+	l.Info("Starting Alertmanager", logKeyInstance, "01-zebra", logKeyEvent, "start", "port", 9093)
+	l.Info(`time=2026-01-01T00:00:00Z level=INFO msg="Listening"`, logKeyInstance, "01-zebra", logKeyEvent, eventOutput)
+	l.Info("Starting Alertmanager", logKeyInstance, "02-lion", logKeyEvent, "start")
+	l.With(logKeyInstance, sourceReceiver).WithGroup("http").Error("Request failed", "status", 500)
+	l.Info("Sweep done")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, []string{
+		colors[0] + "[01-zebra]" + colorReset + " Starting Alertmanager port=9093",
+		// Process output is passed through as is.
+		colors[0] + "[01-zebra]" + colorReset + ` time=2026-01-01T00:00:00Z level=INFO msg="Listening"`,
+		colors[1] + "[02-lion]" + colorReset + " Starting Alertmanager",
+		colors[4] + "[receiver]" + colorReset + " !!! Request failed http.status=500",
+		colors[5] + "[orchestrator]" + colorReset + " Sweep done",
+	}, lines)
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := NewLogger(&buf, LogFormatJSON)
+	require.NoError(t, err)
+	l.Info("Stopping Alertmanager", logKeyInstance, "01-zebra", logKeyEvent, "stop")
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	require.Equal(t, "01-zebra", rec[logKeyInstance])
+	require.Equal(t, "stop", rec[logKeyEvent])
+
+	_, err = NewLogger(&buf, "xml")
+	require.Error(t, err)
+}
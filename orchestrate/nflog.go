@@ -0,0 +1,72 @@
+package orchestrate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
+)
+
+// ReadNflogSnapshot returns the entries of the nflog snapshot at path by the
+// group key and receiver they are about. An empty snapshot has no entries.
+func ReadNflogSnapshot(path string) (map[string]*pb.Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := map[string]*pb.Entry{}
+	for {
+		var e pb.MeshEntry
+		_, err := pbutil.ReadDelimited(f, &e)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read nflog snapshot %s: %w", path, err)
+		}
+		if e.Entry == nil || e.Entry.Receiver == nil {
+			return nil, fmt.Errorf("read nflog snapshot %s: entry without receiver", path)
+		}
+		r := e.Entry.Receiver
+		entries[fmt.Sprintf("%s %s/%s/%d", e.Entry.GroupKey, r.GroupName, r.Integration, r.Idx)] = e.Entry
+	}
+}
+
+// CompareNflogSnapshots checks that the nflog snapshots of all instances
+// know the same notifications, i.e. that the nflog was gossiped between all
+// of them. It fails if no instance knows any notification.
+func CompareNflogSnapshots(snapshots map[string]map[string]*pb.Entry) error {
+	all := map[string]bool{}
+	for _, entries := range snapshots {
+		for key := range entries {
+			all[key] = true
+		}
+	}
+	if len(all) == 0 {
+		return errors.New("no notifications in any nflog snapshot")
+	}
+	var missing []string
+	for instance, entries := range snapshots {
+		n := 0
+		for key := range all {
+			if _, ok := entries[key]; !ok {
+				n++
+			}
+		}
+		if n > 0 {
+			missing = append(missing, fmt.Sprintf("%s lacks %d of %d notifications", instance, n, len(all)))
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return errors.New(strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package orchestrate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+	pb "github.com/prometheus/alertmanager/nflog/nflogpb"
+	"github.com/stretchr/testify/require"
+)
+
+func writeNflogSnapshot(t *testing.T, path string, groupKeys ...string) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, gk := range groupKeys {
+		_, err := pbutil.WriteDelimited(&buf, &pb.MeshEntry{Entry: &pb.Entry{
+			GroupKey: []byte(gk),
+			Receiver: &pb.Receiver{GroupName: "default", Integration: "webhook"},
+		}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func TestNflogSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	writeNflogSnapshot(t, filepath.Join(dir, "a"), "{}:{alertname=\"A\"}", "{}:{alertname=\"B\"}")
+	writeNflogSnapshot(t, filepath.Join(dir, "b"), "{}:{alertname=\"A\"}")
+	writeNflogSnapshot(t, filepath.Join(dir, "empty"))
+
+	a, err := ReadNflogSnapshot(filepath.Join(dir, "a"))
+	require.NoError(t, err)
+	require.Contains(t, a, "{}:{alertname=\"A\"} default/webhook/0")
+	b, err := ReadNflogSnapshot(filepath.Join(dir, "b"))
+	require.NoError(t, err)
+	empty, err := ReadNflogSnapshot(filepath.Join(dir, "empty"))
+	require.NoError(t, err)
+	require.Empty(t, empty)
+
+	require.NoError(t, CompareNflogSnapshots(map[string]map[string]*pb.Entry{"a": a, "a2": a}))
+	require.EqualError(t, CompareNflogSnapshots(map[string]map[string]*pb.Entry{"a": a, "b": b}), "b lacks 1 of 2 notifications")
+	require.Error(t, CompareNflogSnapshots(map[string]map[string]*pb.Entry{"empty": empty}))
+}
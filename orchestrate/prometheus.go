@@ -0,0 +1,338 @@
+package orchestrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Defaults of the Prometheus stand-in, taken from Prometheus itself.
+const (
+	DefaultEvaluationInterval = 1 * time.Minute
+	DefaultResendDelay        = 1 * time.Minute
+	resolvedRetention         = 15 * time.Minute
+)
+
+// Interval is a time range relative to the start of a Prometheus stand-in.
+// A zero To means the interval never ends.
+type Interval struct {
+	From time.Duration
+	To   time.Duration
+}
+
+// Rule is an alerting rule whose expression result doesn't depend on any
+// data. It either always returns a sample, or follows a timeline.
+type Rule struct {
+	Name        string
+	For         time.Duration
+	Labels      map[string]string
+	Annotations map[string]string
+	// Timeline lists the intervals in which the expression returns a sample.
+	// If empty, the expression always returns a sample.
+	Timeline []Interval
+}
+
+func (r Rule) active(elapsed time.Duration) bool {
+	if len(r.Timeline) == 0 {
+		return true
+	}
+	for _, i := range r.Timeline {
+		if elapsed >= i.From && (i.To == 0 || elapsed < i.To) {
+			return true
+		}
+	}
+	return false
+}
+
+type ruleFile struct {
+	Groups []struct {
+		Name  string `yaml:"name"`
+		Rules []struct {
+			Alert       string            `yaml:"alert"`
+			Expr        string            `yaml:"expr"`
+			For         model.Duration    `yaml:"for"`
+			Labels      map[string]string `yaml:"labels"`
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+var constantExpr = regexp.MustCompile(`^\s*vector\(\s*[-+0-9.eE]+\s*\)\s*$`)
+
+// LoadRules reads alerting rules from a Prometheus rule file. Only constant
+// expressions of the form vector(<number>) are supported, since there is no
+// data to evaluate anything else against.
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rf ruleFile
+	if err := yaml.UnmarshalStrict(b, &rf); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	var rules []Rule
+	for _, g := range rf.Groups {
+		for _, r := range g.Rules {
+			if r.Alert == "" {
+				// Recording rules don't produce alerts.
+				continue
+			}
+			if !constantExpr.MatchString(r.Expr) {
+				return nil, fmt.Errorf("rule %s/%s: unsupported expression %q", g.Name, r.Alert, r.Expr)
+			}
+			rules = append(rules, Rule{
+				Name:        r.Alert,
+				For:         time.Duration(r.For),
+				Labels:      r.Labels,
+				Annotations: r.Annotations,
+			})
+		}
+	}
+	return rules, nil
+}
+
+type alertState int
+
+const (
+	statePending alertState = iota
+	stateFiring
+)
+
+// activeAlert mirrors the bookkeeping of rules.Alert in Prometheus.
+type activeAlert struct {
+	state      alertState
+	activeAt   time.Time
+	firedAt    time.Time
+	resolvedAt time.Time
+	lastSentAt time.Time
+	validUntil time.Time
+}
+
+// needsSending is a copy of Alert.needsSending in Prometheus.
+func (a *activeAlert) needsSending(ts time.Time, resendDelay time.Duration) bool {
+	if a.state == statePending {
+		return false
+	}
+	// If an alert has been resolved since the last send, resend it.
+	if a.resolvedAt.After(a.lastSentAt) {
+		return true
+	}
+	return a.lastSentAt.Add(resendDelay).Before(ts)
+}
+
+// TargetStats counts the notifications sent to a single Alertmanager.
+type TargetStats struct {
+	Sent      int
+	Failed    int
+	Dropped   int
+	LastError string
+}
+
+// PrometheusOptions configures a Prometheus stand-in.
+type PrometheusOptions struct {
+	Rules []Rule
+	// Targets are the base URLs of the Alertmanagers, e.g. http://localhost:9093.
+	Targets []string
+
+	EvaluationInterval time.Duration
+	ResendDelay        time.Duration
+	ExternalLabels     map[string]string
+
+	// FailureRate is the probability with which a send to a single target is
+	// dropped before it reaches the network.
+	FailureRate float64
+	Seed        int64
+
+	Clock quartz.Clock
+}
+
+// Prometheus evaluates constant alerting rules and sends the results to all
+// configured Alertmanagers. Alert state transitions, the resend delay and the
+// EndsAt calculation follow Prometheus' rule manager and notifier.
+type Prometheus struct {
+	opts  PrometheusOptions
+	clock quartz.Clock
+	start time.Time
+
+	mtx    sync.Mutex
+	rng    *rand.Rand
+	alerts map[string]*activeAlert
+	stats  map[string]*TargetStats
+}
+
+// NewPrometheus creates a Prometheus stand-in. The rule timelines start at
+// the creation time.
+func NewPrometheus(o PrometheusOptions) (*Prometheus, error) {
+	if len(o.Targets) == 0 {
+		return nil, errors.New("no alertmanager targets configured")
+	}
+	if o.EvaluationInterval <= 0 {
+		o.EvaluationInterval = DefaultEvaluationInterval
+	}
+	if o.ResendDelay <= 0 {
+		o.ResendDelay = DefaultResendDelay
+	}
+	if o.Clock == nil {
+		o.Clock = quartz.NewReal()
+	}
+	stats := map[string]*TargetStats{}
+	for _, t := range o.Targets {
+		stats[t] = &TargetStats{}
+	}
+	return &Prometheus{
+		opts:   o,
+		clock:  o.Clock,
+		start:  o.Clock.Now(),
+		rng:    rand.New(rand.NewSource(o.Seed)),
+		alerts: map[string]*activeAlert{},
+		stats:  stats,
+	}, nil
+}
+
+func (p *Prometheus) labels(r Rule) map[string]string {
+	labels := maps.Clone(p.opts.ExternalLabels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	maps.Copy(labels, r.Labels)
+	labels["alertname"] = r.Name
+	return labels
+}
+
+// Eval evaluates all rules at ts and returns the alerts which need to be
+// sent.
+func (p *Prometheus) Eval(ts time.Time) []Alert {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	elapsed := ts.Sub(p.start)
+	delta := max(p.opts.EvaluationInterval, p.opts.ResendDelay)
+
+	var send []Alert
+	for _, r := range p.opts.Rules {
+		a, ok := p.alerts[r.Name]
+		switch {
+		case r.active(elapsed) && (!ok || !a.resolvedAt.IsZero()):
+			a = &activeAlert{state: statePending, activeAt: ts}
+			p.alerts[r.Name] = a
+			ok = true
+		case !r.active(elapsed) && ok:
+			if a.state == statePending || (!a.resolvedAt.IsZero() && ts.Sub(a.resolvedAt) > resolvedRetention) {
+				delete(p.alerts, r.Name)
+				continue
+			}
+			if a.resolvedAt.IsZero() {
+				a.resolvedAt = ts
+			}
+		}
+		if !ok {
+			continue
+		}
+		if a.state == statePending && ts.Sub(a.activeAt) >= r.For {
+			a.state = stateFiring
+			a.firedAt = ts
+		}
+		if a.resolvedAt.IsZero() {
+			a.validUntil = ts.Add(4 * delta)
+		}
+
+		if !a.needsSending(ts, p.opts.ResendDelay) {
+			continue
+		}
+		a.lastSentAt = ts
+		endsAt := a.validUntil
+		if !a.resolvedAt.IsZero() {
+			endsAt = a.resolvedAt
+		}
+		send = append(send, Alert{
+			Labels:       p.labels(r),
+			Annotations:  r.Annotations,
+			StartsAt:     a.firedAt,
+			EndsAt:       endsAt,
+			GeneratorURL: "http://localhost:9090/graph",
+		})
+	}
+	return send
+}
+
+// Send delivers the alerts to every target independently. A failing target
+// doesn't affect the others and is not retried, just like in Prometheus.
+func (p *Prometheus) Send(alerts []Alert) {
+	var wg sync.WaitGroup
+	for _, target := range p.opts.Targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			p.mtx.Lock()
+			drop := p.opts.FailureRate > 0 && p.rng.Float64() < p.opts.FailureRate
+			p.mtx.Unlock()
+
+			var err error
+			if !drop {
+				err = postAlertsTo(strings.TrimSuffix(target, "/")+"/api/v2/alerts", alerts)
+			}
+
+			p.mtx.Lock()
+			defer p.mtx.Unlock()
+			st := p.stats[target]
+			switch {
+			case drop:
+				st.Dropped += len(alerts)
+				logger.Info("Dropped alerts", logKeyInstance, sourcePrometheus, logKeyEvent, "drop-alerts", "alerts", len(alerts), "target", target)
+			case err != nil:
+				st.Failed += len(alerts)
+				st.LastError = err.Error()
+				logger.Error("Error sending alerts", logKeyInstance, sourcePrometheus, logKeyEvent, "send-alerts", "alerts", len(alerts), "target", target, "err", err)
+			default:
+				st.Sent += len(alerts)
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// Stats returns the per-target delivery counters.
+func (p *Prometheus) Stats() map[string]TargetStats {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	stats := make(map[string]TargetStats, len(p.stats))
+	for t, st := range p.stats {
+		stats[t] = *st
+	}
+	return stats
+}
+
+// Run evaluates the rules every evaluation interval until ctx is done.
+func (p *Prometheus) Run(ctx context.Context) {
+	tick := p.clock.NewTicker(p.opts.EvaluationInterval)
+	defer tick.Stop()
+
+	targets := slices.Clone(p.opts.Targets)
+	logger.Info("Evaluating rules", logKeyInstance, sourcePrometheus, logKeyEvent, "start", "rules", len(p.opts.Rules), "interval", p.opts.EvaluationInterval, "targets", targets)
+
+	for {
+		if alerts := p.Eval(p.clock.Now()); len(alerts) > 0 {
+			p.Send(alerts)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
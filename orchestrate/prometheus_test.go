@@ -0,0 +1,99 @@
+package orchestrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPrometheus(t *testing.T, rules ...Rule) (*Prometheus, time.Time) {
+	t.Helper()
+	clock := quartz.NewMock(t)
+	p, err := NewPrometheus(PrometheusOptions{
+		Rules:              rules,
+		Targets:            []string{"http://localhost:0"},
+		EvaluationInterval: 15 * time.Second,
+		ResendDelay:        time.Minute,
+		Clock:              clock,
+	})
+	require.NoError(t, err)
+	return p, clock.Now()
+}
+
+func TestPrometheusResendDelay(t *testing.T) {
+	p, start := newTestPrometheus(t, Rule{Name: "AlwaysFiring"})
+
+	alerts := p.Eval(start)
+	require.Len(t, alerts, 1)
+	require.Equal(t, start, alerts[0].StartsAt)
+	// EndsAt is four times the larger of evaluation interval and resend delay.
+	require.Equal(t, start.Add(4*time.Minute), alerts[0].EndsAt)
+	require.Equal(t, "AlwaysFiring", alerts[0].Labels["alertname"])
+
+	for i := 1; i <= 4; i++ {
+		require.Empty(t, p.Eval(start.Add(time.Duration(i)*15*time.Second)), "evaluation %d", i)
+	}
+	alerts = p.Eval(start.Add(75 * time.Second))
+	require.Len(t, alerts, 1)
+	require.Equal(t, start, alerts[0].StartsAt)
+	require.Equal(t, start.Add(75*time.Second+4*time.Minute), alerts[0].EndsAt)
+}
+
+func TestPrometheusForAndResolve(t *testing.T) {
+	p, start := newTestPrometheus(t, Rule{
+		Name:     "Flapping",
+		For:      30 * time.Second,
+		Timeline: []Interval{{From: 0, To: 2 * time.Minute}},
+	})
+
+	require.Empty(t, p.Eval(start))
+	require.Empty(t, p.Eval(start.Add(15*time.Second)))
+	alerts := p.Eval(start.Add(30 * time.Second))
+	require.Len(t, alerts, 1)
+	require.Equal(t, start.Add(30*time.Second), alerts[0].StartsAt)
+
+	// The resolved alert is sent right away with EndsAt set to the resolve time.
+	resolvedAt := start.Add(2 * time.Minute)
+	alerts = p.Eval(resolvedAt)
+	require.Len(t, alerts, 1)
+	require.Equal(t, resolvedAt, alerts[0].EndsAt)
+
+	// Resolved alerts are resent until the retention expires.
+	require.Empty(t, p.Eval(resolvedAt.Add(15*time.Second)))
+	require.Len(t, p.Eval(resolvedAt.Add(75*time.Second)), 1)
+	require.Empty(t, p.Eval(resolvedAt.Add(resolvedRetention+15*time.Second)))
+	require.Empty(t, p.alerts)
+}
+
+func TestPrometheusSendFailure(t *testing.T) {
+	p, start := newTestPrometheus(t, Rule{Name: "AlwaysFiring"})
+	p.Send(p.Eval(start))
+
+	stats := p.Stats()["http://localhost:0"]
+	require.Equal(t, 0, stats.Sent)
+	require.Equal(t, 1, stats.Failed)
+	require.NotEmpty(t, stats.LastError)
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := LoadRules("../assets/prometheus_rules.yaml")
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	require.Equal(t, "AlwaysFiringTest", rules[0].Name)
+	require.Equal(t, "critical", rules[0].Labels["severity"])
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+groups:
+- name: test
+  rules:
+  - alert: Unsupported
+    expr: up == 0
+`), 0o600))
+	_, err = LoadRules(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,258 @@
+package orchestrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report is the directory a run writes its artifacts to:
+//
+//	logs/<name>.log          raw output of every process, without colours
+//	configs/<instance>.yml   rendered Alertmanager configurations
+//	snapshots/<instance>/    nflog and silence snapshots after the last stop
+//	timeline.jsonl           orchestrator actions
+//	deliveries.jsonl         journal of the alert-receiver
+//	summary.md               check results and the merged timeline
+//
+// All methods are safe to call on a nil *Report, which does nothing.
+type Report struct {
+	dir   string
+	start time.Time
+
+	mtx      sync.Mutex
+	timeline *os.File
+	events   []TimelineEvent
+	checks   []CheckResult
+	logs     []*os.File
+}
+
+// TimelineEvent is an action of the orchestrator.
+type TimelineEvent struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Event   string    `json:"event"`
+	Message string    `json:"message,omitempty"`
+}
+
+// CheckResult is the result of an assertion of the scenario.
+type CheckResult struct {
+	Time  time.Time `json:"time"`
+	Name  string    `json:"name"`
+	Error string    `json:"error,omitempty"`
+}
+
+// NewReport creates the report directory dir.
+func NewReport(dir string) (*Report, error) {
+	for _, sub := range []string{"logs", "configs", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "timeline.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	return &Report{dir: dir, start: time.Now(), timeline: f}, nil
+}
+
+// NewRunReport creates a report for a run of the named scenario below
+// $AM_REPORT_DIR, or am-reports/ in the temporary directory.
+func NewRunReport(name string) (*Report, error) {
+	base := os.Getenv("AM_REPORT_DIR")
+	if base == "" {
+		base = filepath.Join(os.TempDir(), "am-reports")
+	}
+	return NewReport(filepath.Join(base, fmt.Sprintf("%s-%s", name, time.Now().Format("20060102-150405"))))
+}
+
+// Dir returns the report directory.
+func (r *Report) Dir() string {
+	if r == nil {
+		return ""
+	}
+	return r.dir
+}
+
+// DeliveriesPath is the journal file the alert-receiver should write to.
+func (r *Report) DeliveriesPath() string {
+	if r == nil {
+		return ""
+	}
+	return filepath.Join(r.dir, "deliveries.jsonl")
+}
+
+// Event appends an orchestrator action to the timeline.
+func (r *Report) Event(source, event, format string, args ...any) {
+	if r == nil {
+		return
+	}
+	e := TimelineEvent{
+		Time:    time.Now(),
+		Source:  source,
+		Event:   event,
+		Message: fmt.Sprintf(format, args...),
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events = append(r.events, e)
+	if b, err := json.Marshal(e); err == nil {
+		r.timeline.Write(append(b, '\n'))
+	}
+}
+
+// Check records the result of an assertion and returns err.
+func (r *Report) Check(name string, err error) error {
+	if r == nil {
+		return err
+	}
+	c := CheckResult{Time: time.Now(), Name: name}
+	event := "check-passed"
+	if err != nil {
+		c.Error = err.Error()
+		event = "check-failed"
+	}
+	r.mtx.Lock()
+	r.checks = append(r.checks, c)
+	r.mtx.Unlock()
+	r.Event("scenario", event, "%s", strings.TrimSpace(name+" "+c.Error))
+	return err
+}
+
+// Failed reports whether any check failed.
+func (r *Report) Failed() bool {
+	if r == nil {
+		return false
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return slices.ContainsFunc(r.checks, func(c CheckResult) bool { return c.Error != "" })
+}
+
+// logWriter returns the log file of a process. Restarted processes append to
+// the same file.
+func (r *Report) logWriter(name string) (io.Writer, error) {
+	if r == nil {
+		return nil, nil
+	}
+	f, err := os.OpenFile(filepath.Join(r.dir, "logs", name+".log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.logs = append(r.logs, f)
+	return f, nil
+}
+
+// addConfig stores a rendered configuration.
+func (r *Report) addConfig(instance string, b []byte) error {
+	if r == nil {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(r.dir, "configs", instance+".yml"), b, 0o644)
+}
+
+// addSnapshots copies the snapshots of a stopped instance, replacing the
+// ones of an earlier stop.
+func (r *Report) addSnapshots(instance, storage string) error {
+	if r == nil {
+		return nil
+	}
+	dst := filepath.Join(r.dir, "snapshots", instance)
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range []string{"nflog", "silences"} {
+		f, err := os.Create(filepath.Join(dst, name))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = copyFile(f, filepath.Join(storage, name))
+		if errors.Is(err, os.ErrNotExist) {
+			// Nothing was written yet, keep an empty snapshot.
+			err = nil
+		}
+		errs = append(errs, err, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// SnapshotPath returns the path of the snapshot of instance, "nflog" or
+// "silences", taken at its last stop.
+func (r *Report) SnapshotPath(instance, name string) string {
+	if r == nil {
+		return ""
+	}
+	return filepath.Join(r.dir, "snapshots", instance, name)
+}
+
+// Close writes the summary and closes all files of the report.
+func (r *Report) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	errs := []error{r.writeSummary(), r.timeline.Close()}
+	for _, f := range r.logs {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// writeSummary must be called with r.mtx held.
+func (r *Report) writeSummary() error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Run %s\n\n", r.start.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "Duration: %s\n\n", time.Since(r.start).Round(time.Millisecond))
+
+	sb.WriteString("## Checks\n\n")
+	if len(r.checks) == 0 {
+		sb.WriteString("No checks were recorded.\n")
+	}
+	for _, c := range r.checks {
+		if c.Error == "" {
+			fmt.Fprintf(&sb, "- PASS %s\n", c.Name)
+		} else {
+			fmt.Fprintf(&sb, "- FAIL %s: %s\n", c.Name, c.Error)
+		}
+	}
+
+	// Merge the orchestrator actions with the deliveries.
+	events := slices.Clone(r.events)
+	ds, err := ReadDeliveries(r.DeliveriesPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		fmt.Fprintf(&sb, "\n## Deliveries\n\n%d notifications, %d duplicates\n", len(ds), len(FindDuplicates(ds, 0)))
+		for _, d := range ds {
+			events = append(events, TimelineEvent{
+				Time:    d.Time,
+				Source:  "receiver",
+				Event:   "notification",
+				Message: fmt.Sprintf("%s %s (%d alerts) from %s", d.GroupKey, d.Status, len(d.Alerts), d.ExternalURL),
+			})
+		}
+	}
+	slices.SortStableFunc(events, func(a, b TimelineEvent) int { return a.Time.Compare(b.Time) })
+
+	sb.WriteString("\n## Timeline\n\n```\n")
+	for _, e := range events {
+		fmt.Fprintf(&sb, "%10s  %-12s %-14s %s\n", "+"+e.Time.Sub(r.start).Round(time.Millisecond).String(), e.Source, e.Event, e.Message)
+	}
+	sb.WriteString("```\n")
+	return os.WriteFile(filepath.Join(r.dir, "summary.md"), []byte(sb.String()), 0o644)
+}
@@ -0,0 +1,74 @@
+package orchestrate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	r, err := NewReport(dir)
+	require.NoError(t, err)
+
+	r.Event("01-zebra", "start", "binary %s", DefaultBinary)
+	require.NoError(t, r.Check("silence gossiped", nil))
+	require.False(t, r.Failed())
+	require.Error(t, r.Check("no duplicates", errors.New("1 duplicate")))
+	require.True(t, r.Failed())
+
+	w, err := r.logWriter("01-zebra")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("level=info msg=Listening\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.addConfig("01-zebra", []byte("route: {}\n")))
+
+	storage := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(storage, "nflog"), []byte("entries"), 0o600))
+	require.NoError(t, r.addSnapshots("01-zebra", storage))
+
+	require.NoError(t, os.WriteFile(r.DeliveriesPath(), []byte(
+		`{"time":"2099-01-01T00:00:00Z","groupKey":"{}:{}","status":"firing","externalURL":"http://vm:9093","alerts":[]}`+"\n"), 0o600))
+	require.NoError(t, r.Close())
+
+	b, err := os.ReadFile(filepath.Join(dir, "logs", "01-zebra.log"))
+	require.NoError(t, err)
+	require.Equal(t, "level=info msg=Listening\n", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "snapshots", "01-zebra", "nflog"))
+	require.NoError(t, err)
+	require.Equal(t, "entries", string(b))
+	// Silences were never written, the snapshot is empty.
+	b, err = os.ReadFile(filepath.Join(dir, "snapshots", "01-zebra", "silences"))
+	require.NoError(t, err)
+	require.Empty(t, b)
+
+	b, err = os.ReadFile(filepath.Join(dir, "timeline.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), 3)
+
+	b, err = os.ReadFile(filepath.Join(dir, "summary.md"))
+	require.NoError(t, err)
+	summary := string(b)
+	require.Contains(t, summary, "- PASS silence gossiped\n")
+	require.Contains(t, summary, "- FAIL no duplicates: 1 duplicate\n")
+	require.Contains(t, summary, "1 notifications, 0 duplicates")
+	// Deliveries are merged into the timeline after the orchestrator actions.
+	timeline := summary[strings.Index(summary, "## Timeline"):]
+	require.Less(t, strings.Index(timeline, "start"), strings.Index(timeline, "notification"))
+}
+
+func TestNilReport(t *testing.T) {
+	var r *Report
+	r.Event("01-zebra", "start", "")
+	require.Error(t, r.Check("fails", errors.New("fail")))
+	require.False(t, r.Failed())
+	w, err := r.logWriter("01-zebra")
+	require.NoError(t, err)
+	require.Nil(t, w)
+	require.NoError(t, r.addSnapshots("01-zebra", t.TempDir()))
+	require.NoError(t, r.Close())
+}
@@ -0,0 +1,135 @@
+package orchestrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Scenarios are the scenarios known to the sweep runner. They use the
+// sweep setup, whose template defaults are group_wait 1s and
+// group_interval 2s.
+var Scenarios = map[string]Scenario{
+	"single-peer": {
+		Name:      "single-peer",
+		Setup:     "sweep",
+		Instances: 3,
+		Run:       runSinglePeer,
+	},
+	"all-peers": {
+		Name:      "all-peers",
+		Setup:     "sweep",
+		Instances: 3,
+		Run:       runAllPeers,
+	},
+}
+
+const sweepAlertName = "SweepAlert"
+
+// settleTime is how long a scenario waits after sending an alert until all
+// instances should have flushed their notifications. It covers the position
+// wait of the last peer and two flushes.
+func settleTime(p Point, instances int) (time.Duration, error) {
+	groupWait, err := p.Duration("group_wait", time.Second)
+	if err != nil {
+		return 0, err
+	}
+	groupInterval, err := p.Duration("group_interval", 2*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	peerTimeout, err := p.Duration("peer_timeout", DefaultTiming.PeerTimeout)
+	if err != nil {
+		return 0, err
+	}
+	delay, err := p.Duration("receiver_delay", 0)
+	if err != nil {
+		return 0, err
+	}
+	return groupWait + 2*groupInterval + time.Duration(instances-1)*peerTimeout + 2*delay + 2*time.Second, nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// fireAndResolve sends a firing alert, and after the settle time the resolved
+// alert, via send.
+func fireAndResolve(ctx context.Context, c *Cluster, p Point, send func([]Alert) error) ([]Expectation, error) {
+	settle, err := settleTime(p, len(c.Instances()))
+	if err != nil {
+		return nil, err
+	}
+	// Let the cluster form before the first alert arrives.
+	if err := sleepCtx(ctx, 3*time.Second); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	alert := Alert{
+		Labels:   map[string]string{"alertname": sweepAlertName},
+		StartsAt: now,
+		EndsAt:   now.Add(time.Hour),
+	}
+	if err := send([]Alert{alert}); err != nil {
+		return nil, err
+	}
+	if err := sleepCtx(ctx, settle); err != nil {
+		return nil, err
+	}
+	alert.EndsAt = time.Now()
+	if err := send([]Alert{alert}); err != nil {
+		return nil, err
+	}
+	if err := sleepCtx(ctx, settle); err != nil {
+		return nil, err
+	}
+	return []Expectation{
+		{AlertName: sweepAlertName, Status: "firing"},
+		{AlertName: sweepAlertName, Status: "resolved"},
+	}, nil
+}
+
+// runSinglePeer sends the alert only to the instance at position "peer"
+// (default 0), so that the other instances learn about it via gossip only.
+func runSinglePeer(ctx context.Context, c *Cluster, p Point) ([]Expectation, error) {
+	peer, err := p.Int("peer", 0)
+	if err != nil {
+		return nil, err
+	}
+	instances := c.Instances()
+	if peer < 0 || peer >= len(instances) {
+		return nil, fmt.Errorf("peer %d out of range", peer)
+	}
+	return fireAndResolve(ctx, c, p, func(alerts []Alert) error {
+		return postAlerts(alerts, instances[peer].WebPort)
+	})
+}
+
+// runAllPeers sends the alert to every instance, like Prometheus does, with
+// "skew" (default 0) between consecutive instances.
+func runAllPeers(ctx context.Context, c *Cluster, p Point) ([]Expectation, error) {
+	skew, err := p.Duration("skew", 0)
+	if err != nil {
+		return nil, err
+	}
+	instances := c.Instances()
+	return fireAndResolve(ctx, c, p, func(alerts []Alert) error {
+		for i, inst := range instances {
+			if i > 0 {
+				if err := sleepCtx(ctx, skew); err != nil {
+					return err
+				}
+			}
+			if err := postAlerts(alerts, inst.WebPort); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -4,126 +4,311 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 )
 
-// ANSI Colors for terminal output
-var colors = []string{
-	"\033[36m", // Cyan
-	"\033[32m", // Green
-	"\033[33m", // Yellow
-	"\033[35m", // Magenta
-	"\033[31m", // Red
-	"\033[34m", // Blue
-}
-
-const colorReset = "\033[0m"
-
+// Instance is a single Alertmanager of a local cluster.
 type Instance struct {
 	Name        string
 	WebPort     int
 	ClusterPort int
+	// Binary is the manifest entry the instance runs. Defaults to
+	// DefaultBinary.
+	Binary string
+	// Config overrides the configuration parameters of the cluster for this
+	// instance.
+	Config ConfigParams
+}
+
+// DefaultInstances are the instances started by StartLocalCluster.
+var DefaultInstances = []Instance{
+	{Name: "01-zebra", WebPort: 9093, ClusterPort: 9094},
+	{Name: "02-lion", WebPort: 9095, ClusterPort: 9096},
+	{Name: "03-tiger", WebPort: 9097, ClusterPort: 9098},
 }
 
-func StartReceiver() (*exec.Cmd, error) {
+func receiverBinary() (string, error) {
 	cwd, _ := os.Getwd()
 	binaryPath := filepath.Join(cwd, "bin", "alert-receiver")
 
 	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("receiver binary not found at %s", binaryPath)
+		return "", fmt.Errorf("receiver binary not found at %s", binaryPath)
+	}
+	return binaryPath, nil
+}
+
+// StartReceiver starts bin/alert-receiver with the given arguments. It
+// writes to the report of the clusters started by StartLocalCluster, if any,
+// and is stopped by FinishLocal.
+func StartReceiver(args ...string) (*exec.Cmd, error) {
+	localRun.mtx.Lock()
+	defer localRun.mtx.Unlock()
+	p, err := startReceiver(localRun.report, args...)
+	if err != nil {
+		return nil, err
+	}
+	localRun.receivers = append(localRun.receivers, p)
+	return p.cmd, nil
+}
+
+// startReceiver starts bin/alert-receiver. With a report, the receiver logs
+// to the report and writes its journal to the report's deliveries.
+func startReceiver(r *Report, args ...string) (*process, error) {
+	binaryPath, err := receiverBinary()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := r.logWriter("receiver")
+	if err != nil {
+		return nil, err
+	}
+	if r != nil {
+		args = append(args, "--journal.file="+r.DeliveriesPath())
 	}
 
-	cmd := exec.Command(binaryPath)
+	logger.Info("Starting receiver", logKeyInstance, sourceReceiver, logKeyEvent, "start", "port", 9080)
+	r.Event(sourceReceiver, "start", "port %d", 9080)
 
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
+	return startProcess(sourceReceiver, raw, binaryPath, args...)
+}
 
-	prefix := fmt.Sprintf("%s[Receiver]%s ", colors[4], colorReset)
+// process is a running child process whose output is streamed to stdout.
+type process struct {
+	cmd  *exec.Cmd
+	logs sync.WaitGroup
+	done chan struct{}
+	err  error
+}
 
-	go streamLog(prefix, stdout)
-	go streamLog(prefix, stderr)
+// startProcess runs binaryPath in the background and logs its output as the
+// given instance. If raw is not nil, the output is also written to it as is.
+func startProcess(instance string, raw io.Writer, binaryPath string, args ...string) (*process, error) {
+	p := &process{
+		cmd:  exec.Command(binaryPath, args...),
+		done: make(chan struct{}),
+	}
 
-	fmt.Printf("Starting Receiver on port %d...\n", 9080)
+	stdout, _ := p.cmd.StdoutPipe()
+	stderr, _ := p.cmd.StderrPipe()
 
-	if err := cmd.Start(); err != nil {
+	if err := p.cmd.Start(); err != nil {
 		return nil, err
 	}
-	return cmd, nil
+
+	if raw != nil {
+		raw = &lockedWriter{w: raw}
+	}
+	p.logs.Go(func() { streamLog(instance, raw, stdout) })
+	p.logs.Go(func() { streamLog(instance, raw, stderr) })
+	go func() {
+		// All output must be read before Wait closes the pipes.
+		p.logs.Wait()
+		p.err = p.cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
 }
 
-func StartLocalCluster(setupName string, count int) ([]*exec.Cmd, error) {
-	cwd, _ := os.Getwd()
-	binaryPath := filepath.Join(cwd, "bin", "alertmanager")
-	configPath := filepath.Join(cwd, "setups", setupName, "alertmanager.yml")
+// stop sends SIGTERM to the process and waits for it to exit. The process is
+// killed if it doesn't exit within timeout.
+func (p *process) stop(timeout time.Duration) error {
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	select {
+	case <-p.done:
+		return p.exitErr()
+	case <-time.After(timeout):
+	}
+	if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-p.done
+	return fmt.Errorf("process %d killed after %v", p.cmd.Process.Pid, timeout)
+}
 
-	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("binary not found at %s", binaryPath)
+// exitErr returns how the process exited, which must only be called once it
+// did. Being terminated by the SIGTERM of stop is no error, processes which
+// don't handle it exit like that.
+func (p *process) exitErr() error {
+	var ee *exec.ExitError
+	if errors.As(p.err, &ee) {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGTERM {
+			return nil
+		}
+	}
+	if p.err != nil {
+		return fmt.Errorf("process %d exited: %w", p.cmd.Process.Pid, p.err)
 	}
+	return nil
+}
+
+// Timing are the cluster timing flags of the instances. Zero values fall back
+// to DefaultTiming.
+type Timing struct {
+	GossipInterval   time.Duration
+	PushPullInterval time.Duration
+	PeerTimeout      time.Duration
+	// Latency is added to all gossip an instance receives by a proxy in
+	// front of its cluster port, see latencyProxy. Zero disables it.
+	Latency time.Duration
+}
 
-	instances := []Instance{
-		{Name: "01-zebra", WebPort: 9093, ClusterPort: 9094},
-		{Name: "02-lion", WebPort: 9095, ClusterPort: 9096},
-		{Name: "03-tiger", WebPort: 9097, ClusterPort: 9098},
+// gossipPort is the port the other instances gossip with inst on.
+func (t Timing) gossipPort(inst Instance) int {
+	if t.Latency > 0 {
+		return inst.ClusterPort + latencyPortOffset
 	}
+	return inst.ClusterPort
+}
 
-	var runningCmds []*exec.Cmd
-	bootstrapPeer := fmt.Sprintf("127.0.0.1:%d", instances[0].ClusterPort)
+// DefaultTiming gossips faster than Alertmanager's defaults, so that scenarios
+// converge quickly.
+var DefaultTiming = Timing{
+	GossipInterval:   200 * time.Millisecond,
+	PushPullInterval: time.Minute,
+	PeerTimeout:      15 * time.Second,
+}
 
-	for i := range count {
-		inst := instances[i]
-		tempStorage, err := os.MkdirTemp("", fmt.Sprintf("am-storage-%s-", inst.Name))
+func (t Timing) withDefaults() Timing {
+	if t.GossipInterval == 0 {
+		t.GossipInterval = DefaultTiming.GossipInterval
+	}
+	if t.PushPullInterval == 0 {
+		t.PushPullInterval = DefaultTiming.PushPullInterval
+	}
+	if t.PeerTimeout == 0 {
+		t.PeerTimeout = DefaultTiming.PeerTimeout
+	}
+	return t
+}
+
+// localRun is the report shared by all clusters started by
+// StartLocalCluster in this process, and what FinishLocal stops.
+var localRun struct {
+	mtx       sync.Mutex
+	report    *Report
+	clusters  []*Cluster
+	receivers []*process
+}
+
+// StartLocalCluster starts count instances of the default binary with the
+// configuration of the given setup. The instances write to a report which is
+// shared by all calls, see LocalReport. FinishLocal stops them and completes
+// the report.
+func StartLocalCluster(b *Build, setupName string, count int) (*Cluster, error) {
+	if count > len(DefaultInstances) {
+		return nil, fmt.Errorf("at most %d instances are supported", len(DefaultInstances))
+	}
+	localRun.mtx.Lock()
+	defer localRun.mtx.Unlock()
+	if localRun.report == nil {
+		r, err := NewRunReport(setupName)
 		if err != nil {
 			return nil, err
 		}
+		localRun.report = r
+	}
+	c := NewCluster(b, setupName, DefaultInstances[:count])
+	c.SetReport(localRun.report)
+	localRun.clusters = append(localRun.clusters, c)
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-		args := []string{
-			fmt.Sprintf("--config.file=%s", configPath),
-			fmt.Sprintf("--storage.path=%s", tempStorage),
-			fmt.Sprintf("--web.listen-address=127.0.0.1:%d", inst.WebPort),
-			fmt.Sprintf("--cluster.listen-address=127.0.0.1:%d", inst.ClusterPort),
-			fmt.Sprintf("--cluster.peer-name=%s", inst.Name),
-			"--cluster.gossip-interval=200ms",
-			"--cluster.pushpull-interval=1m",
-			"--log.level=info",
-		}
-
-		if i > 0 {
-			args = append(args, fmt.Sprintf("--cluster.peer=%s", bootstrapPeer))
-		}
+// LocalReport returns the report of the clusters started by
+// StartLocalCluster, which is nil before the first one was started.
+func LocalReport() *Report {
+	localRun.mtx.Lock()
+	defer localRun.mtx.Unlock()
+	return localRun.report
+}
 
-		cmd := exec.Command(binaryPath, args...)
+// FinishLocal stops the clusters started by StartLocalCluster, so that the
+// final snapshots of all instances are added to the report, and the receivers
+// started by StartReceiver. Then it writes the summary of the report.
+func FinishLocal() error {
+	localRun.mtx.Lock()
+	defer localRun.mtx.Unlock()
 
-		stdout, _ := cmd.StdoutPipe()
-		stderr, _ := cmd.StderrPipe()
+	var errs []error
+	for _, c := range localRun.clusters {
+		errs = append(errs, c.Shutdown())
+	}
+	localRun.clusters = nil
+	for _, p := range localRun.receivers {
+		localRun.report.Event(sourceReceiver, "stop", "")
+		errs = append(errs, p.stop(5*time.Second))
+	}
+	localRun.receivers = nil
+	if r := localRun.report; r != nil {
+		errs = append(errs, r.Close())
+		logger.Info("Report written", "dir", r.Dir())
+	}
+	return errors.Join(errs...)
+}
 
-		myColor := colors[i%len(colors)]
-		prefix := fmt.Sprintf("%s[%s]%s ", myColor, inst.Name, colorReset)
+// startAlertmanager starts a single Alertmanager process for inst, which
+// joins the cluster via peers.
+func startAlertmanager(inst Instance, binaryPath, configPath, storagePath, logLevel string, timing Timing, peers []string, raw io.Writer) (*process, error) {
+	timing = timing.withDefaults()
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	args := []string{
+		fmt.Sprintf("--config.file=%s", configPath),
+		fmt.Sprintf("--storage.path=%s", storagePath),
+		fmt.Sprintf("--web.listen-address=127.0.0.1:%d", inst.WebPort),
+		fmt.Sprintf("--cluster.listen-address=127.0.0.1:%d", inst.ClusterPort),
+		fmt.Sprintf("--cluster.peer-name=%s", inst.Name),
+		fmt.Sprintf("--cluster.gossip-interval=%s", timing.GossipInterval),
+		fmt.Sprintf("--cluster.pushpull-interval=%s", timing.PushPullInterval),
+		fmt.Sprintf("--cluster.peer-timeout=%s", timing.PeerTimeout),
+		fmt.Sprintf("--log.level=%s", logLevel),
+	}
+	if timing.Latency > 0 {
+		args = append(args, fmt.Sprintf("--cluster.advertise-address=127.0.0.1:%d", timing.gossipPort(inst)))
+	}
+	for _, peer := range peers {
+		args = append(args, fmt.Sprintf("--cluster.peer=%s", peer))
+	}
 
-		go streamLog(prefix, stdout)
-		go streamLog(prefix, stderr)
+	logger.Info("Starting Alertmanager", logKeyInstance, inst.Name, logKeyEvent, "start", "port", inst.WebPort)
 
-		fmt.Printf("Starting %s on port %d...\n", inst.Name, inst.WebPort)
+	return startProcess(inst.Name, raw, binaryPath, args...)
+}
 
-		if err := cmd.Start(); err != nil {
-			return nil, err
+func streamLog(instance string, raw io.Writer, rc io.ReadCloser) {
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		logger.Info(scanner.Text(), logKeyInstance, instance, logKeyEvent, eventOutput)
+		if raw != nil {
+			fmt.Fprintln(raw, scanner.Text())
 		}
-		runningCmds = append(runningCmds, cmd)
 	}
+}
 
-	return runningCmds, nil
+// lockedWriter serializes the lines of stdout and stderr.
+type lockedWriter struct {
+	mtx sync.Mutex
+	w   io.Writer
 }
 
-func streamLog(prefix string, rc io.ReadCloser) {
-	scanner := bufio.NewScanner(rc)
-	for scanner.Scan() {
-		fmt.Println(prefix + scanner.Text())
-	}
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.w.Write(b)
 }
 
 type Alert struct {
@@ -131,22 +316,37 @@ type Alert struct {
 	Annotations map[string]string `json:"annotations"`
 	StartsAt    time.Time         `json:"startsAt"`
 	EndsAt      time.Time         `json:"endsAt"`
+
+	GeneratorURL string `json:"generatorURL,omitempty"`
 }
 
-func SendAlert(payload []Alert, port int) {
-	url := fmt.Sprintf("http://localhost:%d/api/v2/alerts", port)
-	prefix := fmt.Sprintf("%s[Orchestrator]%s ", colors[5], colorReset)
+func postAlerts(payload []Alert, port int) error {
+	return postAlertsTo(fmt.Sprintf("http://localhost:%d/api/v2/alerts", port), payload)
+}
 
-	jsonBytes, _ := json.Marshal(payload)
+func postAlertsTo(url string, payload []Alert) error {
+	jsonBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
 
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonBytes))
 	if err != nil {
-		fmt.Printf("%s !!! Failed to send alert: %v\n", prefix, err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func SendAlert(payload []Alert, port int) {
+	if err := postAlerts(payload, port); err != nil {
+		logger.Error("Failed to send alert", logKeyEvent, "send-alerts", "port", port, "err", err)
 		return
 	}
-	defer resp.Body.Close() // Best practice: use defer to ensure closure
 	for _, alert := range payload {
-		name := alert.Labels["alertname"]
-		fmt.Printf("%s Alert `%s` sent to :%d \n", prefix, name, port)
+		logger.Info("Alert sent", logKeyEvent, "send-alerts", "alertname", alert.Labels["alertname"], "port", port)
 	}
 }
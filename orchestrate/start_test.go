@@ -0,0 +1,21 @@
+package orchestrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessStop(t *testing.T) {
+	// Being terminated by stop is no error.
+	p, err := startProcess("test", nil, "sleep", "10")
+	require.NoError(t, err)
+	require.NoError(t, p.stop(5*time.Second))
+
+	// A process which failed by itself reports how it exited.
+	p, err = startProcess("test", nil, "sh", "-c", "exit 3")
+	require.NoError(t, err)
+	<-p.done
+	require.ErrorContains(t, p.stop(5*time.Second), "exit status 3")
+}
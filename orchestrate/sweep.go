@@ -0,0 +1,366 @@
+package orchestrate
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Point is one combination of sweep parameters, keyed by parameter name.
+//
+// The following parameters are understood by the sweep itself; all others are
+// passed to the config template as Vars and to the scenario:
+//
+//	group_wait, group_interval, repeat_interval  config template timing knobs
+//	gossip_interval, pushpull_interval,          cluster flags of the instances
+//	peer_timeout
+//	gossip_latency                               delay of the gossip between
+//	                                             the instances
+//	receiver_delay                               response delay of the receiver
+type Point map[string]string
+
+// Duration returns the parameter name as a duration, or def if it isn't set.
+func (p Point) Duration(name string, def time.Duration) (time.Duration, error) {
+	v, ok := p[name]
+	if !ok {
+		return def, nil
+	}
+	d, err := model.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return time.Duration(d), nil
+}
+
+// Int returns the parameter name as an integer, or def if it isn't set.
+func (p Point) Int(name string, def int) (int, error) {
+	v, ok := p[name]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return i, nil
+}
+
+func (p Point) String() string {
+	var parts []string
+	for _, name := range slices.Sorted(maps.Keys(p)) {
+		parts = append(parts, name+"="+p[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+// params translates the point into the config template parameters.
+func (p Point) params() (ConfigParams, error) {
+	c := ConfigParams{Vars: map[string]string{}}
+	for name, v := range p {
+		var dst *model.Duration
+		switch name {
+		case "group_wait":
+			dst = &c.GroupWait
+		case "group_interval":
+			dst = &c.GroupInterval
+		case "repeat_interval":
+			dst = &c.RepeatInterval
+		default:
+			c.Vars[name] = v
+			continue
+		}
+		d, err := model.ParseDuration(v)
+		if err != nil {
+			return ConfigParams{}, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		*dst = d
+	}
+	return c, nil
+}
+
+// timing translates the point into the cluster flags of the instances.
+func (p Point) timing() (Timing, error) {
+	var (
+		t   Timing
+		err error
+	)
+	if t.GossipInterval, err = p.Duration("gossip_interval", 0); err != nil {
+		return Timing{}, err
+	}
+	if t.PushPullInterval, err = p.Duration("pushpull_interval", 0); err != nil {
+		return Timing{}, err
+	}
+	if t.PeerTimeout, err = p.Duration("peer_timeout", 0); err != nil {
+		return Timing{}, err
+	}
+	if t.Latency, err = p.Duration("gossip_latency", 0); err != nil {
+		return Timing{}, err
+	}
+	return t, nil
+}
+
+// Expectation is a notification a scenario expects to be delivered: at least
+// one notification with an alert of the given alertname and status.
+type Expectation struct {
+	AlertName string
+	Status    string
+}
+
+// Scenario is a timing-sensitive HA scenario which can be swept.
+type Scenario struct {
+	Name string
+	// Setup is the setup whose config template the instances use.
+	Setup string
+	// Instances is the number of instances of DefaultInstances to start.
+	Instances int
+	// Run drives a running cluster. It returns the notifications which must
+	// have been delivered once it returns.
+	Run func(ctx context.Context, c *Cluster, p Point) ([]Expectation, error)
+}
+
+// Param is a parameter of a sweep with the values to try.
+type Param struct {
+	Name   string
+	Values []string
+}
+
+// ParseParam parses a parameter in the form name=v1,v2,...
+func ParseParam(s string) (Param, error) {
+	name, values, ok := strings.Cut(s, "=")
+	if !ok || name == "" || values == "" {
+		return Param{}, fmt.Errorf("invalid parameter %q, expected name=v1,v2,...", s)
+	}
+	return Param{Name: name, Values: strings.Split(values, ",")}, nil
+}
+
+// grid returns all combinations of the parameters. The last parameter
+// varies fastest.
+func grid(params []Param) []Point {
+	points := []Point{{}}
+	for _, param := range params {
+		var next []Point
+		for _, p := range points {
+			for _, v := range param.Values {
+				q := maps.Clone(p)
+				q[param.Name] = v
+				next = append(next, q)
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// Result is the outcome of all runs of a point.
+type Result struct {
+	Point Point `json:"point"`
+	Runs  int   `json:"runs"`
+	// Errors counts runs which failed to execute and aren't included in the
+	// rates.
+	Errors int `json:"errors"`
+	// DuplicateRuns and MissingRuns count the runs with at least one
+	// duplicate or missing notification.
+	DuplicateRuns int     `json:"duplicate_runs"`
+	MissingRuns   int     `json:"missing_runs"`
+	DuplicateRate float64 `json:"duplicate_rate"`
+	MissingRate   float64 `json:"missing_rate"`
+	// Duplicates and Missing are the totals over all runs.
+	Duplicates int `json:"duplicates"`
+	Missing    int `json:"missing"`
+}
+
+// Sweep runs a scenario for every combination of its parameters.
+type Sweep struct {
+	Scenario Scenario
+	Params   []Param
+	// Runs is the number of runs per point.
+	Runs int
+	// Dir holds the reports of all runs.
+	Dir string
+}
+
+// Run executes the sweep. Runs are sequential, as every run uses the ports of
+// DefaultInstances and the receiver.
+func (s *Sweep) Run(ctx context.Context, b *Build) ([]Result, error) {
+	if s.Scenario.Instances > len(DefaultInstances) {
+		return nil, fmt.Errorf("at most %d instances are supported", len(DefaultInstances))
+	}
+	if s.Runs < 1 {
+		return nil, fmt.Errorf("runs must be positive, got %d", s.Runs)
+	}
+	points := grid(s.Params)
+	results := make([]Result, 0, len(points))
+	for i, p := range points {
+		r := Result{Point: p, Runs: s.Runs}
+		for run := range s.Runs {
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			logger.Info("Starting sweep run", logKeyEvent, "sweep", "scenario", s.Scenario.Name, "point", fmt.Sprintf("%d/%d", i+1, len(points)), "params", p.String(), "run", fmt.Sprintf("%d/%d", run+1, s.Runs))
+			dir := filepath.Join(s.Dir, fmt.Sprintf("point-%03d-run-%03d", i, run))
+			dups, missing, err := s.runOnce(ctx, b, p, dir)
+			if err != nil {
+				logger.Error("Sweep run failed", logKeyEvent, "sweep", "scenario", s.Scenario.Name, "err", err)
+				r.Errors++
+				continue
+			}
+			r.Duplicates += dups
+			r.Missing += missing
+			if dups > 0 {
+				r.DuplicateRuns++
+			}
+			if missing > 0 {
+				r.MissingRuns++
+			}
+		}
+		if ok := r.Runs - r.Errors; ok > 0 {
+			r.DuplicateRate = float64(r.DuplicateRuns) / float64(ok)
+			r.MissingRate = float64(r.MissingRuns) / float64(ok)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// runOnce runs the scenario on a fresh cluster, writes a report to dir and
+// returns the number of duplicate and missing notifications.
+func (s *Sweep) runOnce(ctx context.Context, b *Build, p Point, dir string) (int, int, error) {
+	params, err := p.params()
+	if err != nil {
+		return 0, 0, err
+	}
+	timing, err := p.timing()
+	if err != nil {
+		return 0, 0, err
+	}
+	delay, err := p.Duration("receiver_delay", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	repeat, err := p.Duration("repeat_interval", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	r, err := NewReport(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+	r.Event("sweep", "point", "%s", p)
+
+	c := NewCluster(b, s.Scenario.Setup, DefaultInstances[:s.Scenario.Instances])
+	c.SetParams(params)
+	c.SetTiming(timing)
+	c.SetReport(r)
+	c.SetLogLevel("debug")
+	defer c.Shutdown()
+	if err := c.StartReceiver(fmt.Sprintf("--response.delay=%s", delay)); err != nil {
+		return 0, 0, err
+	}
+	if err := c.Start(); err != nil {
+		return 0, 0, err
+	}
+
+	expected, err := s.Scenario.Run(ctx, c, p)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := c.Shutdown(); err != nil {
+		return 0, 0, err
+	}
+
+	ds, err := ReadDeliveries(r.DeliveriesPath())
+	if err != nil {
+		return 0, 0, err
+	}
+	dups := FindDuplicates(ds, repeat)
+	missing := missingDeliveries(ds, expected)
+	for _, d := range dups {
+		logger.Warn("Duplicate notification", logKeyEvent, "duplicate",
+			logKeyGroupKey, d.Repeat.GroupKey, logKeyReceiver, d.Repeat.Receiver, logKeyStatus, d.Repeat.Status,
+			"first", d.First.ExternalURL, "repeat", d.Repeat.ExternalURL)
+	}
+	for _, e := range missing {
+		logger.Warn("Missing notification", logKeyEvent, "missing", "alertname", e.AlertName, logKeyStatus, e.Status)
+	}
+	r.Check("no duplicate notifications", countError(len(dups), "duplicate notifications"))
+	r.Check("no missing notifications", countError(len(missing), "missing notifications"))
+	return len(dups), len(missing), nil
+}
+
+func countError(n int, what string) error {
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d %s", n, what)
+}
+
+// missingDeliveries returns the expectations which no delivery satisfies.
+func missingDeliveries(ds []Delivery, expected []Expectation) []Expectation {
+	seen := map[Expectation]bool{}
+	for _, d := range ds {
+		for _, a := range d.Alerts {
+			seen[Expectation{AlertName: a.Labels["alertname"], Status: a.Status}] = true
+		}
+	}
+	var missing []Expectation
+	for _, e := range expected {
+		if !seen[e] {
+			missing = append(missing, e)
+		}
+	}
+	return missing
+}
+
+// WriteCSV writes one row per result. The parameters come first, in the
+// order of params.
+func WriteCSV(w io.Writer, params []Param, results []Result) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, 0, len(params)+8)
+	for _, p := range params {
+		header = append(header, p.Name)
+	}
+	header = append(header, "runs", "errors", "duplicate_runs", "missing_runs", "duplicate_rate", "missing_rate", "duplicates", "missing")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range results {
+		row := make([]string, 0, len(header))
+		for _, p := range params {
+			row = append(row, r.Point[p.Name])
+		}
+		row = append(row,
+			strconv.Itoa(r.Runs),
+			strconv.Itoa(r.Errors),
+			strconv.Itoa(r.DuplicateRuns),
+			strconv.Itoa(r.MissingRuns),
+			strconv.FormatFloat(r.DuplicateRate, 'f', 3, 64),
+			strconv.FormatFloat(r.MissingRate, 'f', 3, 64),
+			strconv.Itoa(r.Duplicates),
+			strconv.Itoa(r.Missing),
+		)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the results as a JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
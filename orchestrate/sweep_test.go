@@ -0,0 +1,80 @@
+package orchestrate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrid(t *testing.T) {
+	a, err := ParseParam("group_interval=2s,30s")
+	require.NoError(t, err)
+	b, err := ParseParam("peer=0,1,2")
+	require.NoError(t, err)
+
+	points := grid([]Param{a, b})
+	require.Len(t, points, 6)
+	require.Equal(t, Point{"group_interval": "2s", "peer": "0"}, points[0])
+	require.Equal(t, Point{"group_interval": "2s", "peer": "1"}, points[1])
+	require.Equal(t, Point{"group_interval": "30s", "peer": "2"}, points[5])
+
+	require.Equal(t, []Point{{}}, grid(nil))
+
+	_, err = ParseParam("peer")
+	require.Error(t, err)
+}
+
+func TestPointParams(t *testing.T) {
+	p := Point{
+		"group_interval":  "30s",
+		"gossip_interval": "1s",
+		"peer_timeout":    "5s",
+		"gossip_latency":  "50ms",
+		"skew":            "100ms",
+	}
+	params, err := p.params()
+	require.NoError(t, err)
+	require.Equal(t, model.Duration(30*time.Second), params.GroupInterval)
+	require.Zero(t, params.GroupWait)
+	require.Equal(t, "100ms", params.Vars["skew"])
+
+	timing, err := p.timing()
+	require.NoError(t, err)
+	require.Equal(t, Timing{GossipInterval: time.Second, PeerTimeout: 5 * time.Second, Latency: 50 * time.Millisecond}, timing)
+	require.Equal(t, DefaultTiming.PushPullInterval, timing.withDefaults().PushPullInterval)
+
+	_, err = Point{"group_wait": "soon"}.params()
+	require.Error(t, err)
+}
+
+func TestMissingDeliveries(t *testing.T) {
+	ds := []Delivery{{
+		Status: "firing",
+		Alerts: []DeliveredAlert{{Status: "firing", Labels: map[string]string{"alertname": "A"}}},
+	}}
+	expected := []Expectation{
+		{AlertName: "A", Status: "firing"},
+		{AlertName: "A", Status: "resolved"},
+	}
+	require.Equal(t, expected[1:], missingDeliveries(ds, expected))
+	require.Equal(t, expected, missingDeliveries(nil, expected))
+}
+
+func TestWriteCSV(t *testing.T) {
+	params := []Param{{Name: "group_interval"}, {Name: "peer"}}
+	results := []Result{{
+		Point:         Point{"group_interval": "2s", "peer": "1"},
+		Runs:          4,
+		DuplicateRuns: 1,
+		DuplicateRate: 0.25,
+		Duplicates:    2,
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, params, results))
+	require.Equal(t, "group_interval,peer,runs,errors,duplicate_runs,missing_runs,duplicate_rate,missing_rate,duplicates,missing\n"+
+		"2s,1,4,0,1,0,0.250,0.000,2,0\n", buf.String())
+}
@@ -0,0 +1,277 @@
+package orchestrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Field is a key-value pair of a log line.
+type Field struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// LogEntry is a single line of the merged timeline of a run.
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Level   string    `json:"level,omitempty"`
+	Message string    `json:"msg"`
+	Fields  []Field   `json:"fields,omitempty"`
+}
+
+// Field returns the value of the first field with the given key.
+func (e LogEntry) Field(key string) (string, bool) {
+	for _, f := range e.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// groupKeyFields are the fields which hold a group key: Alertmanager logs it
+// as aggrGroup or group_key, the receiver journal as groupKey.
+var groupKeyFields = []string{"aggrGroup", "group_key", "groupKey"}
+
+// TimelineFilter selects entries of the timeline. Empty fields match
+// everything.
+type TimelineFilter struct {
+	GroupKey  string
+	AlertName string
+	Sources   []string
+}
+
+// Match reports whether e passes the filter.
+func (f TimelineFilter) Match(e LogEntry) bool {
+	if len(f.Sources) > 0 && !slices.Contains(f.Sources, e.Source) {
+		return false
+	}
+	if f.GroupKey != "" && !slices.ContainsFunc(groupKeyFields, func(k string) bool {
+		v, _ := e.Field(k)
+		return v == f.GroupKey
+	}) {
+		return false
+	}
+	if f.AlertName != "" {
+		// The alertname is part of group keys, label sets and alert lists.
+		quoted := fmt.Sprintf("alertname=%q", f.AlertName)
+		return slices.ContainsFunc(e.Fields, func(fl Field) bool {
+			return (fl.Key == "alertname" && fl.Value == f.AlertName) || strings.Contains(fl.Value, quoted)
+		})
+	}
+	return true
+}
+
+// ReadTimeline reads the logs of a run and merges them into a single timeline
+// sorted by time. Each path is either a report directory, a log file of
+// Alertmanager in logfmt or JSON, a receiver journal or an orchestrator
+// timeline. The source of a log file is its name without extension. Lines
+// without a timestamp are skipped.
+func ReadTimeline(paths ...string) ([]LogEntry, error) {
+	var entries []LogEntry
+	for _, path := range paths {
+		files, err := timelineFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			es, err := readLogFile(file)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, es...)
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b LogEntry) int { return a.Time.Compare(b.Time) })
+	return entries, nil
+}
+
+// timelineFiles expands a report directory into its log files.
+func timelineFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(path, "logs", "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"timeline.jsonl", "deliveries.jsonl"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err == nil {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	return files, nil
+}
+
+func readLogFile(path string) ([]LogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	source := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var parse func(source string, line []byte) (LogEntry, bool)
+	switch filepath.Base(path) {
+	case "deliveries.jsonl":
+		parse = parseDelivery
+	case "timeline.jsonl":
+		parse = parseTimelineEvent
+	default:
+		parse = parseLogLine
+	}
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		if e, ok := parse(source, scanner.Bytes()); ok {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// parseLogLine parses a line of Alertmanager's logfmt or JSON output.
+func parseLogLine(source string, line []byte) (LogEntry, bool) {
+	var fields []Field
+	if bytes.HasPrefix(bytes.TrimSpace(line), []byte("{")) {
+		var m map[string]any
+		if err := json.Unmarshal(line, &m); err != nil {
+			return LogEntry{}, false
+		}
+		for k, v := range m {
+			s, ok := v.(string)
+			if !ok {
+				b, _ := json.Marshal(v)
+				s = string(b)
+			}
+			fields = append(fields, Field{Key: k, Value: s})
+		}
+		slices.SortFunc(fields, func(a, b Field) int { return strings.Compare(a.Key, b.Key) })
+	} else {
+		d := logfmt.NewDecoder(bytes.NewReader(line))
+		for d.ScanRecord() {
+			for d.ScanKeyval() {
+				fields = append(fields, Field{Key: string(d.Key()), Value: string(d.Value())})
+			}
+		}
+		if d.Err() != nil {
+			return LogEntry{}, false
+		}
+	}
+
+	e := LogEntry{Source: source}
+	var ok bool
+	for _, f := range fields {
+		switch f.Key {
+		case "time", "ts":
+			t, err := time.Parse(time.RFC3339Nano, f.Value)
+			if err != nil {
+				return LogEntry{}, false
+			}
+			e.Time, ok = t, true
+		case "level":
+			e.Level = f.Value
+		case "msg":
+			e.Message = f.Value
+		default:
+			e.Fields = append(e.Fields, f)
+		}
+	}
+	return e, ok
+}
+
+func parseDelivery(_ string, line []byte) (LogEntry, bool) {
+	var d Delivery
+	if err := json.Unmarshal(line, &d); err != nil {
+		return LogEntry{}, false
+	}
+	alerts := make([]string, 0, len(d.Alerts))
+	for _, a := range d.Alerts {
+		ls := make(model.LabelSet, len(a.Labels))
+		for name, value := range a.Labels {
+			ls[model.LabelName(name)] = model.LabelValue(value)
+		}
+		alerts = append(alerts, ls.String()+"/"+a.Status)
+	}
+	return LogEntry{
+		Time:    d.Time,
+		Source:  "receiver",
+		Message: "Notification received",
+		Fields: []Field{
+			{Key: "groupKey", Value: d.GroupKey},
+			{Key: "status", Value: d.Status},
+			{Key: "receiver", Value: d.Receiver},
+			{Key: "externalURL", Value: d.ExternalURL},
+			{Key: "alerts", Value: "[" + strings.Join(alerts, " ") + "]"},
+		},
+	}, true
+}
+
+func parseTimelineEvent(_ string, line []byte) (LogEntry, bool) {
+	var e TimelineEvent
+	if err := json.Unmarshal(line, &e); err != nil {
+		return LogEntry{}, false
+	}
+	entry := LogEntry{Time: e.Time, Source: e.Source, Message: e.Event}
+	if e.Message != "" {
+		entry.Fields = []Field{{Key: "details", Value: e.Message}}
+	}
+	return entry, true
+}
+
+// WriteTimeline writes one line per entry with the offset to the previous
+// entry, so that the gaps between the actions of different processes stand
+// out.
+func WriteTimeline(w io.Writer, entries []LogEntry) error {
+	width := 0
+	for _, e := range entries {
+		width = max(width, len(e.Source))
+	}
+	var prev time.Time
+	for i, e := range entries {
+		delta := ""
+		if i > 0 {
+			delta = "+" + e.Time.Sub(prev).Round(time.Millisecond).String()
+		}
+		prev = e.Time
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s %9s %-*s %s", e.Time.UTC().Format("15:04:05.000"), delta, width, e.Source, e.Message)
+		for _, f := range e.Fields {
+			if f.Key == "source" {
+				// The Go source location of the log call.
+				continue
+			}
+			v := f.Value
+			if v == "" || strings.ContainsAny(v, " =\"") {
+				v = fmt.Sprintf("%q", v)
+			}
+			fmt.Fprintf(&sb, " %s=%s", f.Key, v)
+		}
+		if _, err := fmt.Fprintln(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
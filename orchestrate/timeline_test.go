@@ -0,0 +1,70 @@
+package orchestrate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTimeline(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "logs"), 0o755))
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	write("logs/01-zebra.log", `time=2026-01-01T00:00:01.000Z level=DEBUG source=dispatch.go:1 msg=flushing component=dispatcher aggrGroup="{}:{alertname=\"A\"}"
+time=2026-01-01T00:00:03.000Z level=DEBUG msg=flushing aggrGroup="{}:{alertname=\"B\"}"
+`)
+	write("logs/02-lion.log", `{"time":"2026-01-01T00:00:01.180Z","level":"DEBUG","msg":"gossip received","aggrGroup":"{}:{alertname=\"A\"}","n":2}
+not a log line
+`)
+	write("logs/receiver.log", "2026/01/01 00:00:00 Listening for alerts on :9080/alerts...\n")
+	write("deliveries.jsonl", `{"time":"2026-01-01T00:00:02Z","groupKey":"{}:{alertname=\"A\"}","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"A"}}]}
+`)
+	write("timeline.jsonl", `{"time":"2026-01-01T00:00:00Z","source":"01-zebra","event":"start","message":"binary alertmanager"}
+`)
+
+	entries, err := ReadTimeline(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+
+	var order []string
+	for _, e := range entries {
+		order = append(order, e.Source+":"+e.Message)
+	}
+	require.Equal(t, []string{
+		"01-zebra:start",
+		"01-zebra:flushing",
+		"02-lion:gossip received",
+		"receiver:Notification received",
+		"01-zebra:flushing",
+	}, order)
+	n, ok := entries[2].Field("n")
+	require.True(t, ok)
+	require.Equal(t, "2", n)
+	require.Equal(t, "DEBUG", entries[1].Level)
+
+	var selected []LogEntry
+	f := TimelineFilter{GroupKey: `{}:{alertname="A"}`}
+	for _, e := range entries {
+		if f.Match(e) {
+			selected = append(selected, e)
+		}
+	}
+	require.Len(t, selected, 3)
+	require.True(t, TimelineFilter{AlertName: "B"}.Match(entries[4]))
+	require.False(t, TimelineFilter{AlertName: "B"}.Match(entries[1]))
+	require.False(t, TimelineFilter{Sources: []string{"receiver"}}.Match(entries[1]))
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteTimeline(&buf, selected))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	// The gap between the flush of 01-zebra and the gossip of 02-lion.
+	require.Contains(t, lines[1], "+180ms 02-lion")
+	require.NotContains(t, lines[0], "dispatch.go")
+}
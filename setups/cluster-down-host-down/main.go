@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/SoloJacobs/am/orchestrate"
@@ -35,7 +40,15 @@ func createPayload(now time.Time) []orchestrate.Alert {
 }
 
 func main() {
-	_, err := orchestrate.StartLocalCluster("cluster-down-host-down", 2)
+	// The setup runs until it is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		panic(err)
+	}
+	c, err := orchestrate.StartLocalCluster(b, "cluster-down-host-down", 2)
 	if err != nil {
 		panic(err)
 	}
@@ -43,11 +56,27 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	time.Sleep(3 * time.Second)
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	_, err = c.WaitConverged(waitCtx)
+	cancel()
+	orchestrate.LocalReport().Check("cluster converged", err)
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+loop:
 	for {
 		t := time.Now()
 		orchestrate.SendAlert(createPayload(t), 9093)
 		orchestrate.SendAlert(createPayload(t), 9095)
-		time.Sleep(1 * time.Minute)
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-tick.C:
+		}
+	}
+	if err := orchestrate.FinishLocal(); err != nil {
+		fmt.Println(err)
+	}
+	if orchestrate.LocalReport().Failed() {
+		os.Exit(1)
 	}
 }
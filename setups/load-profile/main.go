@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/SoloJacobs/am/orchestrate"
+)
+
+func main() {
+	// The setup runs until it is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		panic(err)
+	}
+	c, err := orchestrate.StartLocalCluster(b, "load-profile", 3)
+	if err != nil {
+		panic(err)
+	}
+	_, err = orchestrate.StartReceiver()
+	if err != nil {
+		panic(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	_, err = c.WaitConverged(waitCtx)
+	cancel()
+	orchestrate.LocalReport().Check("cluster converged", err)
+
+	gen, err := orchestrate.NewGenerator(orchestrate.LoadProfile{
+		Alerts: 5000,
+		Labels: map[string]int{
+			"job":      10,
+			"instance": 500,
+		},
+		Rate:           1000,
+		BatchSize:      100,
+		Stagger:        30 * time.Second,
+		FlapRatio:      0.2,
+		FlapInterval:   20 * time.Second,
+		ResolveTimeout: 5 * time.Minute,
+		PeerSkew:       500 * time.Millisecond,
+	}, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	err = gen.Run(ctx, []int{9093, 9095, 9097})
+	orchestrate.LocalReport().Check("alerts sent", err)
+	if err := orchestrate.FinishLocal(); err != nil {
+		fmt.Println(err)
+	}
+	if orchestrate.LocalReport().Failed() {
+		os.Exit(1)
+	}
+}
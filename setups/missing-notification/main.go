@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/SoloJacobs/am/orchestrate"
 )
 
 func main() {
-	_, err := orchestrate.StartLocalCluster("missing-notification", 2)
+	// The setup runs until it is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		panic(err)
+	}
+	c, err := orchestrate.StartLocalCluster(b, "missing-notification", 2)
 	if err != nil {
 		panic(err)
 	}
@@ -15,7 +28,13 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	time.Sleep(3 * time.Second)
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	_, err = c.WaitConverged(waitCtx)
+	cancel()
+	orchestrate.LocalReport().Check("cluster converged", err)
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+loop:
 	for {
 		now := time.Now()
 		clusterPayload := []orchestrate.Alert{
@@ -46,6 +65,16 @@ func main() {
 		orchestrate.SendAlert(clusterPayload, 9093)
 		orchestrate.SendAlert(hostPayload, 9093)
 		orchestrate.SendAlert(hostPayload, 9095)
-		time.Sleep(1 * time.Minute)
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-tick.C:
+		}
+	}
+	if err := orchestrate.FinishLocal(); err != nil {
+		fmt.Println(err)
+	}
+	if orchestrate.LocalReport().Failed() {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/SoloJacobs/am/orchestrate"
+)
+
+func main() {
+	// The setup runs until it is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		panic(err)
+	}
+	c, err := orchestrate.StartLocalCluster(b, "prometheus-in-the-loop", 2)
+	if err != nil {
+		panic(err)
+	}
+	_, err = orchestrate.StartReceiver()
+	if err != nil {
+		panic(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	_, err = c.WaitConverged(waitCtx)
+	cancel()
+	orchestrate.LocalReport().Check("cluster converged", err)
+
+	rules, err := orchestrate.LoadRules("assets/prometheus_rules.yaml")
+	if err != nil {
+		panic(err)
+	}
+	prom, err := orchestrate.NewPrometheus(orchestrate.PrometheusOptions{
+		Rules: rules,
+		Targets: []string{
+			"http://localhost:9093",
+			"http://localhost:9095",
+		},
+		EvaluationInterval: 15 * time.Second,
+	})
+	if err != nil {
+		panic(err)
+	}
+	prom.Run(ctx)
+	if err := orchestrate.FinishLocal(); err != nil {
+		fmt.Println(err)
+	}
+	if orchestrate.LocalReport().Failed() {
+		os.Exit(1)
+	}
+}
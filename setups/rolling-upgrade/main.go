@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/prometheus/alertmanager/nflog/nflogpb"
+
+	"github.com/SoloJacobs/am/orchestrate"
+)
+
+const (
+	oldBinary = "am_v0_31_0"
+	newBinary = "am_proto"
+)
+
+// silenceEverywhere checks that every instance knows the silence.
+func silenceEverywhere(instances []orchestrate.Instance, id string) func() error {
+	return func() error {
+		for _, inst := range instances {
+			sils, err := orchestrate.ListSilences(inst.WebPort)
+			if err != nil {
+				return err
+			}
+			if !slices.ContainsFunc(sils, func(s orchestrate.Silence) bool { return s.ID == id }) {
+				return fmt.Errorf("silence %s missing on %s (%s)", id, inst.Name, inst.Binary)
+			}
+		}
+		return nil
+	}
+}
+
+func main() {
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		panic(err)
+	}
+	r, err := orchestrate.NewRunReport("rolling-upgrade")
+	if err != nil {
+		panic(err)
+	}
+	instances := slices.Clone(orchestrate.DefaultInstances)
+	for i := range instances {
+		instances[i].Binary = oldBinary
+	}
+	c := orchestrate.NewCluster(b, "rolling-upgrade", instances)
+	c.SetReport(r)
+	if err := c.StartReceiver(); err != nil {
+		panic(err)
+	}
+	if err := c.Start(); err != nil {
+		panic(err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	_, err = c.WaitConverged(waitCtx)
+	waitCancel()
+	// The old version doesn't report the digest of the notification log, it
+	// is compared through the snapshots after the upgrade.
+	if errors.Is(err, orchestrate.ErrUnknownStates) {
+		err = nil
+	}
+	r.Check("cluster formed", err)
+
+	ports := make([]int, 0, len(instances))
+	for _, inst := range instances {
+		ports = append(ports, inst.WebPort)
+	}
+	// New alerts keep appearing throughout the upgrade.
+	gen, err := orchestrate.NewGenerator(orchestrate.LoadProfile{
+		Alerts:    60,
+		Labels:    map[string]int{"instance": 60},
+		Rate:      6,
+		BatchSize: 60,
+		Stagger:   2 * time.Minute,
+	}, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = gen.Run(ctx, ports)
+		close(done)
+	}()
+
+	id, err := orchestrate.CreateSilence(instances[0].WebPort, map[string]string{"alertname": "Silenced"}, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	r.Event("scenario", "create-silence", "%s on %s", id, instances[0].Name)
+	r.Check("initial silence gossiped", orchestrate.WaitFor(10*time.Second, silenceEverywhere(instances, id)))
+	time.Sleep(20 * time.Second)
+
+	// Silences created on an upgraded instance must reach the old ones.
+	err = c.RollingUpgrade(ctx, newBinary, 20*time.Second, func(inst orchestrate.Instance) error {
+		id, err := orchestrate.CreateSilence(inst.WebPort, map[string]string{"alertname": "Silenced", "upgraded": inst.Name}, time.Hour)
+		if err != nil {
+			return err
+		}
+		r.Event("scenario", "create-silence", "%s on %s", id, inst.Name)
+		r.Check("silence of "+inst.Name+" gossiped", orchestrate.WaitFor(10*time.Second, silenceEverywhere(c.Instances(), id)))
+		return nil
+	})
+	r.Check("rolling upgrade", err)
+	time.Sleep(20 * time.Second)
+	cancel()
+	<-done
+
+	for _, inst := range c.Instances() {
+		r.Check(inst.Name+" sees all peers", orchestrate.WaitFor(10*time.Second, func() error {
+			status, err := orchestrate.GetClusterStatus(inst.WebPort)
+			if err != nil {
+				return err
+			}
+			if len(status.Peers) != len(instances) {
+				return fmt.Errorf("%s sees %d peers, expected %d", inst.Name, len(status.Peers), len(instances))
+			}
+			return nil
+		}))
+	}
+
+	if err := c.Shutdown(); err != nil {
+		fmt.Println(err)
+	}
+	// The nflog entries written by either version reached all instances.
+	snapshots := map[string]map[string]*nflogpb.Entry{}
+	var snapErr error
+	for _, inst := range c.Instances() {
+		entries, err := orchestrate.ReadNflogSnapshot(r.SnapshotPath(inst.Name, "nflog"))
+		snapErr = errors.Join(snapErr, err)
+		snapshots[inst.Name] = entries
+	}
+	if snapErr == nil {
+		snapErr = orchestrate.CompareNflogSnapshots(snapshots)
+	}
+	r.Check("nflog gossiped between versions", snapErr)
+	ds, err := orchestrate.ReadDeliveries(r.DeliveriesPath())
+	if err != nil {
+		panic(err)
+	}
+	var dupErr error
+	if dups := orchestrate.FindDuplicates(ds, 24*time.Hour); len(dups) > 0 {
+		dupErr = fmt.Errorf("%d duplicates, first: %s", len(dups), dups[0])
+	}
+	r.Check("no duplicate notifications", dupErr)
+
+	if err := r.Close(); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Printf("%d notifications received, report at %s\n", len(ds), r.Dir())
+	if r.Failed() {
+		fmt.Println("Some checks failed.")
+		os.Exit(1)
+	}
+	fmt.Println("All checks passed.")
+}
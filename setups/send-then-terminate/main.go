@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/SoloJacobs/am/orchestrate"
 )
 
 func main() {
-	_, err := orchestrate.StartLocalCluster("send-then-terminate", 1)
+	// The setup runs until it is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b, err := orchestrate.NewBuild()
+	if err != nil {
+		panic(err)
+	}
+	c, err := orchestrate.StartLocalCluster(b, "send-then-terminate", 1)
 	if err != nil {
 		panic(err)
 	}
@@ -15,7 +28,10 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	time.Sleep(3 * time.Second)
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	_, err = c.WaitConverged(waitCtx)
+	cancel()
+	orchestrate.LocalReport().Check("cluster converged", err)
 	now := time.Now()
 	hostPayload := []orchestrate.Alert{{
 		Labels: map[string]string{
@@ -30,9 +46,15 @@ func main() {
 	}}
 	orchestrate.SendAlert(hostPayload, 9093)
 	orchestrate.KillProcByPort(9093)
-	_, err = orchestrate.StartLocalCluster("send-then-terminate", 1)
+	_, err = orchestrate.StartLocalCluster(b, "send-then-terminate", 1)
 	if err != nil {
 		panic(err)
 	}
-	select {}
+	<-ctx.Done()
+	if err := orchestrate.FinishLocal(); err != nil {
+		fmt.Println(err)
+	}
+	if orchestrate.LocalReport().Failed() {
+		os.Exit(1)
+	}
 }